@@ -23,6 +23,7 @@ func main() {
 	defer ssh.CloseAll()
 
 	if err := cmd.Execute(); err != nil {
-		os.Exit(1)
+		ssh.CloseAll()
+		os.Exit(cmd.ExitCode(err))
 	}
 }
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/engine"
+	"github.com/AhmedAburady/rcm-go/internal/tui/views"
+)
+
+var addCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Add a service to the local Caddyfile",
+	Long: `Append a site block for a new service to the local Caddyfile,
+with its "# name: local_addr" comment and a reverse_proxy to the next
+free VPS port. With --client, the comment is "# name@client: local_addr"
+and the service runs on that client instead of the first one.
+
+The block comes from a site template. Besides the built-in "default",
+a <name>.caddy.tmpl file in the templates directory next to the config
+file can be used; it gets .Name, .LocalAddr, .Domains, .Port, .Upstream,
+.HTTPSBackend and .In n (n levels of the Caddyfile's indentation).
+
+Nothing is deployed unless --sync is given.`,
+	Example: `  rcm add ha --local 192.168.1.10:8123 --domain ha.example.com
+  rcm add portainer --local 192.168.1.50:9443 --domain portainer.example.com --https-backend --sync
+  rcm add plex --local 192.168.1.20:32400 --domain plex.example.com --client nas`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAdd,
+}
+
+var (
+	addLocal        string
+	addDomains      []string
+	addHTTPSBackend bool
+	addTemplate     string
+	addClient       string
+	addSync         bool
+	addPlain        bool
+)
+
+func init() {
+	rootCmd.AddCommand(addCmd)
+	addCmd.Flags().StringVar(&addLocal, "local", "", "Address of the service on the home network (host:port)")
+	addCmd.Flags().StringSliceVar(&addDomains, "domain", nil, "Domain to serve the service on (repeatable)")
+	addCmd.Flags().BoolVar(&addHTTPSBackend, "https-backend", false, "The service speaks HTTPS with a self-signed certificate")
+	addCmd.Flags().StringVar(&addTemplate, "template", "default", "Site template to use")
+	addCmd.Flags().StringVar(&addClient, "client", "", "Client the service runs on, from clients: in config.yaml")
+	addCmd.Flags().BoolVar(&addSync, "sync", false, "Sync straight after adding")
+	addCmd.Flags().BoolVarP(&addPlain, "plain", "p", false, "Plain text sync output (no TUI)")
+	addCmd.MarkFlagRequired("local")
+	addCmd.MarkFlagRequired("domain")
+}
+
+func runAdd(cmd *cobra.Command, args []string) error {
+	if configErr != nil {
+		return configErr
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	svc, err := engine.AddService(cfg, engine.NewService{
+		Name:         args[0],
+		LocalAddr:    addLocal,
+		Domains:      addDomains,
+		HTTPSBackend: addHTTPSBackend,
+		Template:     addTemplate,
+		Client:       addClient,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Added %s (%s → VPS port %d) to %s\n", svc.Name, svc.DomainsString(), svc.VPSPort, cfg.Paths.Caddyfile)
+	return syncAfterEdit(cfg, addSync, addPlain, false)
+}
+
+// syncAfterEdit deploys the edited Caddyfile when asked to, or says how to.
+// yes skips the plain sync's confirmation before services are removed.
+func syncAfterEdit(cfg *config.Config, sync, plain, yes bool) error {
+	if !sync {
+		fmt.Println("Run rcm sync to deploy it.")
+		return nil
+	}
+	if plain {
+		fmt.Println()
+		return runSyncPlain(cfg, yes)
+	}
+	return runTUI(cfg, views.ViewSync)
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/engine"
+	"github.com/AhmedAburady/rcm-go/internal/tui/components"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show what sync would change on each machine",
+	Long: `Compare the local Caddyfile and the generated server.toml and
+client.toml with the files deployed on the VPS and home client,
+and print a unified diff per file.
+
+With --exit-code the exit code tells scripts whether a sync is due:
+  0  everything is up to date
+  1  at least one file differs
+  2  loading config.yaml, parsing the Caddyfile or generating configs
+     failed, or a flag was wrong
+  5  reading the deployed files failed
+
+Diff never exits with 1 for an error, so 1 always means a sync is due.`,
+	RunE: runDiff,
+}
+
+var diffExitCode bool
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().BoolVar(&diffExitCode, "exit-code", false, "Exit with 1 if there are differences")
+	diffCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return withExitCode(ExitParse, err)
+	})
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	if configErr != nil {
+		return withExitCode(ExitParse, configErr)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return withExitCode(ExitParse, fmt.Errorf("load config: %w", err))
+	}
+
+	plan, err := engine.Plan(cfg, nil)
+	if err != nil {
+		return diffError(err, ExitParse)
+	}
+
+	diffs, err := engine.Diff(cfg, plan, nil)
+	if err != nil {
+		return diffError(err, ExitRemote)
+	}
+
+	changed := false
+	for _, d := range diffs {
+		if !d.Changed() {
+			continue
+		}
+		changed = true
+		fmt.Println(strings.Join(components.DiffLines(d.Unified), "\n"))
+	}
+
+	if !changed {
+		fmt.Println("No differences - deployed configs match local.")
+		return nil
+	}
+
+	if diffExitCode {
+		// The diff itself is the output, so don't print an error as well
+		cmd.SilenceErrors = true
+		cmd.SilenceUsage = true
+		return withExitCode(ExitDiff, errors.New("configs differ"))
+	}
+	return nil
+}
+
+// diffError maps an engine error to the exit code of its step, falling
+// back to code so that diff never exits with ExitDiff for an error
+func diffError(err error, code int) error {
+	err = engineExitCode(err)
+	if ExitCode(err) == ExitError {
+		return withExitCode(code, err)
+	}
+	return err
+}
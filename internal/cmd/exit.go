@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/AhmedAburady/rcm-go/internal/engine"
+)
+
+// Exit codes returned by non-interactive commands
+const (
+	ExitOK      = 0
+	ExitError   = 1 // Generic failure (config, flags, TUI)
+	ExitParse   = 2 // Caddyfile parse, validation or config generation failed
+	ExitUpload  = 3 // Uploading configs to a machine failed
+	ExitRestart = 4 // Restarting services failed
+	ExitRemote  = 5 // Reading deployed files from a machine failed
+	ExitAborted = 6 // A confirmation was declined, or stdin ended before an answer
+
+	ExitDiff = 1 // rcm diff --exit-code found differences, as in git diff; diff maps its errors to ExitParse or ExitRemote instead
+)
+
+// exitError carries a process exit code alongside an error
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// withExitCode wraps err so that ExitCode reports code for it
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// ExitCode returns the process exit code for an error returned by Execute
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return ExitError
+}
+
+// engineExitCode wraps an engine error with the exit code of the failed step
+func engineExitCode(err error) error {
+	var e *engine.Error
+	if !errors.As(err, &e) {
+		return err
+	}
+
+	switch e.Step {
+	case engine.StepParse, engine.StepGenerate, engine.StepValidate:
+		return withExitCode(ExitParse, err)
+	case engine.StepUpload, engine.StepRecord:
+		return withExitCode(ExitUpload, err)
+	case engine.StepRestart, engine.StepTunnel, engine.StepRollback:
+		return withExitCode(ExitRestart, err)
+	case engine.StepCompare:
+		return withExitCode(ExitRemote, err)
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/generator"
+)
+
+var keygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generate a noise keypair and a token for rathole",
+	Long: `Generate an X25519 keypair for rathole's noise transport and a random
+token, the same as rathole --genkey and openssl rand -base64 32.
+
+By default they are only printed. With --write they are saved as
+rathole.server_private_key, rathole.server_public_key and rathole.token
+in config.yaml. With --op VAULT they are stored in a new 1Password item
+and config.yaml gets op:// references to them instead.
+
+Existing values are only replaced with --force. Run rcm sync afterwards
+to deploy the new keys to both machines.`,
+	Args: cobra.NoArgs,
+	RunE: runKeygen,
+}
+
+var (
+	keygenWrite bool
+	keygenVault string
+	keygenItem  string
+	keygenForce bool
+)
+
+func init() {
+	rootCmd.AddCommand(keygenCmd)
+	keygenCmd.Flags().BoolVarP(&keygenWrite, "write", "w", false, "Write the keys and token into config.yaml")
+	keygenCmd.Flags().StringVar(&keygenVault, "op", "", "Store the keys and token in this 1Password vault and write op:// references into config.yaml")
+	keygenCmd.Flags().StringVar(&keygenItem, "op-item", "rcm-rathole", "Name of the 1Password item to create")
+	keygenCmd.Flags().BoolVarP(&keygenForce, "force", "f", false, "Replace keys and token already in config.yaml")
+}
+
+func runKeygen(cmd *cobra.Command, args []string) error {
+	keys, err := generator.NoiseKeypair()
+	if err != nil {
+		return err
+	}
+	token, err := generator.Token()
+	if err != nil {
+		return err
+	}
+	settings := []config.Setting{
+		{Key: "token", Value: token},
+		{Key: "server_private_key", Value: keys.Private},
+		{Key: "server_public_key", Value: keys.Public},
+	}
+
+	if !keygenWrite && keygenVault == "" {
+		fmt.Printf("Private key: %s\n", keys.Private)
+		fmt.Printf("Public key:  %s\n", keys.Public)
+		fmt.Printf("Token:       %s\n", token)
+		return nil
+	}
+
+	if configErr != nil {
+		return configErr
+	}
+	if !keygenForce {
+		for _, s := range settings {
+			if viper.GetString("rathole."+s.Key) != "" {
+				return fmt.Errorf("rathole.%s is already set in %s, use --force to replace it", s.Key, config.ConfigPath())
+			}
+		}
+	}
+
+	if keygenVault != "" {
+		refs, err := config.StoreSecrets(keygenVault, keygenItem, settings)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✓ Stored the keys and token in 1Password as %s in %s\n", keygenItem, keygenVault)
+		settings = refs
+	}
+	if err := config.WriteSettings(config.ConfigPath(), "rathole", settings); err != nil {
+		return err
+	}
+	for _, s := range settings {
+		fmt.Printf("✓ Set rathole.%s in %s\n", s.Key, config.ConfigPath())
+	}
+
+	fmt.Printf("\nPublic key: %s\n", keys.Public)
+	if transport := viper.GetString("rathole.transport"); transport != "" && transport != config.TransportNoise {
+		fmt.Printf("Note: rathole.transport is %s, the keys are only used by the noise transport\n", transport)
+	}
+	fmt.Println("Run rcm sync to deploy them to both machines.")
+	return nil
+}
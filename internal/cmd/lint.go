@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/parser"
+)
+
+var lintCmd = &cobra.Command{
+	Use:   "lint [caddyfile]",
+	Short: "Check the Caddyfile for services sync would skip",
+	Long: `Check the local Caddyfile, and the files it imports, for mistakes
+that make a service silently disappear from the tunnels or reach the
+wrong place. Each problem is printed as file:line:col, its severity,
+a message and the ID of the rule that found it:
+
+  orphan-comment       service comment not attached to a site block, or raw
+                       service comment inside a block (warning)
+  unannotated-site     site proxying to localhost without a service comment (warning)
+  missing-upstream     service without a reverse_proxy to localhost (error)
+  invalid-local-addr   service comment whose local address isn't host:port (error)
+  invalid-vps-port     raw service whose "-> port" isn't a port or ip:port (error)
+  conflicting-service  same service name with another local address, VPS port or client (error)
+  duplicate-port       different services on the same VPS port (error)
+  remote-upstream      service proxying somewhere other than localhost (warning)
+  invalid-option       "# rcm:" option rathole doesn't support, or a bad value (error)
+  orphan-options       "# rcm:" comment not right above a service comment (warning)
+
+A Caddyfile given as an argument is checked without loading the config.
+
+Exits with 2 if an error was found, and 0 if there were only warnings.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runLint,
+}
+
+func init() {
+	rootCmd.AddCommand(lintCmd)
+}
+
+func runLint(cmd *cobra.Command, args []string) error {
+	var path string
+	if len(args) == 1 {
+		path = args[0]
+	} else {
+		if configErr != nil {
+			return configErr
+		}
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("load config: %w", err)
+		}
+		path = cfg.Paths.Caddyfile
+	}
+
+	c, err := parser.Load(path, parser.OSFS)
+	if err != nil {
+		return withExitCode(ExitParse, fmt.Errorf("parse caddyfile: %w", err))
+	}
+
+	diags := c.Lint()
+	errs := 0
+	for _, d := range diags {
+		fmt.Println(d)
+		if d.Severity == parser.SeverityError {
+			errs++
+		}
+	}
+
+	if len(diags) == 0 {
+		fmt.Printf("✓ No problems in %s\n", path)
+		return nil
+	}
+	fmt.Printf("\nErrors: %d, warnings: %d\n", errs, len(diags)-errs)
+
+	if errs > 0 {
+		// The diagnostics are the output, so don't print an error as well
+		cmd.SilenceErrors = true
+		cmd.SilenceUsage = true
+		return withExitCode(ExitParse, errors.New("lint errors"))
+	}
+	return nil
+}
@@ -4,11 +4,10 @@ import (
 	"fmt"
 	"strings"
 
-	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/cobra"
 
 	"github.com/AhmedAburady/rcm-go/internal/config"
-	"github.com/AhmedAburady/rcm-go/internal/parser"
+	"github.com/AhmedAburady/rcm-go/internal/engine"
 	"github.com/AhmedAburady/rcm-go/internal/tui/views"
 )
 
@@ -41,18 +40,11 @@ func runList(cmd *cobra.Command, args []string) error {
 	}
 
 	// Launch TUI with main app, starting at list view
-	model := views.NewAppModelWithView(cfg, views.ViewList)
-	p := tea.NewProgram(model, tea.WithAltScreen())
-
-	if _, err := p.Run(); err != nil {
-		return fmt.Errorf("TUI error: %w", err)
-	}
-
-	return nil
+	return runTUI(cfg, views.ViewList)
 }
 
 func runListPlain(cfg *config.Config) error {
-	services, err := parser.ParseFile(cfg.Paths.Caddyfile)
+	services, err := engine.LocalServices(cfg)
 	if err != nil {
 		return err
 	}
@@ -62,13 +54,17 @@ func runListPlain(cfg *config.Config) error {
 		return nil
 	}
 
-	fmt.Printf("%-15s %-22s %-10s %s\n", "SERVICE", "LOCAL ADDRESS", "VPS PORT", "DOMAINS")
-	fmt.Println(strings.Repeat("-", 75))
+	fmt.Printf("%-15s %-22s %-12s %-10s %-6s %s\n", "SERVICE", "LOCAL ADDRESS", "CLIENT", "VPS PORT", "PROTO", "DOMAINS")
+	fmt.Println(strings.Repeat("-", 95))
 
 	for _, s := range services {
 		domains := strings.Join(s.Domains, ", ")
-		fmt.Printf("%-15s %-22s %-10d %s\n",
-			s.Name, s.LocalAddr, s.VPSPort, domains)
+		if s.Raw {
+			domains = fmt.Sprintf("- (no Caddy site, on %s)", s.VPSAddr())
+		}
+		client, _ := cfg.ClientNamed(s.Client)
+		fmt.Printf("%-15s %-22s %-12s %-10d %-6s %s\n",
+			s.Name, s.LocalAddr, client.Label(), s.VPSPort, s.Protocol, domains)
 	}
 
 	fmt.Printf("\nTotal: %d services\n", len(services))
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/AhmedAburady/rcm-go/internal/engine"
+)
+
+// withProgress runs an engine operation, printing one line per finished step
+func withProgress(run func(events chan<- engine.Event) error) error {
+	events := make(chan engine.Event)
+	done := make(chan struct{})
+
+	go func() {
+		for ev := range events {
+			printEvent(ev)
+		}
+		close(done)
+	}()
+
+	err := run(events)
+	close(events)
+	<-done
+	return err
+}
+
+func printEvent(ev engine.Event) {
+	switch ev.Status {
+	case engine.StatusDone:
+		if ev.Message != "" {
+			fmt.Printf("  ✓ %s (%s)\n", ev.Title(), ev.Message)
+		} else {
+			fmt.Printf("  ✓ %s\n", ev.Title())
+		}
+	case engine.StatusUnchanged:
+		fmt.Printf("  – %s (unchanged)\n", ev.Title())
+	case engine.StatusFailed:
+		fmt.Printf("  ✗ %s: %v\n", ev.Title(), ev.Err)
+	}
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/x/term"
+)
+
+// stdin is shared by every prompt so buffered input isn't lost between them
+var stdin = bufio.NewReader(os.Stdin)
+
+// confirm asks a yes/no question on the terminal. Anything other than
+// "y" or "yes" (including EOF) counts as no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N]: ", question)
+
+	response, _ := stdin.ReadString('\n')
+	response = strings.TrimSpace(strings.ToLower(response))
+
+	return response == "y" || response == "yes"
+}
+
+// aborted reports a declined confirmation. It exits with ExitAborted, so
+// cron or CI can't mistake a run that asked and got no answer for one that
+// did its job.
+func aborted() error {
+	// Declining isn't a usage mistake; "Aborted." says all there is
+	rootCmd.SilenceUsage = true
+	rootCmd.SilenceErrors = true
+	fmt.Println("Aborted.")
+	return withExitCode(ExitAborted, errors.New("aborted"))
+}
+
+// plainPrompter answers SSH questions on the terminal
+type plainPrompter struct{}
+
+func (plainPrompter) Confirm(question string) (bool, error) {
+	return confirm(question), nil
+}
+
+func (plainPrompter) Secret(prompt string) (string, error) {
+	if !term.IsTerminal(os.Stdin.Fd()) {
+		return "", errors.New("passphrase required but stdin is not a terminal")
+	}
+
+	fmt.Printf("%s ", prompt)
+	value, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("read passphrase: %w", err)
+	}
+	return string(value), nil
+}
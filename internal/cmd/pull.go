@@ -1,18 +1,13 @@
 package cmd
 
 import (
-	"bufio"
 	"fmt"
 	"os"
-	"path/filepath"
-	"strings"
 
-	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/cobra"
 
 	"github.com/AhmedAburady/rcm-go/internal/config"
-	"github.com/AhmedAburady/rcm-go/internal/parser"
-	"github.com/AhmedAburady/rcm-go/internal/ssh"
+	"github.com/AhmedAburady/rcm-go/internal/engine"
 	"github.com/AhmedAburady/rcm-go/internal/tui/views"
 )
 
@@ -23,7 +18,10 @@ var pullCmd = &cobra.Command{
 
 This is useful for syncing your local Caddyfile with the remote one,
 especially when setting up a new machine or recovering from changes
-made directly on the server.`,
+made directly on the server.
+
+In plain mode, declining to overwrite the local Caddyfile exits with 6;
+pass --force when nobody can answer.`,
 	RunE: runPull,
 }
 
@@ -53,14 +51,7 @@ func runPull(cmd *cobra.Command, args []string) error {
 	}
 
 	// Launch TUI with main app, starting at pull view
-	model := views.NewAppModelWithView(cfg, views.ViewPull)
-	p := tea.NewProgram(model, tea.WithAltScreen())
-
-	if _, err := p.Run(); err != nil {
-		return fmt.Errorf("TUI error: %w", err)
-	}
-
-	return nil
+	return runTUI(cfg, views.ViewPull)
 }
 
 func runPullPlain(cfg *config.Config) error {
@@ -68,51 +59,32 @@ func runPullPlain(cfg *config.Config) error {
 	localPath := cfg.Paths.Caddyfile
 	if _, err := os.Stat(localPath); err == nil && !pullForce {
 		fmt.Printf("Local Caddyfile already exists at %s\n", localPath)
-		fmt.Print("Overwrite? [y/N]: ")
-
-		reader := bufio.NewReader(os.Stdin)
-		response, _ := reader.ReadString('\n')
-		response = strings.TrimSpace(strings.ToLower(response))
-
-		if response != "y" && response != "yes" {
-			fmt.Println("Aborted.")
-			return nil
+		if !confirm("Overwrite?") {
+			return aborted()
 		}
 	}
 
-	// Connect to server
-	fmt.Printf("Connecting to %s...\n", cfg.Server.Host)
-	client, err := ssh.GetClient(cfg.Server.Host, cfg.Server.User, cfg.Server.SSHKey)
-	if err != nil {
-		return fmt.Errorf("connect to server: %w", err)
-	}
-	// Don't close - connection is pooled and reused
+	fmt.Printf("Pulling Caddyfile from %s...\n", cfg.Server.Host)
 
-	// Download Caddyfile
-	fmt.Printf("Downloading Caddyfile from %s...\n", cfg.Server.Caddyfile)
-	content, err := client.DownloadContent(cfg.Server.Caddyfile)
+	var result *engine.PullResult
+	err := withProgress(func(events chan<- engine.Event) error {
+		var err error
+		result, err = engine.Pull(cfg, events)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("download caddyfile: %w", err)
+		return err
 	}
 
-	// Ensure parent directory exists
-	dir := filepath.Dir(localPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("create directory %s: %w", dir, err)
+	fmt.Printf("\n✓ Downloaded Caddyfile to %s\n", result.Path)
+	for _, path := range result.Imports {
+		fmt.Printf("  + %s\n", path)
 	}
 
-	// Write to local path
-	if err := os.WriteFile(localPath, []byte(content), 0644); err != nil {
-		return fmt.Errorf("write local file: %w", err)
-	}
-
-	fmt.Printf("✓ Downloaded Caddyfile to %s\n", localPath)
-
-	// Parse and show summary
-	services, err := parser.ParseContent(content)
-	if err == nil && len(services) > 0 {
-		fmt.Printf("\nDiscovered %d services:\n", len(services))
-		for _, s := range services {
+	// Show summary
+	if len(result.Services) > 0 {
+		fmt.Printf("\nDiscovered %d services:\n", len(result.Services))
+		for _, s := range result.Services {
 			fmt.Printf("  • %s (%s)\n", s.Name, s.PrimaryDomain())
 		}
 	}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/engine"
+)
+
+var removeCmd = &cobra.Command{
+	Use:     "remove <name>",
+	Aliases: []string{"rm"},
+	Short:   "Remove a service from the local Caddyfile",
+	Long: `Delete every site block annotated with the service's name, together
+with the comments directly above it, from the local Caddyfile and the
+files it imports. A raw TCP or UDP service loses just its comment.
+Everything else in the files is left exactly as it was.
+
+Nothing is deployed unless --sync is given.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRemove,
+}
+
+var (
+	removeSync  bool
+	removePlain bool
+	removeYes   bool
+)
+
+func init() {
+	rootCmd.AddCommand(removeCmd)
+	removeCmd.Flags().BoolVar(&removeSync, "sync", false, "Sync straight after removing")
+	removeCmd.Flags().BoolVarP(&removePlain, "plain", "p", false, "Plain text sync output (no TUI)")
+	removeCmd.Flags().BoolVarP(&removeYes, "yes", "y", false, "Don't ask for confirmation when the sync removes services")
+}
+
+func runRemove(cmd *cobra.Command, args []string) error {
+	if configErr != nil {
+		return configErr
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	files, err := engine.RemoveService(cfg, args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		fmt.Printf("✓ Removed %s from %s\n", args[0], file)
+	}
+	return syncAfterEdit(cfg, removeSync, removePlain, removeYes)
+}
@@ -2,12 +2,12 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 
-	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/cobra"
 
 	"github.com/AhmedAburady/rcm-go/internal/config"
-	"github.com/AhmedAburady/rcm-go/internal/ssh"
+	"github.com/AhmedAburady/rcm-go/internal/engine"
 	"github.com/AhmedAburady/rcm-go/internal/tui/views"
 )
 
@@ -55,74 +55,31 @@ func runRestart(cmd *cobra.Command, args []string) error {
 	}
 
 	// Launch TUI with main app, starting at restart view
-	model := views.NewAppModelWithView(cfg, views.ViewRestart)
-	p := tea.NewProgram(model, tea.WithAltScreen())
-
-	if _, err := p.Run(); err != nil {
-		return fmt.Errorf("TUI error: %w", err)
-	}
-
-	return nil
+	return runTUI(cfg, views.ViewRestart)
 }
 
 func runRestartPlain(cfg *config.Config) error {
+	var hosts []string
 	if restartServer {
-		fmt.Printf("Restarting services on server (%s)...\n", cfg.Server.Host)
-		if err := restartServerServices(cfg); err != nil {
-			return err
-		}
+		hosts = append(hosts, fmt.Sprintf("server (%s)", cfg.Server.Host))
 	}
-
 	if restartClient {
-		fmt.Printf("Restarting services on client (%s)...\n", cfg.Client.Host)
-		if err := restartClientServices(cfg); err != nil {
-			return err
-		}
+		hosts = append(hosts, fmt.Sprintf("client (%s)", cfg.ClientHosts()))
 	}
+	fmt.Printf("Restarting services on %s...\n", strings.Join(hosts, " and "))
 
-	fmt.Println("\n✓ All services restarted successfully")
-	return nil
-}
-
-func restartServerServices(cfg *config.Config) error {
-	client, err := ssh.GetClient(cfg.Server.Host, cfg.Server.User, cfg.Server.SSHKey)
-	if err != nil {
-		return fmt.Errorf("connect to server: %w", err)
+	opts := engine.RestartOptions{
+		Server: restartServer,
+		Client: restartClient,
+		Caddy:  restartServer,
 	}
-	// Don't close - connection is pooled and reused
-
-	fmt.Print("  Restarting rathole-server... ")
-	if err := client.RestartService("rathole-server"); err != nil {
-		fmt.Println("✗")
-		return fmt.Errorf("restart rathole-server: %w", err)
-	}
-	fmt.Println("✓")
-
-	if cfg.Server.CaddyComposeDir != "" {
-		fmt.Print("  Restarting caddy... ")
-		if err := client.RestartDockerCompose(cfg.Server.CaddyComposeDir); err != nil {
-			fmt.Println("✗")
-			return fmt.Errorf("restart caddy: %w", err)
-		}
-		fmt.Println("✓")
-	}
-
-	return nil
-}
-
-func restartClientServices(cfg *config.Config) error {
-	client, err := ssh.GetClient(cfg.Client.Host, cfg.Client.User, cfg.Client.SSHKey)
+	err := withProgress(func(events chan<- engine.Event) error {
+		return engine.Restart(cfg, opts, events)
+	})
 	if err != nil {
-		return fmt.Errorf("connect to client: %w", err)
+		return engineExitCode(err)
 	}
-	// Don't close - connection is pooled and reused
-
-	fmt.Print("  Restarting rathole-client... ")
-	if err := client.RestartService("rathole-client"); err != nil {
-		fmt.Println("✗")
-		return fmt.Errorf("restart rathole-client: %w", err)
-	}
-	fmt.Println("✓")
 
+	fmt.Println("\n✓ All services restarted successfully")
 	return nil
 }
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/engine"
+	"github.com/AhmedAburady/rcm-go/internal/tui/views"
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [id]",
+	Short: "Restore a previously deployed configuration",
+	Long: `Re-deploy a configuration recorded by an earlier sync.
+
+Every sync records a snapshot of the Caddyfile, server.toml and
+client.toml it deployed. Rollback uploads the chosen snapshot to
+both machines and restarts services the same way sync does.
+
+Without an id, the deployment before the current one is restored. A
+rollback counts as the snapshot it restored, so rolling back again
+steps further back rather than undoing the rollback. Use --list to
+see the recorded snapshots.
+
+In plain mode the exit codes match rcm sync, and declining the
+confirmation exits with 6.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runRollback,
+}
+
+var (
+	rollbackList  bool
+	rollbackPlain bool
+	rollbackYes   bool
+)
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+	rollbackCmd.Flags().BoolVarP(&rollbackList, "list", "l", false, "List recorded snapshots")
+	rollbackCmd.Flags().BoolVarP(&rollbackPlain, "plain", "p", false, "Plain text output (no TUI)")
+	rollbackCmd.Flags().BoolVarP(&rollbackYes, "yes", "y", false, "Don't ask for confirmation")
+}
+
+func runRollback(cmd *cobra.Command, args []string) error {
+	if configErr != nil {
+		return configErr
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	var id string
+	if len(args) > 0 {
+		id = args[0]
+	}
+
+	if rollbackList {
+		return printHistory()
+	}
+
+	if rollbackPlain {
+		return runRollbackPlain(cfg, id)
+	}
+
+	// Launch TUI with main app, starting at history view
+	return runTUIModel(views.NewAppModelWithView(cfg, views.ViewHistory).WithSnapshot(id))
+}
+
+func printHistory() error {
+	snapshots, err := engine.History()
+	if err != nil {
+		return fmt.Errorf("load history: %w", err)
+	}
+	if len(snapshots) == 0 {
+		fmt.Println("No deployments recorded yet.")
+		return nil
+	}
+
+	fmt.Printf("%-21s %-18s %-25s %s\n", "ID", "DEPLOYED", "HOSTS", "SERVICES")
+	fmt.Println(strings.Repeat("-", 88))
+	for i, snap := range snapshots {
+		id := snap.ID
+		if i == 0 {
+			id += "*"
+		}
+		services := strings.Join(snap.Services, ", ")
+		if snap.RollbackOf != "" {
+			services = "rollback to " + snap.RollbackOf + ": " + services
+		}
+		fmt.Printf("%-21s %-18s %-25s %s\n",
+			id,
+			snap.Time.Local().Format("2006-01-02 15:04"),
+			snap.ServerHost+", "+snap.ClientHost,
+			services)
+	}
+	fmt.Println("\n* current deployment")
+	return nil
+}
+
+func runRollbackPlain(cfg *config.Config, id string) error {
+	snap, err := engine.LoadSnapshot(cfg, id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Snapshot %s, deployed %s\n", snap.ID, snap.Time.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("  Server:   %s\n", snap.ServerHost)
+	fmt.Printf("  Client:   %s\n", snap.ClientHost)
+	fmt.Printf("  Services: %s\n", strings.Join(snap.Services, ", "))
+
+	if snap.ServerHost != cfg.Server.Host || snap.ClientHost != cfg.ClientHosts() {
+		fmt.Printf("\nWarning: recorded for different hosts than the current config (%s, %s)\n",
+			cfg.Server.Host, cfg.ClientHosts())
+	}
+
+	if !rollbackYes && !confirm("\nRe-deploy this snapshot and restart services?") {
+		return aborted()
+	}
+
+	fmt.Printf("\nRolling back %s and %s...\n", cfg.Server.Host, cfg.ClientHosts())
+	var result *engine.SyncResult
+	err = withProgress(func(events chan<- engine.Event) error {
+		var err error
+		result, err = engine.Rollback(cfg, snap, engine.SyncOptions{}, events)
+		return err
+	})
+	if err != nil {
+		return engineExitCode(err)
+	}
+
+	if !result.Changes.Any() {
+		fmt.Printf("\n✓ %s is already deployed\n", snap.ID)
+		return nil
+	}
+	fmt.Printf("\n✓ Rolled back to %s\n", snap.ID)
+	return nil
+}
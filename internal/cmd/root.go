@@ -9,6 +9,7 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/ssh"
 	"github.com/AhmedAburady/rcm-go/internal/tui/views"
 )
 
@@ -39,9 +40,26 @@ func runApp(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("load config: %w", err)
 	}
 
-	model := views.NewAppModel(cfg)
+	return runTUI(cfg, views.ViewMenu)
+}
+
+// runTUI launches the main TUI application at the given view
+func runTUI(cfg *config.Config, view views.AppView) error {
+	return runTUIModel(views.NewAppModelWithView(cfg, view))
+}
+
+// runTUIModel launches the TUI with a prepared app model. While it runs,
+// SSH questions such as unknown host keys are asked inside the TUI.
+func runTUIModel(model views.AppModel) error {
 	p := tea.NewProgram(model, tea.WithAltScreen())
 
+	prompter := views.NewPrompter(p)
+	ssh.SetPrompter(prompter)
+	defer func() {
+		prompter.Close()
+		ssh.SetPrompter(plainPrompter{})
+	}()
+
 	if _, err := p.Run(); err != nil {
 		return fmt.Errorf("TUI error: %w", err)
 	}
@@ -55,10 +73,21 @@ func Execute() error {
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
+	cobra.OnInitialize(initConfig, initSSH)
 
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "",
 		"config file (default: ~/.config/rcm/config.yaml)")
+	rootCmd.PersistentFlags().BoolVar(&acceptNewHostKey, "accept-new-host-key", false,
+		"trust and remember host keys of unknown hosts without asking")
+}
+
+var acceptNewHostKey bool
+
+// initSSH applies SSH flags and answers SSH questions on the terminal
+// until a TUI takes over
+func initSSH() {
+	ssh.AcceptNewHostKeys = acceptNewHostKey
+	ssh.SetPrompter(plainPrompter{})
 }
 
 var configErr error
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/engine"
+)
+
+var rotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the rathole token and noise keys",
+	Long: `Generate a new token and/or server noise keypair and deploy them.
+
+rathole-server gets the new config first, then rathole-client, and rcm
+waits until the VPS listens on every service's port again, which rathole
+only does once the client has connected. If the tunnel doesn't come back
+within --timeout, the previous configs are deployed again.
+
+The deployed configs must match the local ones, so run rcm sync first if
+anything changed. The new values are then saved where the old ones came
+from: op:// references are updated in 1Password, plain values in
+config.yaml. Values from ${ENV} references can't be rotated this way.
+
+Without flags, both the token and the keys are rotated (only the token
+when the transport isn't noise). Exit codes match rcm sync, and
+declining the confirmation exits with 6.`,
+	Args: cobra.NoArgs,
+	RunE: runRotate,
+}
+
+var (
+	rotateToken   bool
+	rotateKeys    bool
+	rotateTimeout time.Duration
+	rotateYes     bool
+)
+
+func init() {
+	rootCmd.AddCommand(rotateCmd)
+	rotateCmd.Flags().BoolVar(&rotateToken, "token", false, "Rotate rathole.token")
+	rotateCmd.Flags().BoolVar(&rotateKeys, "keys", false, "Rotate the server's noise keypair")
+	rotateCmd.Flags().DurationVar(&rotateTimeout, "timeout", 30*time.Second, "How long to wait for the tunnel to come back")
+	rotateCmd.Flags().BoolVarP(&rotateYes, "yes", "y", false, "Don't ask for confirmation")
+}
+
+func runRotate(cmd *cobra.Command, args []string) error {
+	if configErr != nil {
+		return configErr
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	if !rotateToken && !rotateKeys {
+		rotateToken = true
+		rotateKeys = cfg.Rathole.Transport == config.TransportNoise
+	}
+	var keys, what []string
+	if rotateToken {
+		keys = append(keys, "token")
+		what = append(what, "token")
+	}
+	if rotateKeys {
+		keys = append(keys, "server_private_key", "server_public_key")
+		what = append(what, "noise keys")
+	}
+	if err := config.CheckUpdatable("rathole", keys); err != nil {
+		return err
+	}
+
+	if !rotateYes && !confirm(fmt.Sprintf("Rotate the %s on %s and %s? Tunnels drop while rathole restarts.",
+		strings.Join(what, " and "), cfg.Server.Host, cfg.ClientHosts())) {
+		return aborted()
+	}
+
+	fmt.Printf("\nRotating the %s...\n", strings.Join(what, " and "))
+	var result *engine.RotateResult
+	err = withProgress(func(events chan<- engine.Event) error {
+		var err error
+		result, err = engine.Rotate(cfg, engine.RotateOptions{Token: rotateToken, Keys: rotateKeys, Timeout: rotateTimeout}, events)
+		return err
+	})
+	if err != nil {
+		return engineExitCode(err)
+	}
+
+	if err := config.UpdateSettings(config.ConfigPath(), "rathole", result.Settings); err != nil {
+		// The new values are live, so losing them would lock rcm out of
+		// its own tunnel on the next sync. They aren't printed, since
+		// terminals and CI logs get kept.
+		fmt.Println("\nThe new credentials are deployed but couldn't be saved. Update these by hand:")
+		for _, s := range result.Settings {
+			where := config.ConfigPath()
+			if raw := viper.GetString("rathole." + s.Key); strings.HasPrefix(raw, "op://") {
+				where = raw
+			}
+			fmt.Printf("  rathole.%s (%s)\n", s.Key, where)
+		}
+		fmt.Printf("The new values are in %s on %s", cfg.Server.RatholeConfig, cfg.Server.Host)
+		if result.SnapshotID != "" {
+			fmt.Printf(" and in %s", filepath.Join(engine.HistoryDir(), result.SnapshotID+".json"))
+		}
+		fmt.Println(".")
+		return err
+	}
+
+	fmt.Printf("\n✓ Rotated the %s and saved them\n", strings.Join(what, " and "))
+	return nil
+}
@@ -4,23 +4,22 @@ import (
 	"fmt"
 	"strings"
 
-	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/cobra"
 
 	"github.com/AhmedAburady/rcm-go/internal/config"
-	"github.com/AhmedAburady/rcm-go/internal/ssh"
+	"github.com/AhmedAburady/rcm-go/internal/engine"
 	"github.com/AhmedAburady/rcm-go/internal/tui/views"
 )
 
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Show service status",
-	Long: `Check the health of rathole and caddy services on both machines.
+	Long: `Check the health of rathole and caddy services on all machines.
 
-This command connects to both the VPS and home client via SSH
+This command connects to the VPS and every home client via SSH
 and checks the status of:
 - rathole-server (on VPS)
-- rathole-client (on home machine)
+- rathole-client (on each home machine)
 - caddy (docker compose on VPS)`,
 	RunE: runStatus,
 }
@@ -47,63 +46,38 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	}
 
 	// Launch TUI with main app, starting at status view
-	model := views.NewAppModelWithView(cfg, views.ViewStatus)
-	p := tea.NewProgram(model, tea.WithAltScreen())
-
-	if _, err := p.Run(); err != nil {
-		return fmt.Errorf("TUI error: %w", err)
-	}
-
-	return nil
+	return runTUI(cfg, views.ViewStatus)
 }
 
 func runStatusPlain(cfg *config.Config) error {
 	fmt.Println("SERVICE STATUS")
 	fmt.Println(strings.Repeat("-", 60))
 
-	// Check server
-	fmt.Printf("\nServer (%s):\n", cfg.Server.Host)
-	serverClient, err := ssh.GetClient(cfg.Server.Host, cfg.Server.User, cfg.Server.SSHKey)
-	if err != nil {
-		fmt.Printf("  ✗ Unable to connect: %v\n", err)
-	} else {
-		// Don't close - connection is pooled and reused
-
-		// Check rathole-server
-		running, status, _ := serverClient.GetServiceStatus("rathole-server")
-		icon := "✗"
-		if running {
-			icon = "✓"
-		}
-		fmt.Printf("  %s rathole-server: %s\n", icon, status)
-
-		// Check caddy if configured
-		if cfg.Server.CaddyComposeDir != "" {
-			running, status, _ := serverClient.GetDockerComposeStatus(cfg.Server.CaddyComposeDir)
-			icon := "✗"
-			if running {
-				icon = "✓"
-			}
-			fmt.Printf("  %s caddy (docker): %s\n", icon, status)
+	report := engine.Status(cfg, nil)
+	printMachineStatus("Server", report.Server)
+	for _, client := range report.Clients {
+		name := "Client"
+		if client.Name != "" {
+			name += " " + client.Name
 		}
+		printMachineStatus(name, client)
 	}
 
-	// Check client
-	fmt.Printf("\nClient (%s):\n", cfg.Client.Host)
-	clientClient, err := ssh.GetClient(cfg.Client.Host, cfg.Client.User, cfg.Client.SSHKey)
-	if err != nil {
-		fmt.Printf("  ✗ Unable to connect: %v\n", err)
-	} else {
-		// Don't close - connection is pooled and reused
+	return nil
+}
 
-		// Check rathole-client
-		running, status, _ := clientClient.GetServiceStatus("rathole-client")
+func printMachineStatus(name string, status engine.MachineStatus) {
+	fmt.Printf("\n%s (%s):\n", name, status.Host)
+	if !status.Online {
+		fmt.Printf("  ✗ Unable to connect: %v\n", status.Err)
+		return
+	}
+
+	for _, svc := range status.Services {
 		icon := "✗"
-		if running {
+		if svc.Running {
 			icon = "✓"
 		}
-		fmt.Printf("  %s rathole-client: %s\n", icon, status)
+		fmt.Printf("  %s %s: %s\n", icon, svc.Name, svc.Status)
 	}
-
-	return nil
 }
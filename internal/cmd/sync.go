@@ -2,11 +2,12 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 
-	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/cobra"
 
 	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/engine"
 	"github.com/AhmedAburady/rcm-go/internal/tui/views"
 )
 
@@ -19,18 +20,37 @@ and deploy to both VPS and home client.
 This command will:
 1. Parse the local Caddyfile to extract service definitions
 2. Generate server.toml and client.toml configs
-3. Upload server.toml to the VPS
-4. Upload client.toml to the home client
-5. Restart rathole-server on VPS
-6. Restart rathole-client on home machine`,
+3. Compare them with the configs deployed on each machine
+4. Validate a changed Caddyfile with caddy on the VPS
+5. Upload the changed configs to the VPS and the home client
+6. Restart rathole-server, rathole-client and Caddy where their config changed
+
+Nothing is uploaded or restarted when the deployed configs already match;
+use --force to redeploy and restart everything anyway.
+
+In plain mode the exit code reports which stage failed:
+  1  general error (config, flags)
+  2  parsing or validating the Caddyfile, or generating configs
+  3  uploading configs
+  4  restarting services
+  6  the removal of services wasn't confirmed; pass --yes when
+     nobody can answer`,
 	RunE: runSync,
 }
 
-var syncDryRun bool
+var (
+	syncDryRun bool
+	syncPlain  bool
+	syncYes    bool
+	syncForce  bool
+)
 
 func init() {
 	rootCmd.AddCommand(syncCmd)
 	syncCmd.Flags().BoolVar(&syncDryRun, "dry-run", false, "Preview changes without deploying")
+	syncCmd.Flags().BoolVarP(&syncPlain, "plain", "p", false, "Plain text output (no TUI)")
+	syncCmd.Flags().BoolVarP(&syncYes, "yes", "y", false, "Don't ask for confirmation when services would be removed")
+	syncCmd.Flags().BoolVar(&syncForce, "force", false, "Upload and restart even if nothing changed")
 }
 
 func runSync(cmd *cobra.Command, args []string) error {
@@ -43,17 +63,95 @@ func runSync(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("load config: %w", err)
 	}
 
+	if syncPlain {
+		return runSyncPlain(cfg, syncYes)
+	}
+
 	// Launch TUI with main app, starting at sync view
 	initialView := views.ViewSync
 	if syncDryRun {
 		initialView = views.ViewSyncDryRun
 	}
 	model := views.NewAppModelWithView(cfg, initialView)
-	p := tea.NewProgram(model, tea.WithAltScreen())
+	if syncForce {
+		model = model.WithForce()
+	}
+	return runTUIModel(model)
+}
 
-	if _, err := p.Run(); err != nil {
-		return fmt.Errorf("TUI error: %w", err)
+// runSyncPlain syncs with plain text output. yes skips the confirmation
+// before services are removed.
+func runSyncPlain(cfg *config.Config, yes bool) error {
+	fmt.Println("Preparing sync...")
+
+	var plan *engine.SyncPlan
+	err := withProgress(func(events chan<- engine.Event) error {
+		var err error
+		plan, err = engine.Plan(cfg, events)
+		return err
+	})
+	if err != nil {
+		return engineExitCode(err)
+	}
+
+	if len(plan.Lint) > 0 {
+		fmt.Println("\nProblems in the Caddyfile:")
+		for _, d := range plan.Lint {
+			fmt.Printf("  %s\n", d)
+		}
 	}
 
+	if syncDryRun {
+		printSyncPreview(cfg, plan)
+		return nil
+	}
+
+	if len(plan.Removed) > 0 && !yes {
+		fmt.Printf("\nThe following services will be removed from %s:\n", cfg.Server.Host)
+		for _, name := range plan.Removed {
+			fmt.Printf("  • %s\n", name)
+		}
+		if !confirm("Continue?") {
+			return aborted()
+		}
+	}
+
+	fmt.Printf("\nDeploying to %s and %s...\n", cfg.Server.Host, cfg.ClientHosts())
+	var result *engine.SyncResult
+	err = withProgress(func(events chan<- engine.Event) error {
+		var err error
+		result, err = engine.Sync(cfg, plan, engine.SyncOptions{Force: syncForce}, events)
+		return err
+	})
+	if err != nil {
+		return engineExitCode(err)
+	}
+
+	if !result.Changes.Any() {
+		fmt.Println("\n✓ Already up to date, nothing to deploy")
+		return nil
+	}
+	fmt.Printf("\n✓ Deployed %d services\n", len(plan.Services))
 	return nil
 }
+
+func printSyncPreview(cfg *config.Config, plan *engine.SyncPlan) {
+	fmt.Println()
+	fmt.Printf("%-15s %-22s %-10s %-6s %-8s %s\n", "SERVICE", "LOCAL ADDRESS", "VPS PORT", "PROTO", "REMOTE", "DOMAINS")
+	fmt.Println(strings.Repeat("-", 91))
+	for _, row := range plan.Rows {
+		remote := "new"
+		if row.IsRemote {
+			remote = "update"
+		}
+		fmt.Printf("%-15s %-22s %-10d %-6s %-8s %s\n",
+			row.Name, row.LocalAddr, row.VPSPort, row.Protocol, remote, strings.Join(row.Domains, ", "))
+	}
+	for _, name := range plan.Removed {
+		fmt.Printf("%-15s %-22s %-10s %-6s %-8s\n", name, "-", "-", "-", "removed")
+	}
+
+	fmt.Printf("\nServer: %s\n", cfg.Server.Host)
+	fmt.Printf("Client: %s\n", cfg.ClientHosts())
+	fmt.Println("\nDry run - nothing was deployed.")
+}
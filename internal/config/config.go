@@ -2,7 +2,10 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/viper"
@@ -19,8 +22,16 @@ func Load() (*Config, error) {
 
 	// Set defaults
 	viper.SetDefault("paths.ssh_dir", "~/.ssh")
-	viper.SetDefault("server.user", "root")
+	viper.SetDefault("server.backups", 5)
+	viper.SetDefault("server.caddy_service", "caddy")
+	viper.SetDefault("server.caddy_config", "/etc/caddy/Caddyfile")
+	viper.SetDefault("server.caddy_reload", CaddyReloadGraceful)
+	viper.SetDefault("server.caddy_validate", CaddyValidateAuto)
+	viper.SetDefault("client.backups", 5)
 	viper.SetDefault("rathole.bind_port", 2333)
+	viper.SetDefault("rathole.transport", TransportNoise)
+	viper.SetDefault("rathole.port_range_start", 8001)
+	viper.SetDefault("rathole.port_range_end", 8999)
 
 	if err := viper.Unmarshal(&cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
@@ -37,14 +48,43 @@ func Load() (*Config, error) {
 
 	// Handle SSH keys - if just a filename, combine with ssh_dir
 	cfg.Server.SSHKey = resolveSSHKey(cfg.Server.SSHKey, cfg.Paths.SSHDir)
+	for i := range cfg.Clients {
+		cfg.Clients[i].SSHKey = resolveSSHKey(cfg.Clients[i].SSHKey, cfg.Paths.SSHDir)
+	}
 	cfg.Client.SSHKey = resolveSSHKey(cfg.Client.SSHKey, cfg.Paths.SSHDir)
 
 	// Validate required fields
 	if cfg.Server.Host == "" {
 		return nil, fmt.Errorf("server.host is required")
 	}
-	if cfg.Client.Host == "" {
-		return nil, fmt.Errorf("client.host is required")
+	if err := validateClients(&cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Server.Backups < 0 {
+		return nil, fmt.Errorf("backups must be 0 or more")
+	}
+	if r := cfg.Server.CaddyReload; r != CaddyReloadGraceful && r != CaddyReloadRestart {
+		return nil, fmt.Errorf("server.caddy_reload: unknown mode %q (use reload or restart)", r)
+	}
+	switch cfg.Server.CaddyValidate {
+	case CaddyValidateAuto, CaddyValidateCompose, CaddyValidateNative, CaddyValidateOff:
+	default:
+		return nil, fmt.Errorf("server.caddy_validate: unknown mode %q (use auto, compose, native or off)", cfg.Server.CaddyValidate)
+	}
+	if cfg.Server.CaddyValidate == CaddyValidateCompose && cfg.Server.CaddyComposeDir == "" {
+		return nil, fmt.Errorf("server.caddy_validate: compose needs server.caddy_compose_dir")
+	}
+	if r := cfg.Rathole; r.PortRangeStart < 1 || r.PortRangeEnd > 65535 || r.PortRangeStart > r.PortRangeEnd {
+		return nil, fmt.Errorf("rathole: invalid port range %d-%d", r.PortRangeStart, r.PortRangeEnd)
+	}
+	if err := validateTransport(&cfg.Rathole); err != nil {
+		return nil, err
+	}
+	if err := validateServices(cfg.Services, cfg.Clients); err != nil {
+		return nil, err
+	}
+	if err := validateAuthMethods("server", cfg.Server.AuthMethods); err != nil {
+		return nil, err
 	}
 
 	return &cfg, nil
@@ -67,6 +107,197 @@ func resolveSSHKey(keyPath, sshDir string) string {
 	return filepath.Join(sshDir, keyPath)
 }
 
+// validateClients checks the client section, or the clients list, and makes
+// Clients hold every client and Client the first one
+func validateClients(cfg *Config) error {
+	if len(cfg.Clients) == 0 {
+		if cfg.Client.Host == "" {
+			return fmt.Errorf("client.host is required")
+		}
+		if cfg.Client.Backups < 0 {
+			return fmt.Errorf("backups must be 0 or more")
+		}
+		cfg.Clients = []ClientConfig{cfg.Client}
+		return validateAuthMethods("client", cfg.Client.AuthMethods)
+	}
+	if viper.InConfig("client") {
+		return fmt.Errorf("client and clients can't both be set, move the client into the clients list")
+	}
+
+	// List entries don't get viper's defaults
+	raw, _ := viper.Get("clients").([]any)
+	seen := make(map[string]bool)
+	for i := range cfg.Clients {
+		c := &cfg.Clients[i]
+		if !serviceNameRe.MatchString(c.Name) {
+			return fmt.Errorf("clients[%d]: invalid name %q (use letters, digits, _ and -)", i, c.Name)
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("clients.%s: defined twice", c.Name)
+		}
+		seen[c.Name] = true
+		if c.Host == "" {
+			return fmt.Errorf("clients.%s: host is required", c.Name)
+		}
+		if i < len(raw) {
+			if m, ok := raw[i].(map[string]any); ok {
+				if _, set := m["backups"]; !set {
+					c.Backups = 5
+				}
+			}
+		}
+		if c.Backups < 0 {
+			return fmt.Errorf("clients.%s: backups must be 0 or more", c.Name)
+		}
+		if strings.ContainsAny(c.Token, "\"\\\n") {
+			return fmt.Errorf("clients.%s: token can't hold quotes, backslashes or newlines", c.Name)
+		}
+		if err := validateAuthMethods("clients."+c.Name, c.AuthMethods); err != nil {
+			return err
+		}
+	}
+	cfg.Client = cfg.Clients[0]
+	return nil
+}
+
+// validateAuthMethods checks the auth_methods list of a host section
+func validateAuthMethods(section string, methods []string) error {
+	for _, m := range methods {
+		if m != "agent" && m != "key" {
+			return fmt.Errorf("%s.auth_methods: unknown method %q (use agent or key)", section, m)
+		}
+	}
+	return nil
+}
+
+// validateTransport checks the settings the chosen rathole transport needs
+// are there
+func validateTransport(r *RatholeConfig) error {
+	switch r.Transport {
+	case TransportTCP:
+		return nil
+	case TransportNoise:
+		return validateNoise(r)
+	case TransportTLS:
+		return validateTLS(r.TLS)
+	case TransportWebsocket:
+		if r.Websocket.TLS {
+			return validateTLS(r.TLS)
+		}
+		return nil
+	default:
+		return fmt.Errorf("rathole.transport: unknown transport %q (use tcp, tls, noise or websocket)", r.Transport)
+	}
+}
+
+// noisePatternRe matches the patterns rathole's noise transport supports,
+// capturing the handshake letters of the client and the server
+var noisePatternRe = regexp.MustCompile(`^Noise_([NKXI])([NKX])_25519_(ChaChaPoly|AESGCM)_(BLAKE2s|BLAKE2b|SHA256|SHA512)$`)
+
+// validateNoise checks the keys the noise pattern needs. The client is the
+// initiator: with N it has no static key, with K the server knows its public
+// key in advance, and the same goes for the server as the responder.
+func validateNoise(r *RatholeConfig) error {
+	pattern := r.Noise.Pattern
+	if pattern == "" {
+		pattern = "Noise_NK_25519_ChaChaPoly_BLAKE2s"
+	}
+	m := noisePatternRe.FindStringSubmatch(pattern)
+	if m == nil {
+		return fmt.Errorf("rathole.noise.pattern: unsupported pattern %q (e.g. Noise_NK_25519_ChaChaPoly_BLAKE2s)", pattern)
+	}
+	client, server := m[1], m[2]
+
+	required := []struct {
+		needed bool
+		key    string
+		value  string
+	}{
+		{server != "N", "rathole.server_private_key", r.ServerPrivateKey},
+		{server == "K", "rathole.server_public_key", r.ServerPublicKey},
+		{client != "N", "rathole.noise.client_private_key", r.Noise.ClientPrivateKey},
+		{client == "K", "rathole.noise.client_public_key", r.Noise.ClientPublicKey},
+	}
+	for _, req := range required {
+		if req.needed && req.value == "" {
+			return fmt.Errorf("%s is required by the noise transport with %s", req.key, pattern)
+		}
+	}
+	return nil
+}
+
+// validateTLS checks the server has a certificate to present
+func validateTLS(t TLSConfig) error {
+	if t.PKCS12 == "" {
+		return fmt.Errorf("rathole.tls.pkcs12 is required by the tls transport")
+	}
+	if t.PKCS12Password == "" {
+		return fmt.Errorf("rathole.tls.pkcs12_password is required by the tls transport")
+	}
+	values := [][2]string{{"pkcs12", t.PKCS12}, {"pkcs12_password", t.PKCS12Password}, {"trusted_root", t.TrustedRoot}, {"hostname", t.Hostname}}
+	for _, v := range values {
+		if strings.ContainsAny(v[1], "\"\\\n") {
+			return fmt.Errorf("rathole.tls.%s can't hold quotes, backslashes or newlines", v[0])
+		}
+	}
+	return nil
+}
+
+// serviceNameRe matches names that can be rathole service names
+var serviceNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// validateServices checks the services section and fills in its defaults
+func validateServices(services []ServiceConfig, clients []ClientConfig) error {
+	seen := make(map[string]bool)
+	for i := range services {
+		svc := &services[i]
+		if !serviceNameRe.MatchString(svc.Name) {
+			return fmt.Errorf("services[%d]: invalid name %q (use letters, digits, _ and -)", i, svc.Name)
+		}
+		if seen[svc.Name] {
+			return fmt.Errorf("services.%s: defined twice", svc.Name)
+		}
+		seen[svc.Name] = true
+
+		host, port, err := net.SplitHostPort(svc.LocalAddr)
+		localPort, perr := strconv.Atoi(port)
+		if err != nil || host == "" || perr != nil || localPort < 1 || localPort > 65535 {
+			return fmt.Errorf("services.%s: local_addr %q isn't host:port", svc.Name, svc.LocalAddr)
+		}
+		if svc.VPSPort == 0 {
+			svc.VPSPort = localPort
+		}
+		if svc.VPSPort < 1 || svc.VPSPort > 65535 {
+			return fmt.Errorf("services.%s: invalid vps_port %d", svc.Name, svc.VPSPort)
+		}
+
+		switch svc.Protocol {
+		case "":
+			svc.Protocol = "tcp"
+		case "tcp", "udp":
+		default:
+			return fmt.Errorf("services.%s: unknown protocol %q (use tcp or udp)", svc.Name, svc.Protocol)
+		}
+		if svc.BindAddr != "" && net.ParseIP(svc.BindAddr) == nil {
+			return fmt.Errorf("services.%s: bind_addr %q isn't an IP address", svc.Name, svc.BindAddr)
+		}
+		if svc.Client != "" && !hasClient(clients, svc.Client) {
+			return fmt.Errorf("services.%s: no client named %s under clients", svc.Name, svc.Client)
+		}
+	}
+	return nil
+}
+
+// hasClient reports whether a client has the given name
+func hasClient(clients []ClientConfig, name string) bool {
+	for _, c := range clients {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 // ConfigPath returns the path of the loaded config file
 func ConfigPath() string {
 	return viper.ConfigFileUsed()
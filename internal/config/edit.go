@@ -0,0 +1,218 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Setting is a key and its value under a top level section of config.yaml
+type Setting struct {
+	Key   string
+	Value string
+}
+
+// WriteSettings sets keys under a top level section of the config file.
+// Only the lines of those keys change, so comments and formatting are kept.
+// Missing keys are added at the end of the section, and a missing section
+// at the end of the file. The file is replaced atomically, so a crash or a
+// full disk never leaves it half written.
+func WriteSettings(path, section string, settings []Setting) error {
+	// Write through a symlink, e.g. into a dotfiles repository
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := writeFileAtomic(path, []byte(setSettings(string(content), section, settings)), mode); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temp file next to path, syncs it to
+// disk and renames it over path
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// setSettings edits the YAML source src, see WriteSettings
+func setSettings(src, section string, settings []Setting) string {
+	lines := strings.SplitAfter(src, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	sectionRe := regexp.MustCompile(`^` + regexp.QuoteMeta(section) + `:\s*(#.*)?$`)
+	start := -1
+	for i, line := range lines {
+		if sectionRe.MatchString(strings.TrimRight(line, "\r\n")) {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+			lines[len(lines)-1] += "\n"
+		}
+		lines = append(lines, section+":\n")
+		for _, s := range settings {
+			lines = append(lines, "  "+s.Key+": "+strconv.Quote(s.Value)+"\n")
+		}
+		return strings.Join(lines, "")
+	}
+
+	// The section ends at the next line that isn't indented. Its keys are
+	// indented like its first one.
+	end, last, indent := len(lines), start, ""
+	for i := start + 1; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" {
+			continue
+		}
+		if !strings.HasPrefix(lines[i], " ") && !strings.HasPrefix(lines[i], "\t") {
+			end = i
+			break
+		}
+		last = i
+		if indent == "" && !strings.HasPrefix(trimmed, "#") {
+			indent = lines[i][:len(lines[i])-len(strings.TrimLeft(lines[i], " \t"))]
+		}
+	}
+	if indent == "" {
+		indent = "  "
+	}
+
+	var added []string
+	for _, s := range settings {
+		prefix := indent + s.Key + ":"
+		found := false
+		for i := start + 1; i < end; i++ {
+			rest, ok := strings.CutPrefix(lines[i], prefix)
+			if !ok || (rest != "" && !strings.ContainsAny(rest[:1], " \t\r\n")) {
+				continue
+			}
+			newline := rest[len(strings.TrimRight(rest, "\r\n")):]
+			lines[i] = prefix + " " + strconv.Quote(s.Value) + trailingComment(strings.TrimRight(rest, "\r\n")) + newline
+			found = true
+			break
+		}
+		if !found {
+			added = append(added, prefix+" "+strconv.Quote(s.Value)+"\n")
+		}
+	}
+	if len(added) > 0 {
+		if !strings.HasSuffix(lines[last], "\n") {
+			lines[last] += "\n"
+		}
+		lines = append(lines[:last+1], append(added, lines[last+1:]...)...)
+	}
+	return strings.Join(lines, "")
+}
+
+// trailingComment returns the comment after a YAML value, with the spaces
+// before it, or ""
+func trailingComment(value string) string {
+	trimmed := strings.TrimLeft(value, " \t")
+	offset := len(value) - len(trimmed)
+	if q := trimmed[:min(1, len(trimmed))]; q == `"` || q == `'` {
+		// Skip the quoted string, which may hold a #
+		i := 1
+		for i < len(trimmed) && trimmed[i:i+1] != q {
+			if q == `"` && trimmed[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		offset += i + 1
+	}
+	if offset > len(value) {
+		return ""
+	}
+	rest := value[offset:]
+	i := strings.Index(rest, " #")
+	if i < 0 {
+		i = strings.Index(rest, "\t#")
+	}
+	if i < 0 {
+		return ""
+	}
+	gap := rest[:i+1]
+	return gap[len(strings.TrimRight(gap, " \t")):] + rest[i+1:]
+}
+
+// UpdateSettings changes keys under a top level section wherever their
+// values come from: op:// references are updated in 1Password, and plain
+// values are written into the config file. Values from ${ENV} references
+// can't be changed; CheckUpdatable reports them before anything changes.
+func UpdateSettings(path, section string, settings []Setting) error {
+	var plain []Setting
+	for _, s := range settings {
+		raw := viper.GetString(section + "." + s.Key)
+		if strings.HasPrefix(raw, "op://") {
+			if err := opItemEdit(raw, s.Value); err != nil {
+				return fmt.Errorf("%s.%s: %w", section, s.Key, err)
+			}
+			continue
+		}
+		plain = append(plain, s)
+	}
+	if len(plain) == 0 {
+		return nil
+	}
+	return WriteSettings(path, section, plain)
+}
+
+// CheckUpdatable reports keys UpdateSettings can't change: values from
+// ${ENV} references, and op:// references to items op can't read
+func CheckUpdatable(section string, keys []string) error {
+	for _, key := range keys {
+		raw := viper.GetString(section + "." + key)
+		switch {
+		case strings.HasPrefix(raw, "op://"):
+			vault, item, _, _, err := parseOpRef(raw)
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", section, key, err)
+			}
+			if out, err := exec.Command("op", "item", "get", item, "--vault", vault).CombinedOutput(); err != nil {
+				return fmt.Errorf("%s.%s: op item get: %s", section, key, strings.TrimSpace(string(out)))
+			}
+		case strings.Contains(raw, "${"):
+			return fmt.Errorf("%s.%s comes from %s, which rcm can't change; use a plain value or an op:// reference", section, key, raw)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetSettings(t *testing.T) {
+	settings := []Setting{
+		{Key: "token", Value: "new-token"},
+		{Key: "server_private_key", Value: "op://Vault/rcm/server_private_key"},
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "replace and add",
+			src: `server:
+  host: vps
+
+rathole:
+  bind_port: 2333
+  # token: commented out
+  token: "old # not a comment"   # keep me
+  server_public_key: abc
+
+client:
+  host: home
+`,
+			want: `server:
+  host: vps
+
+rathole:
+  bind_port: 2333
+  # token: commented out
+  token: "new-token"   # keep me
+  server_public_key: abc
+  server_private_key: "op://Vault/rcm/server_private_key"
+
+client:
+  host: home
+`,
+		},
+		{
+			name: "four space indent at the end of the file",
+			src:  "rathole:\n    server_private_key: old # was here\n    bind_port: 2333",
+			want: "rathole:\n    server_private_key: \"op://Vault/rcm/server_private_key\" # was here\n    bind_port: 2333\n    token: \"new-token\"\n",
+		},
+		{
+			name: "missing section",
+			src:  "server:\n  host: vps\n",
+			want: "server:\n  host: vps\nrathole:\n  token: \"new-token\"\n  server_private_key: \"op://Vault/rcm/server_private_key\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := setSettings(tt.src, "rathole", settings); got != tt.want {
+				t.Errorf("setSettings() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteSettings(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dotfiles", "config.yaml")
+	os.Mkdir(filepath.Dir(target), 0700)
+	os.WriteFile(target, []byte("rathole:\n  token: old\n"), 0640)
+	link := filepath.Join(dir, "config.yaml")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	if err := WriteSettings(link, "rathole", []Setting{{Key: "token", Value: "new"}}); err != nil {
+		t.Fatalf("WriteSettings failed: %v", err)
+	}
+
+	got, _ := os.ReadFile(target)
+	if string(got) != "rathole:\n  token: \"new\"\n" {
+		t.Errorf("Unexpected content: %q", got)
+	}
+	if info, _ := os.Lstat(link); info.Mode()&os.ModeSymlink == 0 {
+		t.Error("Expected the symlink to be kept")
+	}
+	if info, _ := os.Stat(target); info.Mode().Perm() != 0640 {
+		t.Errorf("Expected mode 0640 to be kept, got %o", info.Mode().Perm())
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(target), ".*")); len(leftovers) != 0 {
+		t.Errorf("Expected no temp files, got %v", leftovers)
+	}
+}
+
+func TestSetItemField(t *testing.T) {
+	item := `{"id":"abc","title":"rcm-rathole","fields":[` +
+		`{"id":"notesPlain","label":"notesPlain","type":"STRING","value":""},` +
+		`{"id":"token","label":"token","type":"CONCEALED","value":"old"},` +
+		`{"id":"f1","label":"token","type":"CONCEALED","value":"other","section":{"id":"s1","label":"backup"}}]}`
+
+	got, err := setItemField([]byte(item), "", "token", "new")
+	if err != nil {
+		t.Fatalf("setItemField failed: %v", err)
+	}
+	want := `{"fields":[` +
+		`{"id":"notesPlain","label":"notesPlain","type":"STRING","value":""},` +
+		`{"id":"token","label":"token","type":"CONCEALED","value":"new"},` +
+		`{"id":"f1","label":"token","section":{"id":"s1","label":"backup"},"type":"CONCEALED","value":"other"}],` +
+		`"id":"abc","title":"rcm-rathole"}`
+	if string(got) != want {
+		t.Errorf("setItemField() =\n%s\nwant\n%s", got, want)
+	}
+
+	got, err = setItemField([]byte(item), "backup", "token", "new")
+	if err != nil || !strings.Contains(string(got), `"section":{"id":"s1","label":"backup"},"type":"CONCEALED","value":"new"`) {
+		t.Errorf("Expected the field in the backup section to change, got %s (%v)", got, err)
+	}
+	if _, err := setItemField([]byte(item), "", "missing", "new"); err == nil {
+		t.Error("Expected an error for a missing field")
+	}
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -16,7 +17,31 @@ type resolveTask struct {
 
 // resolveRefs walks all string fields in cfg and resolves op:// and ${ENV} references.
 func resolveRefs(cfg *Config) error {
-	tasks := collectTasks(reflect.ValueOf(cfg).Elem())
+	if err := resolveTasks(collectTasks(reflect.ValueOf(cfg).Elem())); err != nil {
+		return fmt.Errorf("failed to resolve config values:\n%w", err)
+	}
+	return nil
+}
+
+// Resolve replaces op:// and ${ENV} references in values, the same way as
+// in the config file. Other values are left alone.
+func Resolve(values ...*string) error {
+	var tasks []resolveTask
+	for _, v := range values {
+		if isRef(*v) {
+			tasks = append(tasks, resolveTask{ptr: v, raw: *v})
+		}
+	}
+	return resolveTasks(tasks)
+}
+
+// isRef reports whether s needs resolving
+func isRef(s string) bool {
+	return strings.HasPrefix(s, "op://") || strings.Contains(s, "${")
+}
+
+// resolveTasks resolves ${ENV} refs inline and op:// refs in one batch
+func resolveTasks(tasks []resolveTask) error {
 	if len(tasks) == 0 {
 		return nil
 	}
@@ -31,10 +56,7 @@ func resolveRefs(cfg *Config) error {
 		}
 	}
 
-	if err := opInjectBatch(opTasks); err != nil {
-		return fmt.Errorf("failed to resolve config values:\n%w", err)
-	}
-	return nil
+	return opInjectBatch(opTasks)
 }
 
 // opInjectBatch resolves all op:// references in a single `op inject` call.
@@ -82,6 +104,116 @@ func opInjectBatch(tasks []resolveTask) error {
 	return nil
 }
 
+// StoreSecrets saves settings as concealed fields of a new 1Password item
+// and returns them as op:// references to those fields
+func StoreSecrets(vault, item string, secrets []Setting) ([]Setting, error) {
+	if err := exec.Command("op", "item", "get", item, "--vault", vault).Run(); err == nil {
+		return nil, fmt.Errorf("1Password item %q already exists in vault %q", item, vault)
+	}
+
+	type field struct {
+		ID    string `json:"id"`
+		Label string `json:"label"`
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	template := struct {
+		Title    string  `json:"title"`
+		Category string  `json:"category"`
+		Fields   []field `json:"fields"`
+	}{Title: item, Category: "SECURE_NOTE"}
+	refs := make([]Setting, len(secrets))
+	for i, s := range secrets {
+		template.Fields = append(template.Fields, field{ID: s.Key, Label: s.Key, Type: "CONCEALED", Value: s.Value})
+		refs[i] = Setting{Key: s.Key, Value: fmt.Sprintf("op://%s/%s/%s", vault, item, s.Key)}
+	}
+	input, err := json.Marshal(template)
+	if err != nil {
+		return nil, err
+	}
+
+	// The template goes through stdin so the secrets never show up in the
+	// process list
+	cmd := exec.Command("op", "item", "create", "--vault", vault)
+	cmd.Stdin = strings.NewReader(string(input))
+	if _, err := cmd.Output(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("op item create: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("op item create: %w", err)
+	}
+	return refs, nil
+}
+
+// opItemEdit sets the field an op:// reference points at. The item is
+// fetched as JSON and sent back as a template through stdin, like
+// StoreSecrets does, so the value never shows up in the process list.
+func opItemEdit(ref, value string) error {
+	vault, item, section, field, err := parseOpRef(ref)
+	if err != nil {
+		return err
+	}
+	current, err := exec.Command("op", "item", "get", item, "--vault", vault, "--format", "json").Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("op item get: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return fmt.Errorf("op item get: %w", err)
+	}
+	template, err := setItemField(current, section, field, value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", ref, err)
+	}
+
+	cmd := exec.Command("op", "item", "edit", item, "--vault", vault)
+	cmd.Stdin = strings.NewReader(string(template))
+	if _, err := cmd.Output(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("op item edit: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return fmt.Errorf("op item edit: %w", err)
+	}
+	return nil
+}
+
+// setItemField changes the value of a field in an item as op item get
+// prints it in JSON, matching the field and its section by label or ID.
+// Everything else in the item is kept.
+func setItemField(itemJSON []byte, section, field, value string) ([]byte, error) {
+	var item map[string]any
+	if err := json.Unmarshal(itemJSON, &item); err != nil {
+		return nil, fmt.Errorf("read item: %w", err)
+	}
+	fields, _ := item["fields"].([]any)
+	for _, f := range fields {
+		m, ok := f.(map[string]any)
+		if !ok || (m["label"] != field && m["id"] != field) {
+			continue
+		}
+		if section != "" {
+			s, _ := m["section"].(map[string]any)
+			if s == nil || (s["label"] != section && s["id"] != section) {
+				continue
+			}
+		}
+		m["value"] = value
+		return json.Marshal(item)
+	}
+	return nil, fmt.Errorf("no field %s in the item", field)
+}
+
+// parseOpRef splits op://vault/item/[section/]field
+func parseOpRef(ref string) (vault, item, section, field string, err error) {
+	parts := strings.Split(strings.TrimPrefix(ref, "op://"), "/")
+	switch len(parts) {
+	case 3:
+		return parts[0], parts[1], "", parts[2], nil
+	case 4:
+		return parts[0], parts[1], parts[2], parts[3], nil
+	}
+	return "", "", "", "", fmt.Errorf("can't tell the item and field of %s", ref)
+}
+
 // collectTasks recursively walks struct fields and collects string fields needing resolution.
 func collectTasks(v reflect.Value) []resolveTask {
 	var tasks []resolveTask
@@ -92,9 +224,15 @@ func collectTasks(v reflect.Value) []resolveTask {
 		switch field.Kind() {
 		case reflect.Struct:
 			tasks = append(tasks, collectTasks(field)...)
+		case reflect.Slice:
+			for j := range field.Len() {
+				if elem := field.Index(j); elem.Kind() == reflect.Struct {
+					tasks = append(tasks, collectTasks(elem)...)
+				}
+			}
 		case reflect.String:
 			s := field.String()
-			if strings.HasPrefix(s, "op://") || strings.Contains(s, "${") {
+			if isRef(s) {
 				tasks = append(tasks, resolveTask{
 					ptr: field.Addr().Interface().(*string),
 					raw: s,
@@ -3,14 +3,17 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // Config is the root configuration structure
 type Config struct {
-	Paths   PathsConfig   `mapstructure:"paths"`
-	Server  ServerConfig  `mapstructure:"server"`
-	Client  ClientConfig  `mapstructure:"client"`
-	Rathole RatholeConfig `mapstructure:"rathole"`
+	Paths    PathsConfig     `mapstructure:"paths"`
+	Server   ServerConfig    `mapstructure:"server"`
+	Client   ClientConfig    `mapstructure:"client"`  // The first of Clients, which services without @client go to
+	Clients  []ClientConfig  `mapstructure:"clients"` // Every home machine, just Client when clients: isn't set
+	Rathole  RatholeConfig   `mapstructure:"rathole"`
+	Services []ServiceConfig `mapstructure:"services"`
 }
 
 // PathsConfig holds local path settings
@@ -21,28 +24,135 @@ type PathsConfig struct {
 
 // ServerConfig holds VPS connection settings
 type ServerConfig struct {
-	Host            string `mapstructure:"host"`
-	User            string `mapstructure:"user"`
-	SSHKey          string `mapstructure:"ssh_key"`
-	RatholeConfig   string `mapstructure:"rathole_config"`
-	Caddyfile       string `mapstructure:"caddyfile"`
-	CaddyComposeDir string `mapstructure:"caddy_compose_dir"`
+	Host             string   `mapstructure:"host"`
+	TunnelHost       string   `mapstructure:"tunnel_host"` // Address clients reach rathole on, the HostName of host by default
+	User             string   `mapstructure:"user"`
+	SSHKey           string   `mapstructure:"ssh_key"`
+	SSHKeyPassphrase string   `mapstructure:"ssh_key_passphrase"`
+	AuthMethods      []string `mapstructure:"auth_methods"`
+	ProxyJump        string   `mapstructure:"proxy_jump"`
+	RatholeConfig    string   `mapstructure:"rathole_config"`
+	Backups          int      `mapstructure:"backups"`
+	Caddyfile        string   `mapstructure:"caddyfile"`
+	CaddyComposeDir  string   `mapstructure:"caddy_compose_dir"`
+	CaddyService     string   `mapstructure:"caddy_service"`
+	CaddyConfig      string   `mapstructure:"caddy_config"` // The Caddyfile's path inside the Caddy container
+	CaddyReload      string   `mapstructure:"caddy_reload"`
+	CaddyValidate    string   `mapstructure:"caddy_validate"`
 }
 
+// Ways of applying a new Caddyfile
+const (
+	CaddyReloadGraceful = "reload"  // caddy reload inside the container
+	CaddyReloadRestart  = "restart" // docker compose restart
+)
+
+// Where the Caddyfile is validated before it goes live
+const (
+	CaddyValidateAuto    = "auto"    // compose if caddy_compose_dir is set, else native if installed
+	CaddyValidateCompose = "compose" // inside the Caddy compose service
+	CaddyValidateNative  = "native"  // with the caddy binary on the VPS
+	CaddyValidateOff     = "off"
+)
+
 // ClientConfig holds home machine connection settings
 type ClientConfig struct {
-	Host          string `mapstructure:"host"`
-	User          string `mapstructure:"user"`
-	SSHKey        string `mapstructure:"ssh_key"`
-	RatholeConfig string `mapstructure:"rathole_config"`
+	Name             string   `mapstructure:"name"`  // What "# service@name:" comments call it, "" for a lone client:
+	Token            string   `mapstructure:"token"` // Token of this client's services, rathole.token when empty
+	Host             string   `mapstructure:"host"`
+	User             string   `mapstructure:"user"`
+	SSHKey           string   `mapstructure:"ssh_key"`
+	SSHKeyPassphrase string   `mapstructure:"ssh_key_passphrase"`
+	AuthMethods      []string `mapstructure:"auth_methods"`
+	ProxyJump        string   `mapstructure:"proxy_jump"`
+	RatholeConfig    string   `mapstructure:"rathole_config"`
+	Backups          int      `mapstructure:"backups"`
 }
 
 // RatholeConfig holds rathole-specific settings
 type RatholeConfig struct {
-	BindPort         int    `mapstructure:"bind_port"`
-	Token            string `mapstructure:"token"`
-	ServerPrivateKey string `mapstructure:"server_private_key"`
-	ServerPublicKey  string `mapstructure:"server_public_key"`
+	BindPort         int             `mapstructure:"bind_port"`
+	Token            string          `mapstructure:"token"`
+	Transport        string          `mapstructure:"transport"`
+	ServerPrivateKey string          `mapstructure:"server_private_key"`
+	ServerPublicKey  string          `mapstructure:"server_public_key"`
+	Noise            NoiseConfig     `mapstructure:"noise"`
+	TLS              TLSConfig       `mapstructure:"tls"`
+	Websocket        WebsocketConfig `mapstructure:"websocket"`
+	PortRangeStart   int             `mapstructure:"port_range_start"`
+	PortRangeEnd     int             `mapstructure:"port_range_end"`
+	CheckPorts       bool            `mapstructure:"check_ports"`
+}
+
+// Rathole transports
+const (
+	TransportTCP       = "tcp"
+	TransportTLS       = "tls"
+	TransportNoise     = "noise"
+	TransportWebsocket = "websocket"
+)
+
+// NoiseConfig holds the noise transport settings. The server's keys are
+// server_private_key and server_public_key.
+type NoiseConfig struct {
+	Pattern          string `mapstructure:"pattern"`            // Rathole's default, Noise_NK_25519_ChaChaPoly_BLAKE2s, when empty
+	ClientPrivateKey string `mapstructure:"client_private_key"` // For patterns where the client has a static key
+	ClientPublicKey  string `mapstructure:"client_public_key"`  // For patterns where the server knows it in advance
+}
+
+// TLSConfig holds the tls transport settings, also used by websocket with
+// tls on. Paths are on the machine that reads them.
+type TLSConfig struct {
+	PKCS12         string `mapstructure:"pkcs12"`          // Server certificate and key, on the VPS
+	PKCS12Password string `mapstructure:"pkcs12_password"` // Password of the pkcs12 file
+	TrustedRoot    string `mapstructure:"trusted_root"`    // CA certificate the client trusts, system roots when empty
+	Hostname       string `mapstructure:"hostname"`        // Name the client checks the certificate for, server.host when empty
+}
+
+// WebsocketConfig holds the websocket transport settings
+type WebsocketConfig struct {
+	TLS bool `mapstructure:"tls"` // Wrap the websocket in TLS, configured under tls
+}
+
+// ServiceConfig is a TCP or UDP service exposed straight on a VPS port,
+// without a Caddy site
+type ServiceConfig struct {
+	Name      string `mapstructure:"name"`
+	LocalAddr string `mapstructure:"local_addr"`
+	VPSPort   int    `mapstructure:"vps_port"`  // Defaults to the port of local_addr
+	Protocol  string `mapstructure:"protocol"`  // tcp or udp, tcp by default
+	BindAddr  string `mapstructure:"bind_addr"` // VPS address to listen on, all of them by default
+	Client    string `mapstructure:"client"`    // Name of the client it runs on, the first one by default
+}
+
+// Label returns the client's name, or its host when it has none
+func (c ClientConfig) Label() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Host
+}
+
+// ClientHosts returns the hosts of every client, comma separated
+func (c *Config) ClientHosts() string {
+	hosts := make([]string, len(c.Clients))
+	for i, client := range c.Clients {
+		hosts[i] = client.Host
+	}
+	return strings.Join(hosts, ", ")
+}
+
+// ClientNamed returns the client with the given name; "" is the first one
+func (c *Config) ClientNamed(name string) (ClientConfig, bool) {
+	if name == "" {
+		return c.Client, true
+	}
+	for _, client := range c.Clients {
+		if client.Name == name {
+			return client, true
+		}
+	}
+	return ClientConfig{}, false
 }
 
 // ExpandPath expands ~ to home directory
@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/parser"
+)
+
+// checkClients reports services that run on a client config.yaml doesn't
+// have
+func checkClients(cfg *config.Config, services []parser.Service) error {
+	for _, svc := range services {
+		// config.yaml services were checked when it was loaded
+		if _, ok := cfg.ClientNamed(svc.Client); !ok {
+			return fmt.Errorf("service %s (%s) runs on client %s, which isn't under clients: in config.yaml", svc.Name, svc.Pos, svc.Client)
+		}
+	}
+	return nil
+}
+
+// clientServices returns the services that run on client. Services that
+// don't name a client run on the first one.
+func clientServices(cfg *config.Config, client config.ClientConfig, services []parser.Service) []parser.Service {
+	var own []parser.Service
+	for _, svc := range services {
+		c, _ := cfg.ClientNamed(svc.Client)
+		if c.Name == client.Name {
+			own = append(own, svc)
+		}
+	}
+	return own
+}
+
+// clientLabel returns the label of the client a service names, which is
+// the first client's when it names none
+func clientLabel(cfg *config.Config, name string) string {
+	if c, ok := cfg.ClientNamed(name); ok {
+		return c.Label()
+	}
+	return name
+}
+
+// applyClientTokens gives the services of clients with their own token
+// that token, unless an "# rcm: token" option already set one
+func applyClientTokens(cfg *config.Config, services []parser.Service) {
+	for i := range services {
+		c, _ := cfg.ClientNamed(services[i].Client)
+		if services[i].Options.Token == "" && c.Token != "" {
+			services[i].Options.Token = c.Token
+		}
+	}
+}
+
+// selectClients returns the clients with the given names, or every
+// client when names is nil
+func selectClients(cfg *config.Config, names []string) []config.ClientConfig {
+	if names == nil {
+		return cfg.Clients
+	}
+	var clients []config.ClientConfig
+	for _, name := range names {
+		if c, ok := cfg.ClientNamed(name); ok {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
+// eachClient runs task on the clients concurrently and reports them as one
+// TargetClient event for step: unchanged when no task changed anything and
+// done with message otherwise. Tasks report their own failures, naming the
+// client's host.
+func eachClient(clients []config.ClientConfig, step Step, message string, events chan<- Event, task func(config.ClientConfig) (bool, error)) error {
+	changed := make([]bool, len(clients))
+	tasks := make([]func() error, len(clients))
+	for i, c := range clients {
+		tasks[i] = func() error {
+			var err error
+			changed[i], err = task(c)
+			return err
+		}
+	}
+	if err := runParallel(tasks...); err != nil {
+		return err
+	}
+
+	status := StatusUnchanged
+	for _, c := range changed {
+		if c {
+			status = StatusDone
+		}
+	}
+	emit(events, Event{Step: step, Target: TargetClient, Status: status, Message: message})
+	return nil
+}
+
+// clientFile labels the client.toml of c, by the client's name when
+// there are several
+func clientFile(cfg *config.Config, c config.ClientConfig) string {
+	if len(cfg.Clients) < 2 {
+		return "client.toml"
+	}
+	return c.Label() + "/client.toml"
+}
+
+// clientNames returns the names of clients
+func clientNames(clients []config.ClientConfig) []string {
+	names := make([]string, len(clients))
+	for i, c := range clients {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// hostsOf returns the hosts of clients, comma separated
+func hostsOf(clients []config.ClientConfig) string {
+	hosts := make([]string, len(clients))
+	for i, c := range clients {
+		hosts[i] = c.Host
+	}
+	return strings.Join(hosts, ", ")
+}
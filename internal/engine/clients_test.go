@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/parser"
+)
+
+func TestClientServices(t *testing.T) {
+	cfg := &config.Config{Clients: []config.ClientConfig{
+		{Name: "nas", Host: "nas.lan"},
+		{Name: "pi", Host: "pi.lan", Token: "pi-token"},
+	}}
+	cfg.Client = cfg.Clients[0]
+	services := []parser.Service{
+		{Name: "plex"},
+		{Name: "jellyfin", Client: "nas"},
+		{Name: "pihole", Client: "pi"},
+		{Name: "grafana", Client: "pi", Options: parser.Options{Token: "own"}},
+	}
+
+	var nas []string
+	for _, svc := range clientServices(cfg, cfg.Clients[0], services) {
+		nas = append(nas, svc.Name)
+	}
+	if len(nas) != 2 || nas[0] != "plex" || nas[1] != "jellyfin" {
+		t.Errorf("Expected services without a client on the first one, got %v", nas)
+	}
+
+	applyClientTokens(cfg, services)
+	for _, want := range []struct{ name, token string }{{"plex", ""}, {"pihole", "pi-token"}, {"grafana", "own"}} {
+		for _, svc := range services {
+			if svc.Name == want.name && svc.Options.Token != want.token {
+				t.Errorf("%s token = %q, want %q", svc.Name, svc.Options.Token, want.token)
+			}
+		}
+	}
+
+	if err := checkClients(cfg, services); err != nil {
+		t.Errorf("checkClients failed: %v", err)
+	}
+	if err := checkClients(cfg, []parser.Service{{Name: "x", Client: "desktop"}}); err == nil {
+		t.Error("Expected an error for an unknown client")
+	}
+}
@@ -0,0 +1,164 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/pmezard/go-difflib/difflib"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/ssh"
+)
+
+// FileDiff compares a local file with the one deployed on a machine
+type FileDiff struct {
+	Name    string // File label, e.g. "server.toml" or "nas/client.toml"
+	Target  Target // Machine the file is deployed to
+	Path    string // Remote path
+	Missing bool   // The file isn't deployed yet
+	Unified string // Unified diff from deployed to local, empty if identical
+}
+
+// Changed reports whether syncing would change the file
+func (d FileDiff) Changed() bool {
+	return d.Unified != ""
+}
+
+// Diff fetches the deployed Caddyfile and rathole configs and compares them
+// with the ones in plan. Nothing is changed remotely.
+func Diff(cfg *config.Config, plan *SyncPlan, events chan<- Event) ([]FileDiff, error) {
+	emit(events, Event{Step: StepCompare, Target: TargetServer, Status: StatusRunning})
+	emit(events, Event{Step: StepCompare, Target: TargetClient, Status: StatusRunning})
+
+	var serverDiffs, clientDiffs []FileDiff
+	err := runParallel(
+		func() error {
+			var err error
+			serverDiffs, err = diffServer(cfg, plan, events)
+			return err
+		},
+		func() error {
+			var err error
+			clientDiffs, err = diffClients(cfg, plan, events)
+			return err
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(serverDiffs, clientDiffs...), nil
+}
+
+func diffServer(cfg *config.Config, plan *SyncPlan, events chan<- Event) ([]FileDiff, error) {
+	client, err := connectServer(cfg)
+	if err != nil {
+		return nil, fail(events, StepCompare, TargetServer, fmt.Sprintf("Couldn't connect to server (%s)", cfg.Server.Host), err)
+	}
+	// Don't close - connection is pooled and reused
+
+	var diffs []FileDiff
+	if cfg.Server.Caddyfile != "" {
+		d, err := diffFile(client, "Caddyfile", TargetServer, cfg.Server.Caddyfile, plan.Caddyfile)
+		if err != nil {
+			return nil, fail(events, StepCompare, TargetServer, "Couldn't read Caddyfile on server", err)
+		}
+		diffs = append(diffs, d)
+
+		for _, rel := range sortedPaths(plan.Imports) {
+			d, err := diffFile(client, rel, TargetServer, remoteImportPath(cfg, rel), plan.Imports[rel])
+			if err != nil {
+				return nil, fail(events, StepCompare, TargetServer, fmt.Sprintf("Couldn't read %s on server", rel), err)
+			}
+			diffs = append(diffs, d)
+		}
+	}
+
+	d, err := diffFile(client, "server.toml", TargetServer, cfg.Server.RatholeConfig, plan.ServerTOML)
+	if err != nil {
+		return nil, fail(events, StepCompare, TargetServer, "Couldn't read rathole config on server", err)
+	}
+	diffs = append(diffs, d)
+
+	emit(events, Event{Step: StepCompare, Target: TargetServer, Status: StatusDone, Message: changedSummary(diffs)})
+	return diffs, nil
+}
+
+// diffClients compares the client.toml of every client concurrently
+func diffClients(cfg *config.Config, plan *SyncPlan, events chan<- Event) ([]FileDiff, error) {
+	diffs := make([]FileDiff, len(cfg.Clients))
+	tasks := make([]func() error, len(cfg.Clients))
+	for i, c := range cfg.Clients {
+		tasks[i] = func() error {
+			client, err := connectClient(cfg, c)
+			if err != nil {
+				return fail(events, StepCompare, TargetClient, fmt.Sprintf("Couldn't connect to client (%s)", c.Host), err)
+			}
+			// Don't close - connection is pooled and reused
+
+			diffs[i], err = diffFile(client, clientFile(cfg, c), TargetClient, c.RatholeConfig, plan.ClientTOMLs[c.Name])
+			if err != nil {
+				return fail(events, StepCompare, TargetClient, fmt.Sprintf("Couldn't read rathole config on client (%s)", c.Host), err)
+			}
+			return nil
+		}
+	}
+	if err := runParallel(tasks...); err != nil {
+		return nil, err
+	}
+
+	emit(events, Event{Step: StepCompare, Target: TargetClient, Status: StatusDone, Message: changedSummary(diffs)})
+	return diffs, nil
+}
+
+// diffFile downloads a deployed file and diffs it against local content
+func diffFile(client *ssh.Client, name string, target Target, path, local string) (FileDiff, error) {
+	d := FileDiff{Name: name, Target: target, Path: path}
+
+	exists, err := client.FileExists(path)
+	if err != nil {
+		return d, err
+	}
+	deployed := ""
+	fromFile := fmt.Sprintf("%s:%s", target, path)
+	if exists {
+		if deployed, err = client.DownloadContent(path); err != nil {
+			return d, err
+		}
+	} else {
+		d.Missing = true
+		fromFile = "/dev/null"
+	}
+
+	d.Unified = unifiedDiff(deployed, local, fromFile, "local/"+name)
+	return d, nil
+}
+
+// unifiedDiff returns a unified diff with 3 lines of context, or "" when
+// a and b are identical
+func unifiedDiff(a, b, fromFile, toFile string) string {
+	if a == b {
+		return ""
+	}
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(a),
+		B:        difflib.SplitLines(b),
+		FromFile: fromFile,
+		ToFile:   toFile,
+		Context:  3,
+	})
+	return diff
+}
+
+// changedSummary describes how many of diffs would change
+func changedSummary(diffs []FileDiff) string {
+	changed := 0
+	for _, d := range diffs {
+		if d.Changed() {
+			changed++
+		}
+	}
+	if changed == 0 {
+		return "unchanged"
+	}
+	return fmt.Sprintf("%d of %d files changed", changed, len(diffs))
+}
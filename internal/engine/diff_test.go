@@ -0,0 +1,27 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	if d := unifiedDiff("same\n", "same\n", "a", "b"); d != "" {
+		t.Errorf("Expected no diff for identical content, got %q", d)
+	}
+
+	deployed := "[server]\nbind_addr = \"0.0.0.0:2333\"\n\n[server.services.app]\nbind_addr = \"127.0.0.1:5000\"\n"
+	local := "[server]\nbind_addr = \"0.0.0.0:2333\"\n\n[server.services.app]\nbind_addr = \"127.0.0.1:5001\"\n"
+
+	d := unifiedDiff(deployed, local, "server:/etc/rathole/server.toml", "local/server.toml")
+	for _, want := range []string{
+		"--- server:/etc/rathole/server.toml",
+		"+++ local/server.toml",
+		"-bind_addr = \"127.0.0.1:5000\"",
+		"+bind_addr = \"127.0.0.1:5001\"",
+	} {
+		if !strings.Contains(d, want) {
+			t.Errorf("Expected diff to contain %q, got:\n%s", want, d)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Package engine implements the deployment workflows (sync, pull, restart,
+// status) independently of any user interface. Each operation reports its
+// progress as Events on a channel so the TUI and plain mode can render it
+// however they like.
+package engine
+
+import (
+	"fmt"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/ssh"
+)
+
+// Step identifies a stage of an operation
+type Step string
+
+const (
+	StepParse    Step = "parse"
+	StepGenerate Step = "generate"
+	StepConnect  Step = "connect"
+	StepDownload Step = "download"
+	StepSave     Step = "save"
+	StepValidate Step = "validate"
+	StepUpload   Step = "upload"
+	StepRecord   Step = "record"
+	StepRestart  Step = "restart"
+	StepCheck    Step = "check"
+	StepCompare  Step = "compare"
+	StepTunnel   Step = "tunnel"
+	StepRollback Step = "rollback"
+)
+
+// Target identifies what a step acts on
+type Target string
+
+const (
+	TargetLocal  Target = "local"
+	TargetServer Target = "server"
+	TargetClient Target = "client"
+	TargetCaddy  Target = "caddy"
+)
+
+// TaskStatus is the state of a step for a target
+type TaskStatus int
+
+const (
+	StatusRunning TaskStatus = iota
+	StatusDone
+	StatusFailed
+	StatusUnchanged // Skipped because the deployed config already matches
+)
+
+// Event reports progress of a single step
+type Event struct {
+	Step    Step
+	Target  Target
+	Status  TaskStatus
+	Message string // Optional detail, e.g. "3 services"
+	Err     error  // Set when Status is StatusFailed
+}
+
+// Title returns a short human readable description of the event's task
+func (e Event) Title() string {
+	switch e.Step {
+	case StepParse:
+		if e.Target == TargetServer {
+			return "Parse remote Caddyfile"
+		}
+		return "Parse Caddyfile"
+	case StepGenerate:
+		return "Generate configs"
+	case StepConnect:
+		return fmt.Sprintf("Connect to %s", e.Target)
+	case StepDownload:
+		return "Download Caddyfile"
+	case StepSave:
+		return "Save Caddyfile"
+	case StepValidate:
+		return "Validate Caddyfile"
+	case StepUpload:
+		return fmt.Sprintf("Upload %s", e.Target)
+	case StepRecord:
+		return "Record snapshot"
+	case StepRestart:
+		return fmt.Sprintf("Restart %s", serviceName(e.Target))
+	case StepCheck:
+		return fmt.Sprintf("Check %s", e.Target)
+	case StepCompare:
+		return fmt.Sprintf("Compare %s configs", e.Target)
+	case StepTunnel:
+		return "Check tunnel"
+	case StepRollback:
+		return "Roll back"
+	}
+	return string(e.Step)
+}
+
+// Error is returned when a step fails
+type Error struct {
+	Step     Step
+	Target   Target
+	Friendly string // Short message suitable for display
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Friendly, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// emit sends an event if a channel was provided
+func emit(events chan<- Event, ev Event) {
+	if events != nil {
+		events <- ev
+	}
+}
+
+// fail emits a failure event and returns the matching error
+func fail(events chan<- Event, step Step, target Target, friendly string, err error) *Error {
+	emit(events, Event{Step: step, Target: target, Status: StatusFailed, Message: friendly, Err: err})
+	return &Error{Step: step, Target: target, Friendly: friendly, Err: err}
+}
+
+// serviceName returns the unit managed for a restart target
+func serviceName(t Target) string {
+	switch t {
+	case TargetServer:
+		return "rathole-server"
+	case TargetClient:
+		return "rathole-client"
+	case TargetCaddy:
+		return "caddy"
+	}
+	return string(t)
+}
+
+// serverEndpoint returns the SSH settings of the VPS
+func serverEndpoint(cfg *config.Config) ssh.Endpoint {
+	ep := ssh.Endpoint{
+		Host:        cfg.Server.Host,
+		User:        cfg.Server.User,
+		DefaultUser: "root",
+		KeyPath:     cfg.Server.SSHKey,
+		Passphrase:  cfg.Server.SSHKeyPassphrase,
+		AuthMethods: cfg.Server.AuthMethods,
+	}
+	ep.Jumps = jumpEndpoints(cfg.Server.ProxyJump, ep, nil)
+	return ep
+}
+
+// clientEndpoint returns the SSH settings of a home machine
+func clientEndpoint(cfg *config.Config, c config.ClientConfig) ssh.Endpoint {
+	ep := ssh.Endpoint{
+		Host:        c.Host,
+		User:        c.User,
+		KeyPath:     c.SSHKey,
+		Passphrase:  c.SSHKeyPassphrase,
+		AuthMethods: c.AuthMethods,
+	}
+	server := serverEndpoint(cfg)
+	ep.Jumps = jumpEndpoints(c.ProxyJump, ep, &server)
+	return ep
+}
+
+// jumpEndpoints turns a proxy_jump spec into the hops to reach target.
+// A hop naming the VPS uses the server settings, so the pooled server
+// connection carries the tunnel; other hops log in with target's keys.
+func jumpEndpoints(spec string, target ssh.Endpoint, server *ssh.Endpoint) []ssh.Endpoint {
+	hops := ssh.ParseProxyJump(spec)
+	for i, hop := range hops {
+		if server != nil && hop.Host == server.Host && (hop.User == "" || hop.User == server.User) {
+			hops[i] = *server
+			continue
+		}
+		hops[i].KeyPath = target.KeyPath
+		hops[i].Passphrase = target.Passphrase
+		hops[i].AuthMethods = target.AuthMethods
+	}
+	return hops
+}
+
+// serverUpload returns the upload options for files on the VPS
+func serverUpload(cfg *config.Config) ssh.UploadOptions {
+	return ssh.UploadOptions{Backups: cfg.Server.Backups}
+}
+
+// caddyUpload returns the upload options for the Caddyfile and its
+// imports. They're overwritten in place, since a Caddy container that
+// mounts just the Caddyfile would keep reading a file renamed over it.
+func caddyUpload(cfg *config.Config) ssh.UploadOptions {
+	opts := serverUpload(cfg)
+	opts.InPlace = true
+	return opts
+}
+
+// clientUpload returns the upload options for files on a home machine
+func clientUpload(c config.ClientConfig) ssh.UploadOptions {
+	return ssh.UploadOptions{Backups: c.Backups}
+}
+
+// tunnelHost returns the address clients reach rathole on. server.host is
+// what rcm connects to over SSH, which may be an alias or carry the SSH
+// port, so unless tunnel_host is set it's resolved to its HostName.
+func tunnelHost(cfg *config.Config) string {
+	if cfg.Server.TunnelHost != "" {
+		return cfg.Server.TunnelHost
+	}
+	return ssh.HostName(cfg.Server.Host)
+}
+
+// connectServer returns the pooled connection to the VPS
+func connectServer(cfg *config.Config) (*ssh.Client, error) {
+	return ssh.GetClient(serverEndpoint(cfg))
+}
+
+// connectClient returns the pooled connection to a home machine
+func connectClient(cfg *config.Config, c config.ClientConfig) (*ssh.Client, error) {
+	return ssh.GetClient(clientEndpoint(cfg, c))
+}
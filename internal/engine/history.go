@@ -0,0 +1,320 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/parser"
+	"github.com/AhmedAburady/rcm-go/internal/ssh"
+)
+
+// historyLimit is how many snapshots are kept locally and on each remote
+const historyLimit = 20
+
+// remoteHistoryDir holds the snapshots on each remote, readable only by
+// the SSH user since they contain the rathole token and keys
+const remoteHistoryDir = "~/.rcm/history"
+
+// Snapshot is the configuration set deployed by one sync
+type Snapshot struct {
+	ID          string            `json:"id"`
+	Time        time.Time         `json:"time"`
+	ServerHost  string            `json:"server_host"`
+	ClientHost  string            `json:"client_host"` // Hosts of every client, comma separated
+	Services    []string          `json:"services"`
+	Caddyfile   string            `json:"caddyfile,omitempty"`
+	Imports     map[string]string `json:"caddyfile_imports,omitempty"`
+	ServerTOML  string            `json:"server_toml,omitempty"`
+	ClientTOML  string            `json:"client_toml,omitempty"`  // Set by rcm versions with a single client
+	ClientTOMLs map[string]string `json:"client_tomls,omitempty"` // By client name
+	RollbackOf  string            `json:"rollback_of,omitempty"`  // ID of the snapshot a rollback restored
+}
+
+// snapshotIDFormat has millisecond precision so that deployments in the
+// same second don't overwrite each other's snapshots. IDs sort by time.
+const snapshotIDFormat = "20060102-150405.000"
+
+// HistoryDir returns the local directory where snapshots are stored
+func HistoryDir() string {
+	return config.ExpandPath("~/.config/rcm/history")
+}
+
+// newSnapshot describes the deployment of plan
+func newSnapshot(cfg *config.Config, plan *SyncPlan) *Snapshot {
+	now := time.Now()
+	snap := &Snapshot{
+		ID:          now.UTC().Format(snapshotIDFormat),
+		Time:        now,
+		ServerHost:  cfg.Server.Host,
+		ClientHost:  cfg.ClientHosts(),
+		Caddyfile:   plan.Caddyfile,
+		Imports:     plan.Imports,
+		ServerTOML:  plan.ServerTOML,
+		ClientTOMLs: plan.ClientTOMLs,
+		RollbackOf:  plan.RollbackOf,
+	}
+	for _, svc := range plan.Services {
+		snap.Services = append(snap.Services, svc.Name)
+	}
+	return snap
+}
+
+// serverPart and clientPart are the parts stored on each remote, so a
+// client never holds the server's private key or another client's config
+func (s *Snapshot) serverPart() *Snapshot {
+	part := *s
+	part.ClientTOML = ""
+	part.ClientTOMLs = nil
+	return &part
+}
+
+func (s *Snapshot) clientPart(name string) *Snapshot {
+	part := *s
+	part.Caddyfile = ""
+	part.Imports = nil
+	part.ServerTOML = ""
+	part.ClientTOMLs = map[string]string{name: s.ClientTOMLs[name]}
+	return &part
+}
+
+// clientTOMLs returns the client.toml of every client in cfg. Snapshots
+// from before clients: hold one, which belongs to the first client.
+func (s *Snapshot) clientTOMLs(cfg *config.Config) (map[string]string, error) {
+	tomls := make(map[string]string)
+	for name, content := range s.ClientTOMLs {
+		tomls[name] = content
+	}
+	if s.ClientTOML != "" {
+		if _, ok := tomls[cfg.Client.Name]; !ok {
+			tomls[cfg.Client.Name] = s.ClientTOML
+		}
+	}
+	for _, c := range cfg.Clients {
+		if _, ok := tomls[c.Name]; !ok {
+			return nil, fmt.Errorf("snapshot %s has no client.toml for %s", s.ID, c.Label())
+		}
+	}
+	return tomls, nil
+}
+
+// plan turns the snapshot back into something Sync can deploy to the
+// clients in cfg
+func (s *Snapshot) plan(cfg *config.Config) (*SyncPlan, error) {
+	tomls, err := s.clientTOMLs(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	files := parser.MapFS{"Caddyfile": s.Caddyfile}
+	for path, content := range s.Imports {
+		files[path] = content
+	}
+
+	var services []parser.Service
+	if caddyfile, err := parser.Load("Caddyfile", files); err == nil {
+		services = caddyfile.Services()
+	}
+	// Rolling back to a rollback restores the snapshot it restored
+	restores := s.ID
+	if s.RollbackOf != "" {
+		restores = s.RollbackOf
+	}
+	return &SyncPlan{
+		Services:    services,
+		Caddyfile:   s.Caddyfile,
+		Imports:     s.Imports,
+		ServerTOML:  s.ServerTOML,
+		ClientTOMLs: tomls,
+		RollbackOf:  restores,
+	}, nil
+}
+
+// History returns the locally recorded snapshots, newest first
+func History() ([]Snapshot, error) {
+	files, err := filepath.Glob(filepath.Join(HistoryDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var snaps []Snapshot
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var snap Snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("read %s: %w", file, err)
+		}
+		snaps = append(snaps, snap)
+	}
+
+	sort.Slice(snaps, func(i, j int) bool {
+		return snaps[i].ID > snaps[j].ID
+	})
+	return snaps, nil
+}
+
+// PreviousDeployment returns the index in snaps, newest first, of the
+// deployment before the current one, or -1 when there is none. A rollback
+// counts as the deployment it restored and is never picked itself, so
+// rolling back again steps further back instead of undoing the rollback.
+func PreviousDeployment(snaps []Snapshot) int {
+	if len(snaps) == 0 {
+		return -1
+	}
+	current := 0
+	if restored := snaps[0].RollbackOf; restored != "" {
+		current = -1
+		for i, snap := range snaps {
+			if snap.ID == restored {
+				current = i
+			}
+		}
+		if current < 0 {
+			// The restored snapshot has been pruned, so nothing is older
+			return -1
+		}
+	}
+	for i := current + 1; i < len(snaps); i++ {
+		if snaps[i].RollbackOf == "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// LoadSnapshot returns the snapshot with the given ID, or the one
+// PreviousDeployment picks when id is empty. Snapshots missing locally,
+// e.g. on a new machine, are put together from the copies kept on the
+// remotes.
+func LoadSnapshot(cfg *config.Config, id string) (*Snapshot, error) {
+	if id == "" {
+		snaps, err := History()
+		if err != nil {
+			return nil, err
+		}
+		i := PreviousDeployment(snaps)
+		if i < 0 {
+			return nil, errors.New("no previous deployment to roll back to")
+		}
+		return &snaps[i], nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(HistoryDir(), id+".json"))
+	if err == nil {
+		var snap Snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("read snapshot %s: %w", id, err)
+		}
+		return &snap, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return remoteSnapshot(cfg, id)
+}
+
+// remoteSnapshot assembles a snapshot from the server and client copies
+func remoteSnapshot(cfg *config.Config, id string) (*Snapshot, error) {
+	server, err := connectServer(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("connect to server: %w", err)
+	}
+	var snap Snapshot
+	if err := readRemoteSnapshot(server, id, &snap); err != nil {
+		return nil, fmt.Errorf("snapshot %s not found locally or on server: %w", id, err)
+	}
+
+	snap.ClientTOMLs = make(map[string]string)
+	for _, c := range cfg.Clients {
+		client, err := connectClient(cfg, c)
+		if err != nil {
+			return nil, fmt.Errorf("connect to client %s: %w", c.Host, err)
+		}
+		var part Snapshot
+		if err := readRemoteSnapshot(client, id, &part); err != nil {
+			return nil, fmt.Errorf("snapshot %s not found locally or on client %s: %w", id, c.Host, err)
+		}
+		content, ok := part.ClientTOMLs[c.Name]
+		if !ok {
+			content = part.ClientTOML
+		}
+		snap.ClientTOMLs[c.Name] = content
+	}
+	return &snap, nil
+}
+
+func readRemoteSnapshot(client *ssh.Client, id string, snap *Snapshot) error {
+	content, err := client.DownloadContent(remoteHistoryDir + "/" + id + ".json")
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(content), snap)
+}
+
+// saveLocalSnapshot writes the snapshot to the local history and drops the
+// oldest ones beyond historyLimit
+func saveLocalSnapshot(snap *Snapshot) error {
+	dir := HistoryDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, snap.ID+".json"), data, 0600); err != nil {
+		return err
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	for _, old := range files[min(len(files), historyLimit):] {
+		os.Remove(old)
+	}
+	return nil
+}
+
+// saveRemoteSnapshot stores part of a snapshot on a remote
+func saveRemoteSnapshot(client *ssh.Client, part *Snapshot) error {
+	data, err := json.MarshalIndent(part, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := remoteHistoryDir + "/" + part.ID + ".json"
+	if err := client.UploadContent(string(data), path, ssh.UploadOptions{Mode: 0600, AsUser: true}); err != nil {
+		return err
+	}
+	return client.RemoveOldFiles(remoteHistoryDir, ".json", historyLimit)
+}
+
+// Rollback deploys a recorded snapshot to both machines and restarts them
+// the same way Sync does. The rollback is recorded as a new snapshot that
+// names the one it restored.
+func Rollback(cfg *config.Config, snap *Snapshot, opts SyncOptions, events chan<- Event) (*SyncResult, error) {
+	plan, err := snap.plan(cfg)
+	if err != nil {
+		return nil, fail(events, StepGenerate, TargetLocal, "Snapshot doesn't cover every client, nothing was deployed", err)
+	}
+	return Sync(cfg, plan, opts, events)
+}
+
+// Summary returns a one line description of the snapshot
+func (s *Snapshot) Summary() string {
+	services := strings.Join(s.Services, ", ")
+	if services == "" {
+		services = "no services"
+	}
+	return fmt.Sprintf("%s  %s  %s", s.ID, s.Time.Local().Format("2006-01-02 15:04"), services)
+}
@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/parser"
+)
+
+func TestLocalHistory(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	cfg := &config.Config{}
+	cfg.Server.Host = "vps"
+	cfg.Client.Host = "home"
+
+	if _, err := LoadSnapshot(cfg, ""); err == nil {
+		t.Fatal("Expected an error with no previous deployment")
+	}
+
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < historyLimit+2; i++ {
+		snap := newSnapshot(cfg, &SyncPlan{
+			Services:   []parser.Service{{Name: fmt.Sprintf("svc%d", i)}},
+			ServerTOML: fmt.Sprintf("server %d", i),
+		})
+		snap.ID = start.Add(time.Duration(i) * time.Minute).Format(snapshotIDFormat)
+		if err := saveLocalSnapshot(snap); err != nil {
+			t.Fatalf("saveLocalSnapshot failed: %v", err)
+		}
+	}
+
+	snaps, err := History()
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(snaps) != historyLimit {
+		t.Fatalf("Expected %d snapshots to be kept, got %d", historyLimit, len(snaps))
+	}
+	if snaps[0].Services[0] != fmt.Sprintf("svc%d", historyLimit+1) {
+		t.Errorf("Expected newest snapshot first, got %v", snaps[0].Services)
+	}
+
+	// No id means the deployment before the current one
+	prev, err := LoadSnapshot(cfg, "")
+	if err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+	if prev.ServerTOML != fmt.Sprintf("server %d", historyLimit) {
+		t.Errorf("Expected previous deployment, got %q", prev.ServerTOML)
+	}
+
+	byID, err := LoadSnapshot(cfg, snaps[5].ID)
+	if err != nil || byID.ID != snaps[5].ID {
+		t.Errorf("Expected snapshot %s, got %v (%v)", snaps[5].ID, byID, err)
+	}
+}
+
+func TestSnapshotKeepsCaddyfileImports(t *testing.T) {
+	c, err := parser.Load("/etc/caddy/Caddyfile", parser.MapFS{
+		"/etc/caddy/Caddyfile":     "import sites/*.caddy\n",
+		"/etc/caddy/sites/a.caddy": "# app: 192.168.1.10:3000\napp.example.com {\n\treverse_proxy localhost:8001\n}\n",
+	})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	imports, err := importsByPath(c)
+	if err != nil {
+		t.Fatalf("importsByPath failed: %v", err)
+	}
+	if imports["sites/a.caddy"] == "" || len(imports) != 1 {
+		t.Fatalf("Expected imports keyed by relative path, got %v", imports)
+	}
+
+	cfg := &config.Config{}
+	snap := newSnapshot(cfg, &SyncPlan{Caddyfile: c.Files[0].Src, Imports: imports})
+	if snap.clientPart("").Imports != nil {
+		t.Error("Expected the client's part to leave out the Caddyfile imports")
+	}
+	plan, err := snap.plan(cfg)
+	if err != nil {
+		t.Fatalf("plan failed: %v", err)
+	}
+	if len(plan.Services) != 1 || plan.Services[0].Name != "app" {
+		t.Errorf("Expected the imported service back from the snapshot, got %+v", plan.Services)
+	}
+
+	outside, _ := parser.Load("/etc/caddy/Caddyfile", parser.MapFS{
+		"/etc/caddy/Caddyfile": "import ../shared/*.caddy\n",
+		"/etc/shared/a.caddy":  "a.example.com {\n}\n",
+	})
+	if _, err := importsByPath(outside); err == nil {
+		t.Error("Expected an error for an import outside the Caddyfile's directory")
+	}
+}
+
+func TestSnapshotClientTOMLs(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Clients = []config.ClientConfig{{Name: "nas", Host: "nas.lan"}, {Name: "pi", Host: "pi.lan"}}
+	cfg.Client = cfg.Clients[0]
+
+	snap := newSnapshot(cfg, &SyncPlan{ClientTOMLs: map[string]string{"nas": "nas toml", "pi": "pi toml"}})
+	if snap.ClientHost != "nas.lan, pi.lan" {
+		t.Errorf("ClientHost = %q", snap.ClientHost)
+	}
+	if part := snap.clientPart("pi"); len(part.ClientTOMLs) != 1 || part.ClientTOMLs["pi"] != "pi toml" {
+		t.Errorf("Expected the client's part to hold only its own client.toml, got %v", part.ClientTOMLs)
+	}
+	if part := snap.serverPart(); part.ClientTOMLs != nil {
+		t.Errorf("Expected the server's part to leave out the client.toml files, got %v", part.ClientTOMLs)
+	}
+
+	// Snapshots from before clients: belong to the first client
+	legacy := &Snapshot{ID: "old", ClientTOML: "old toml"}
+	if _, err := legacy.plan(cfg); err == nil {
+		t.Error("Expected an error for a snapshot missing a client")
+	}
+	cfg.Clients = cfg.Clients[:1]
+	plan, err := legacy.plan(cfg)
+	if err != nil {
+		t.Fatalf("plan failed: %v", err)
+	}
+	if plan.ClientTOMLs["nas"] != "old toml" {
+		t.Errorf("Expected the old client.toml for the first client, got %v", plan.ClientTOMLs)
+	}
+}
+
+func TestPreviousDeployment(t *testing.T) {
+	// Newest first: deployments 1 to 4, then rollbacks to 3 and to 2
+	snaps := []Snapshot{
+		{ID: "6", RollbackOf: "2"},
+		{ID: "5", RollbackOf: "3"},
+		{ID: "4"},
+		{ID: "3"},
+		{ID: "2"},
+		{ID: "1"},
+	}
+	tests := []struct {
+		from int
+		want string
+	}{
+		{from: 2, want: "3"},
+		{from: 1, want: "2"},
+		{from: 0, want: "1"},
+	}
+	for _, tt := range tests {
+		i := PreviousDeployment(snaps[tt.from:])
+		if i < 0 || snaps[tt.from+i].ID != tt.want {
+			t.Errorf("From %s: got index %d, want %s", snaps[tt.from].ID, i, tt.want)
+		}
+	}
+
+	if i := PreviousDeployment(snaps[4:5]); i != -1 {
+		t.Errorf("Expected no previous deployment with one snapshot, got %d", i)
+	}
+	if i := PreviousDeployment([]Snapshot{{ID: "9", RollbackOf: "pruned"}, {ID: "8"}}); i != -1 {
+		t.Errorf("Expected no previous deployment once the restored one is pruned, got %d", i)
+	}
+
+	// Rolling back to a rollback restores the same snapshot it did
+	cfg := &config.Config{}
+	plan, err := snaps[0].plan(cfg)
+	if err != nil {
+		t.Fatalf("plan failed: %v", err)
+	}
+	if plan.RollbackOf != "2" {
+		t.Errorf("RollbackOf = %q, want 2", plan.RollbackOf)
+	}
+}
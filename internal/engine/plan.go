@@ -0,0 +1,324 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/generator"
+	"github.com/AhmedAburady/rcm-go/internal/parser"
+	"github.com/AhmedAburady/rcm-go/internal/ssh"
+)
+
+// ServiceRow represents a service merged from the local and remote Caddyfiles
+type ServiceRow struct {
+	Name      string
+	LocalAddr string
+	VPSPort   int
+	Protocol  string
+	Domains   []string
+	Client    string // Label of the client it runs on
+	IsLocal   bool
+	IsRemote  bool
+}
+
+// SyncPlan holds everything needed to deploy the local configuration
+type SyncPlan struct {
+	Services    []parser.Service    // Services parsed from the local Caddyfile
+	Rows        []ServiceRow        // Local services compared with the deployed ones
+	Removed     []string            // Deployed services missing from the local Caddyfile
+	Caddyfile   string              // Local Caddyfile content
+	Imports     map[string]string   // Files the Caddyfile imports, by path relative to it
+	Lint        []parser.Diagnostic // Problems in the local Caddyfile, as rcm lint reports them
+	ServerTOML  string
+	ClientTOMLs map[string]string // client.toml of each client, by name
+	RollbackOf  string            // Snapshot a rollback restores, empty for a sync
+}
+
+// Plan parses the local Caddyfile, compares it with the one deployed on the
+// server and generates the rathole configs. Nothing is changed remotely.
+func Plan(cfg *config.Config, events chan<- Event) (*SyncPlan, error) {
+	emit(events, Event{Step: StepParse, Target: TargetLocal, Status: StatusRunning})
+
+	// Fetch local and remote concurrently
+	type remoteResult struct {
+		services  []parser.Service
+		listening map[int]bool
+	}
+	remoteCh := make(chan remoteResult, 1)
+	go func() {
+		services, _ := fetchRemoteServices(cfg)
+		listening, _ := listeningPorts(cfg)
+		remoteCh <- remoteResult{services: services, listening: listening}
+	}()
+
+	caddyfile, err := parser.Load(cfg.Paths.Caddyfile, parser.OSFS)
+	fetched := <-remoteCh
+	remote := fetched.services
+	if err != nil {
+		return nil, fail(events, StepParse, TargetLocal, "Couldn't parse local Caddyfile", err)
+	}
+
+	imports, err := importsByPath(caddyfile)
+	if err != nil {
+		return nil, fail(events, StepParse, TargetLocal, "Couldn't deploy Caddyfile imports", err)
+	}
+
+	local, err := localServices(cfg, caddyfile)
+	if err != nil {
+		return nil, fail(events, StepParse, TargetLocal, "Conflicting service names, nothing was deployed", err)
+	}
+	if err := checkPorts(cfg, local, remote, fetched.listening); err != nil {
+		return nil, fail(events, StepParse, TargetLocal, "Conflicting VPS ports, nothing was deployed", err)
+	}
+	if err := checkClients(cfg, local); err != nil {
+		return nil, fail(events, StepParse, TargetLocal, "Unknown client, nothing was deployed", err)
+	}
+	if err := caddyfile.CheckOptions(); err != nil {
+		return nil, fail(events, StepParse, TargetLocal, "Invalid rcm options, nothing was deployed", err)
+	}
+	if err := resolveTokens(local); err != nil {
+		return nil, fail(events, StepParse, TargetLocal, "Couldn't resolve service tokens", err)
+	}
+	applyClientTokens(cfg, local)
+	plan := &SyncPlan{
+		Services:  local,
+		Caddyfile: caddyfile.Files[0].Src,
+		Imports:   imports,
+		Lint:      caddyfile.Lint(),
+	}
+
+	remoteNames := make(map[string]bool)
+	for _, svc := range remote {
+		remoteNames[svc.Name] = true
+	}
+	localNames := make(map[string]bool)
+	for _, svc := range local {
+		localNames[svc.Name] = true
+		plan.Rows = append(plan.Rows, ServiceRow{
+			Name:      svc.Name,
+			LocalAddr: svc.LocalAddr,
+			VPSPort:   svc.VPSPort,
+			Protocol:  svc.Protocol,
+			Domains:   svc.Domains,
+			Client:    clientLabel(cfg, svc.Client),
+			IsLocal:   true,
+			IsRemote:  remoteNames[svc.Name],
+		})
+	}
+	for _, svc := range remote {
+		if !localNames[svc.Name] {
+			plan.Removed = append(plan.Removed, svc.Name)
+		}
+	}
+
+	sort.Slice(plan.Rows, func(i, j int) bool {
+		return plan.Rows[i].Name < plan.Rows[j].Name
+	})
+	sort.Strings(plan.Removed)
+
+	message := fmt.Sprintf("%d services", len(local))
+	if len(plan.Lint) > 0 {
+		message += fmt.Sprintf(", %d lint problems", len(plan.Lint))
+	}
+	emit(events, Event{
+		Step:    StepParse,
+		Target:  TargetLocal,
+		Status:  StatusDone,
+		Message: message,
+	})
+
+	// Generate rathole configs
+	emit(events, Event{Step: StepGenerate, Target: TargetLocal, Status: StatusRunning})
+
+	plan.ServerTOML, err = generator.GenerateServerTOML(cfg, local)
+	if err != nil {
+		return nil, fail(events, StepGenerate, TargetLocal, "Couldn't generate server config", err)
+	}
+
+	plan.ClientTOMLs = make(map[string]string)
+	host := tunnelHost(cfg)
+	for _, c := range cfg.Clients {
+		plan.ClientTOMLs[c.Name], err = generator.GenerateClientTOML(cfg, c, host, clientServices(cfg, c, local))
+		if err != nil {
+			return nil, fail(events, StepGenerate, TargetLocal, fmt.Sprintf("Couldn't generate client config for %s", c.Label()), err)
+		}
+	}
+
+	emit(events, Event{Step: StepGenerate, Target: TargetLocal, Status: StatusDone})
+
+	return plan, nil
+}
+
+// resolveTokens replaces op:// and ${ENV} references in service tokens
+// with their values
+func resolveTokens(services []parser.Service) error {
+	var tokens []*string
+	for i := range services {
+		if services[i].Options.Token != "" {
+			tokens = append(tokens, &services[i].Options.Token)
+		}
+	}
+	if err := config.Resolve(tokens...); err != nil {
+		return err
+	}
+	for _, svc := range services {
+		if strings.ContainsAny(svc.Options.Token, "\"\\\n") {
+			return fmt.Errorf("token of %s can't hold quotes, backslashes or newlines", svc.Name)
+		}
+	}
+	return nil
+}
+
+// Services returns every service found in the local or remote Caddyfile,
+// sorted by name. Failing to reach the server is not an error; the remote
+// side is simply reported as empty.
+func Services(cfg *config.Config) ([]ServiceRow, error) {
+	localByName := make(map[string]parser.Service)
+	if cfg.Paths.Caddyfile != "" {
+		services, err := parser.ParseFile(cfg.Paths.Caddyfile)
+		if err == nil {
+			for _, svc := range services {
+				localByName[svc.Name] = svc
+			}
+		}
+	}
+	for _, svc := range configServices(cfg) {
+		localByName[svc.Name] = svc
+	}
+
+	remoteServices := make(map[string]parser.Service)
+	services, _ := fetchRemoteServices(cfg)
+	for _, svc := range services {
+		remoteServices[svc.Name] = svc
+	}
+
+	// Merge all service names
+	allNames := make(map[string]bool)
+	for name := range localByName {
+		allNames[name] = true
+	}
+	for name := range remoteServices {
+		allNames[name] = true
+	}
+
+	if len(allNames) == 0 {
+		return nil, fmt.Errorf("no services found")
+	}
+
+	var rows []ServiceRow
+	for name := range allNames {
+		localSvc, isLocal := localByName[name]
+		remoteSvc, isRemote := remoteServices[name]
+
+		// Use whichever exists for data
+		svc := remoteSvc
+		if isLocal {
+			svc = localSvc
+		}
+
+		rows = append(rows, ServiceRow{
+			Name:      svc.Name,
+			LocalAddr: svc.LocalAddr,
+			VPSPort:   svc.VPSPort,
+			Protocol:  svc.Protocol,
+			Domains:   svc.Domains,
+			Client:    clientLabel(cfg, svc.Client),
+			IsLocal:   isLocal,
+			IsRemote:  isRemote,
+		})
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Name < rows[j].Name
+	})
+
+	return rows, nil
+}
+
+// fetchRemoteServices downloads and parses the Caddyfile deployed on the server
+func fetchRemoteServices(cfg *config.Config) ([]parser.Service, error) {
+	if cfg.Server.Host == "" || cfg.Server.Caddyfile == "" {
+		return nil, nil
+	}
+
+	client, err := connectServer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Don't close - connection is pooled and reused
+
+	caddyfile, err := parser.Load(client.ExpandPath(cfg.Server.Caddyfile), remoteFS{client: client, downloaded: make(map[string]string)})
+	if err != nil {
+		return nil, err
+	}
+	services := caddyfile.Services()
+
+	// Raw services from config.yaml are only in the deployed server.toml
+	if cfg.Server.RatholeConfig != "" {
+		if content, err := client.DownloadContent(cfg.Server.RatholeConfig); err == nil {
+			names := make(map[string]bool)
+			for _, svc := range services {
+				names[svc.Name] = true
+			}
+			for _, svc := range deployedServices(content) {
+				if !names[svc.Name] {
+					services = append(services, svc)
+				}
+			}
+		}
+	}
+	return services, nil
+}
+
+// importsByPath returns the contents of the files a Caddyfile imports,
+// keyed by their path relative to it. Imports from outside the Caddyfile's
+// directory are refused, since they couldn't be deployed next to it.
+func importsByPath(c *parser.Caddyfile) (map[string]string, error) {
+	dir := filepath.Dir(c.Files[0].Name)
+	imports := make(map[string]string)
+	for _, f := range c.Imports() {
+		rel, err := filepath.Rel(dir, f.Name)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("%s is imported from outside %s", f.Name, dir)
+		}
+		imports[filepath.ToSlash(rel)] = f.Src
+	}
+	return imports, nil
+}
+
+// sortedPaths returns the keys of imports in order
+func sortedPaths(imports map[string]string) []string {
+	paths := make([]string, 0, len(imports))
+	for p := range imports {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// remoteFS reads a deployed Caddyfile's imports over SSH
+type remoteFS struct {
+	client     *ssh.Client
+	downloaded map[string]string // Files already fetched, by remote path
+}
+
+// ReadFile downloads a file once; the parser reads an import when checking
+// that it exists and again when expanding it
+func (r remoteFS) ReadFile(name string) ([]byte, error) {
+	if content, ok := r.downloaded[name]; ok {
+		return []byte(content), nil
+	}
+	content, err := r.client.DownloadContent(name)
+	if err != nil {
+		return nil, err
+	}
+	r.downloaded[name] = content
+	return []byte(content), nil
+}
+
+func (r remoteFS) Glob(pattern string) ([]string, error) {
+	return r.client.Glob(pattern)
+}
@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/parser"
+)
+
+// PortError lists the VPS ports that can't be deployed as they are
+type PortError struct {
+	Conflicts []string
+}
+
+func (e *PortError) Error() string {
+	return "VPS port conflicts:\n    " + strings.Join(e.Conflicts, "\n    ")
+}
+
+// checkPorts reports services sharing a VPS port, using rathole's bind
+// port, or, when listening is given, using a port another process on the
+// VPS already listens on. Ports of deployed services are rathole's own. A
+// TCP and a UDP service can share a port number.
+func checkPorts(cfg *config.Config, services, deployed []parser.Service, listening map[int]bool) error {
+	byPort := make(map[vpsPort][]string)
+	for _, svc := range services {
+		byPort[portOf(svc)] = append(byPort[portOf(svc)], svc.Name)
+	}
+	ownPorts := make(map[vpsPort]bool)
+	for _, svc := range deployed {
+		ownPorts[portOf(svc)] = true
+	}
+
+	ports := make([]vpsPort, 0, len(byPort))
+	for port := range byPort {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].port != ports[j].port {
+			return ports[i].port < ports[j].port
+		}
+		return !ports[i].udp && ports[j].udp
+	})
+
+	var conflicts []string
+	for _, port := range ports {
+		names := strings.Join(byPort[port], ", ")
+		switch {
+		case len(byPort[port]) > 1:
+			conflicts = append(conflicts, fmt.Sprintf("port %s is used by %s", port, names))
+		case port.udp:
+			// Rathole's bind port and ss -ltn are TCP only
+		case port.port == cfg.Rathole.BindPort:
+			conflicts = append(conflicts, fmt.Sprintf("port %s of %s is rathole's bind_port", port, names))
+		case listening[port.port] && !ownPorts[port]:
+			conflicts = append(conflicts, fmt.Sprintf("port %s of %s is already in use on the VPS", port, names))
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return &PortError{Conflicts: conflicts}
+	}
+	return nil
+}
+
+// vpsPort is a port on the VPS, TCP or UDP
+type vpsPort struct {
+	port int
+	udp  bool
+}
+
+func portOf(svc parser.Service) vpsPort {
+	return vpsPort{port: svc.VPSPort, udp: svc.Protocol == parser.ProtocolUDP}
+}
+
+func (p vpsPort) String() string {
+	if p.udp {
+		return fmt.Sprintf("%d/udp", p.port)
+	}
+	return fmt.Sprint(p.port)
+}
+
+// allocatePort returns the lowest port in the configured range that no
+// site proxies to, no raw service uses and that isn't otherwise taken. Raw
+// services include the ones in config.yaml.
+func allocatePort(cfg *config.Config, c *parser.Caddyfile, taken map[int]bool) (int, error) {
+	used := make(map[int]bool)
+	for port := range taken {
+		used[port] = true
+	}
+	used[cfg.Rathole.BindPort] = true
+	for _, b := range c.Sites() {
+		if _, port, ok := parser.LocalUpstream(b); ok {
+			used[port] = true
+		}
+	}
+	for _, svc := range append(c.Services(), configServices(cfg)...) {
+		used[svc.VPSPort] = true
+	}
+
+	start, end := cfg.Rathole.PortRangeStart, cfg.Rathole.PortRangeEnd
+	for port := start; port <= end; port++ {
+		if !used[port] {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free VPS port left in %d-%d, widen rathole.port_range_start/port_range_end", start, end)
+}
+
+// listeningPorts returns the ports in use on the VPS when check_ports is
+// on, or nil
+func listeningPorts(cfg *config.Config) (map[int]bool, error) {
+	if !cfg.Rathole.CheckPorts || cfg.Server.Host == "" {
+		return nil, nil
+	}
+	client, err := connectServer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Don't close - connection is pooled and reused
+	return client.ListeningPorts()
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/parser"
+)
+
+func TestCheckPorts(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Rathole.BindPort = 2333
+
+	services := []parser.Service{
+		{Name: "plex", VPSPort: 8001},
+		{Name: "jellyfin", VPSPort: 8001},
+		{Name: "ha", VPSPort: 2333},
+		{Name: "nas", VPSPort: 8003},
+		{Name: "git", VPSPort: 8004},
+		{Name: "dns", VPSPort: 53, Protocol: parser.ProtocolUDP},
+		{Name: "dns-tcp", VPSPort: 53},
+		{Name: "wg", VPSPort: 51820, Protocol: parser.ProtocolUDP},
+		{Name: "wg2", VPSPort: 51820, Protocol: parser.ProtocolUDP},
+	}
+	deployed := []parser.Service{{Name: "nas", VPSPort: 8003}}
+	listening := map[int]bool{22: true, 2333: true, 8003: true, 8004: true}
+
+	err := checkPorts(cfg, services, deployed, listening)
+	var portErr *PortError
+	if !errors.As(err, &portErr) {
+		t.Fatalf("Expected a PortError, got %v", err)
+	}
+	want := []string{
+		"port 2333 of ha is rathole's bind_port",
+		"port 8001 is used by plex, jellyfin",
+		"port 8004 of git is already in use on the VPS",
+		"port 51820/udp is used by wg, wg2",
+	}
+	if !reflect.DeepEqual(portErr.Conflicts, want) {
+		t.Errorf("Conflicts = %q, want %q", portErr.Conflicts, want)
+	}
+
+	if err := checkPorts(cfg, services[3:7], deployed, nil); err != nil {
+		t.Errorf("Expected no conflicts without the VPS check, got %v", err)
+	}
+}
+
+func TestAllocatePort(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Rathole.BindPort = 8002
+	cfg.Rathole.PortRangeStart = 8001
+	cfg.Rathole.PortRangeEnd = 8005
+
+	c, err := parser.Load("Caddyfile", parser.MapFS{"Caddyfile": "a.example.com {\n\treverse_proxy localhost:8001\n}\nb.example.com {\n\treverse_proxy localhost:8004\n}\n"})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if port, err := allocatePort(cfg, c, map[int]bool{8003: true}); err != nil || port != 8005 {
+		t.Errorf("allocatePort() = %d, %v, want 8005", port, err)
+	}
+	if _, err := allocatePort(cfg, c, map[int]bool{8003: true, 8005: true}); err == nil {
+		t.Error("Expected an error when the range is full")
+	}
+}
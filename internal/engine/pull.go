@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/parser"
+)
+
+// PullResult holds the Caddyfile downloaded from the server
+type PullResult struct {
+	Content  string
+	Services []parser.Service
+	Path     string   // Local path the Caddyfile was saved to
+	Imports  []string // Local paths the files it imports were saved to
+}
+
+// Pull downloads the Caddyfile and the files it imports from the server,
+// parses them and saves them next to the local Caddyfile path, overwriting
+// any existing files.
+func Pull(cfg *config.Config, events chan<- Event) (*PullResult, error) {
+	emit(events, Event{Step: StepConnect, Target: TargetServer, Status: StatusRunning})
+	client, err := connectServer(cfg)
+	if err != nil {
+		return nil, fail(events, StepConnect, TargetServer, "Couldn't connect to server", fmt.Errorf("connect to server: %w", err))
+	}
+	// Don't close - connection is pooled and reused
+	emit(events, Event{Step: StepConnect, Target: TargetServer, Status: StatusDone, Message: cfg.Server.Host})
+
+	emit(events, Event{Step: StepDownload, Target: TargetServer, Status: StatusRunning})
+	content, err := client.DownloadContent(cfg.Server.Caddyfile)
+	if err != nil {
+		return nil, fail(events, StepDownload, TargetServer, "Caddyfile not found on server", fmt.Errorf("download caddyfile: %w", err))
+	}
+	emit(events, Event{
+		Step:    StepDownload,
+		Target:  TargetServer,
+		Status:  StatusDone,
+		Message: fmt.Sprintf("%d bytes from %s", len(content), cfg.Server.Caddyfile),
+	})
+
+	// Imports are downloaded as the parser comes across them
+	emit(events, Event{Step: StepParse, Target: TargetServer, Status: StatusRunning})
+	remotePath := client.ExpandPath(cfg.Server.Caddyfile)
+	fsys := remoteFS{client: client, downloaded: map[string]string{remotePath: content}}
+	caddyfile, err := parser.Load(remotePath, fsys)
+	if err != nil {
+		return nil, fail(events, StepParse, TargetServer, "Couldn't parse Caddyfile", fmt.Errorf("parse caddyfile: %w", err))
+	}
+	imports, err := importsByPath(caddyfile)
+	if err != nil {
+		return nil, fail(events, StepParse, TargetServer, "Couldn't pull Caddyfile imports", err)
+	}
+	services := caddyfile.Services()
+	emit(events, Event{
+		Step:    StepParse,
+		Target:  TargetServer,
+		Status:  StatusDone,
+		Message: fmt.Sprintf("%d services", len(services)),
+	})
+
+	emit(events, Event{Step: StepSave, Target: TargetLocal, Status: StatusRunning})
+	localPath := config.ExpandPath(cfg.Paths.Caddyfile)
+
+	// Ensure parent directory exists
+	dir := filepath.Dir(localPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fail(events, StepSave, TargetLocal, "Couldn't create config directory", fmt.Errorf("create directory %s: %w", dir, err))
+	}
+
+	if err := os.WriteFile(localPath, []byte(content), 0644); err != nil {
+		return nil, fail(events, StepSave, TargetLocal, "Couldn't save Caddyfile", fmt.Errorf("write local file: %w", err))
+	}
+
+	var saved []string
+	for _, rel := range sortedPaths(imports) {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fail(events, StepSave, TargetLocal, "Couldn't create config directory", fmt.Errorf("create directory %s: %w", filepath.Dir(path), err))
+		}
+		if err := os.WriteFile(path, []byte(imports[rel]), 0644); err != nil {
+			return nil, fail(events, StepSave, TargetLocal, fmt.Sprintf("Couldn't save %s", rel), fmt.Errorf("write local file: %w", err))
+		}
+		saved = append(saved, path)
+	}
+
+	message := localPath
+	if len(saved) > 0 {
+		message = fmt.Sprintf("%s and %d imported files", localPath, len(saved))
+	}
+	emit(events, Event{Step: StepSave, Target: TargetLocal, Status: StatusDone, Message: message})
+
+	return &PullResult{
+		Content:  content,
+		Services: services,
+		Path:     localPath,
+		Imports:  saved,
+	}, nil
+}
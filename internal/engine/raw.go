@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/parser"
+)
+
+// configServices returns the services declared under services: in
+// config.yaml, which have no Caddy site
+func configServices(cfg *config.Config) []parser.Service {
+	var services []parser.Service
+	for _, svc := range cfg.Services {
+		services = append(services, parser.Service{
+			Name:      svc.Name,
+			LocalAddr: svc.LocalAddr,
+			VPSPort:   svc.VPSPort,
+			Protocol:  svc.Protocol,
+			Raw:       true,
+			BindAddr:  svc.BindAddr,
+			Client:    svc.Client,
+		})
+	}
+	return services
+}
+
+// LocalServices returns the services of the local Caddyfile and config.yaml
+func LocalServices(cfg *config.Config) ([]parser.Service, error) {
+	c, err := parser.Load(cfg.Paths.Caddyfile, parser.OSFS)
+	if err != nil {
+		return nil, fmt.Errorf("parse caddyfile: %w", err)
+	}
+	return localServices(cfg, c)
+}
+
+// localServices returns the services of the local Caddyfile followed by
+// the ones in config.yaml. A name can only be used once across both.
+func localServices(cfg *config.Config, c *parser.Caddyfile) ([]parser.Service, error) {
+	services := c.Services()
+	names := make(map[string]parser.Pos)
+	for _, svc := range services {
+		names[svc.Name] = svc.Pos
+	}
+	for _, svc := range configServices(cfg) {
+		if pos, ok := names[svc.Name]; ok {
+			return nil, fmt.Errorf("service %s is in both config.yaml and the Caddyfile (%s)", svc.Name, pos)
+		}
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+var (
+	// Pattern: [server.services.name]
+	tomlServiceRe = regexp.MustCompile(`^\[server\.services\.([\w-]+)\]$`)
+
+	// Pattern: key = "value"
+	tomlValueRe = regexp.MustCompile(`^(\w+)\s*=\s*"([^"]*)"$`)
+)
+
+// deployedServices reads the services out of a server.toml written by rcm.
+// Only the fields rcm writes are understood.
+func deployedServices(serverTOML string) []parser.Service {
+	var services []parser.Service
+	var current *parser.Service
+	for _, line := range strings.Split(serverTOML, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			current = nil
+			if m := tomlServiceRe.FindStringSubmatch(line); m != nil {
+				services = append(services, parser.Service{Name: m[1], Protocol: parser.ProtocolTCP, Raw: true})
+				current = &services[len(services)-1]
+			}
+			continue
+		}
+		m := tomlValueRe.FindStringSubmatch(line)
+		if current == nil || m == nil {
+			continue
+		}
+		switch m[1] {
+		case "type":
+			current.Protocol = m[2]
+		case "bind_addr":
+			host, port, err := parser.ParseBindTarget(m[2])
+			if err == nil {
+				if host == "0.0.0.0" {
+					host = ""
+				}
+				current.BindAddr, current.VPSPort = host, port
+			}
+		}
+	}
+	return services
+}
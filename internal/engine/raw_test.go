@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/generator"
+	"github.com/AhmedAburady/rcm-go/internal/parser"
+)
+
+func TestLocalServicesMergesConfig(t *testing.T) {
+	cfg := &config.Config{Services: []config.ServiceConfig{
+		{Name: "ssh", LocalAddr: "192.168.1.5:22", VPSPort: 2222, Protocol: "tcp", BindAddr: "10.8.0.1"},
+		{Name: "wireguard", LocalAddr: "192.168.1.5:51820", VPSPort: 51820, Protocol: "udp"},
+	}}
+	c, err := parser.Load("Caddyfile", parser.MapFS{"Caddyfile": "# plex: 192.168.1.100:32400\nplex.example.com {\n\treverse_proxy localhost:8001\n}\n"})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	services, err := localServices(cfg, c)
+	if err != nil {
+		t.Fatalf("localServices failed: %v", err)
+	}
+	if len(services) != 3 || services[1].Name != "ssh" || !services[1].Raw || services[1].VPSAddr() != "10.8.0.1:2222" {
+		t.Fatalf("Unexpected services %+v", services)
+	}
+
+	// The generated server.toml reads back as the same services
+	serverTOML, err := generator.GenerateServerTOML(cfg, services)
+	if err != nil {
+		t.Fatalf("GenerateServerTOML failed: %v", err)
+	}
+	var got []string
+	for _, svc := range deployedServices(serverTOML) {
+		got = append(got, svc.Name+" "+svc.Protocol+" "+svc.VPSAddr())
+	}
+	want := []string{"plex tcp 0.0.0.0:8001", "ssh tcp 10.8.0.1:2222", "wireguard udp 0.0.0.0:51820"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("deployedServices() = %q, want %q", got, want)
+	}
+
+	cfg.Services[0].Name = "plex"
+	if _, err := localServices(cfg, c); err == nil {
+		t.Error("Expected an error for a name in both config.yaml and the Caddyfile")
+	}
+}
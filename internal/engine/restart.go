@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/ssh"
+)
+
+// RestartOptions selects which services to restart
+type RestartOptions struct {
+	Server  bool     // rathole-server on the VPS
+	Client  bool     // rathole-client on the home machines
+	Clients []string // Names of the clients to restart rathole-client on, all of them when nil
+	Caddy   bool     // Caddy (docker compose) on the VPS, if configured
+
+	// ReloadCaddy reloads the Caddyfile instead of restarting the container,
+	// falling back to a restart if the reload fails
+	ReloadCaddy bool
+}
+
+// Restart restarts the selected services and verifies they came back up.
+// All machines are handled concurrently.
+func Restart(cfg *config.Config, opts RestartOptions, events chan<- Event) error {
+	if cfg.Server.CaddyComposeDir == "" {
+		opts.Caddy = false
+	}
+
+	// Mark everything as running up front so UIs can show all tasks at once
+	if opts.Server {
+		emit(events, Event{Step: StepRestart, Target: TargetServer, Status: StatusRunning})
+	}
+	if opts.Caddy {
+		emit(events, Event{Step: StepRestart, Target: TargetCaddy, Status: StatusRunning})
+	}
+	if opts.Client {
+		emit(events, Event{Step: StepRestart, Target: TargetClient, Status: StatusRunning})
+	}
+
+	var tasks []func() error
+	if opts.Server || opts.Caddy {
+		tasks = append(tasks, func() error { return restartServer(cfg, opts, events) })
+	}
+	if opts.Client {
+		clients := selectClients(cfg, opts.Clients)
+		tasks = append(tasks, func() error {
+			return eachClient(clients, StepRestart, restartMessage(cfg, clients), events, func(c config.ClientConfig) (bool, error) {
+				return true, restartClient(cfg, c, events)
+			})
+		})
+	}
+
+	return runParallel(tasks...)
+}
+
+func restartServer(cfg *config.Config, opts RestartOptions, events chan<- Event) error {
+	client, err := connectServer(cfg)
+	if err != nil {
+		friendly := fmt.Sprintf("Couldn't connect to server (%s)", cfg.Server.Host)
+		if opts.Caddy && !opts.Server {
+			return fail(events, StepRestart, TargetCaddy, friendly, err)
+		}
+		return fail(events, StepRestart, TargetServer, friendly, err)
+	}
+	// Don't close - connection is pooled and reused
+
+	if opts.Server {
+		if err := client.RestartService("rathole-server"); err != nil {
+			return fail(events, StepRestart, TargetServer, "Couldn't restart rathole-server", err)
+		}
+		// Verify service is running
+		running, status, _ := client.GetServiceStatus("rathole-server")
+		if !running {
+			return fail(events, StepRestart, TargetServer,
+				fmt.Sprintf("rathole-server failed to start (%s)", status),
+				fmt.Errorf("service not running: %s", status))
+		}
+		emit(events, Event{Step: StepRestart, Target: TargetServer, Status: StatusDone})
+	}
+
+	if opts.Caddy {
+		return applyCaddy(cfg, client, opts.ReloadCaddy, events)
+	}
+
+	return nil
+}
+
+// applyCaddy reloads or restarts Caddy and verifies the container is up
+func applyCaddy(cfg *config.Config, client *ssh.Client, reload bool, events chan<- Event) error {
+	dir := cfg.Server.CaddyComposeDir
+
+	if reload {
+		err := client.ReloadCaddy(dir, cfg.Server.CaddyService, cfg.Server.CaddyConfig)
+		if err == nil {
+			emit(events, Event{Step: StepRestart, Target: TargetCaddy, Status: StatusDone, Message: "reloaded"})
+			return nil
+		}
+		// The container may be stopped or too old to reload; restart it instead
+	}
+
+	if err := client.RestartDockerCompose(dir); err != nil {
+		return fail(events, StepRestart, TargetCaddy, "Couldn't restart Caddy", err)
+	}
+	// Verify container is running
+	running, status, _ := client.GetDockerComposeStatus(dir)
+	if !running {
+		return fail(events, StepRestart, TargetCaddy,
+			fmt.Sprintf("Caddy failed to start (%s)", status),
+			fmt.Errorf("container not running: %s", status))
+	}
+
+	message := ""
+	if reload {
+		message = "reload failed, restarted"
+	}
+	emit(events, Event{Step: StepRestart, Target: TargetCaddy, Status: StatusDone, Message: message})
+	return nil
+}
+
+// restartMessage names the clients restarted when there are several
+func restartMessage(cfg *config.Config, clients []config.ClientConfig) string {
+	if len(cfg.Clients) < 2 {
+		return ""
+	}
+	return hostsOf(clients)
+}
+
+func restartClient(cfg *config.Config, c config.ClientConfig, events chan<- Event) error {
+	client, err := connectClient(cfg, c)
+	if err != nil {
+		return fail(events, StepRestart, TargetClient, fmt.Sprintf("Couldn't connect to client (%s)", c.Host), err)
+	}
+	// Don't close - connection is pooled and reused
+
+	if err := client.RestartService("rathole-client"); err != nil {
+		return fail(events, StepRestart, TargetClient, fmt.Sprintf("Couldn't restart rathole-client on %s", c.Host), err)
+	}
+	// Verify service is running
+	running, status, _ := client.GetServiceStatus("rathole-client")
+	if !running {
+		return fail(events, StepRestart, TargetClient,
+			fmt.Sprintf("rathole-client failed to start on %s (%s)", c.Host, status),
+			fmt.Errorf("service not running: %s", status))
+	}
+	return nil
+}
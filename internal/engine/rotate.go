@@ -0,0 +1,222 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/generator"
+	"github.com/AhmedAburady/rcm-go/internal/parser"
+)
+
+// RotateOptions selects the credentials to replace
+type RotateOptions struct {
+	Token   bool          // rathole.token
+	Keys    bool          // The server's noise keypair
+	Timeout time.Duration // How long to wait for the tunnel, 30 seconds when zero
+}
+
+// RotateResult holds the new credentials, which the caller must store
+type RotateResult struct {
+	Settings   []config.Setting // New values of the rathole settings
+	SnapshotID string
+}
+
+// tunnelPoll is how often the VPS is asked whether the tunnel is back
+const tunnelPoll = 2 * time.Second
+
+// Rotate deploys a new token and/or noise keypair. The deployed configs
+// must match the local ones, so nothing but the credentials changes.
+// rathole-server is switched over first, then rathole-client, and the
+// tunnel must come back up within the timeout. If any of that fails, the
+// previous configs are deployed again. Nothing is stored locally: the new
+// values are returned for the caller to save.
+func Rotate(cfg *config.Config, opts RotateOptions, events chan<- Event) (*RotateResult, error) {
+	if !opts.Token && !opts.Keys {
+		return nil, errors.New("nothing to rotate")
+	}
+	if opts.Keys && cfg.Rathole.Transport != config.TransportNoise {
+		return nil, fmt.Errorf("rathole.transport is %s, there are no noise keys to rotate", cfg.Rathole.Transport)
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+
+	current, err := Plan(cfg, events)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := detectChanges(cfg, current, events)
+	if err != nil {
+		return nil, err
+	}
+	if changes.Any() {
+		return nil, fail(events, StepCompare, TargetLocal, "Undeployed changes, run rcm sync before rotating", fmt.Errorf("changed since the last sync: %s", changedFiles(cfg, changes)))
+	}
+	// Without a working tunnel now, there would be no telling whether the
+	// new credentials work
+	if err := waitForTunnel(cfg, current.Services, 0, events); err != nil {
+		return nil, err
+	}
+
+	rotated, result, err := rotatedConfig(cfg, opts)
+	if err != nil {
+		return nil, fail(events, StepGenerate, TargetLocal, "Couldn't generate new credentials", err)
+	}
+	plan, err := Plan(rotated, nil)
+	if err != nil {
+		return nil, fail(events, StepGenerate, TargetLocal, "Couldn't generate configs with the new credentials", err)
+	}
+
+	// The server goes first: the client keeps retrying until the server
+	// accepts its new credentials
+	snap := newSnapshot(rotated, plan)
+	err = uploadServer(rotated, plan, snap, Changes{ServerTOML: true}, events)
+	if err == nil {
+		err = Restart(rotated, RestartOptions{Server: true}, events)
+	}
+	if err == nil {
+		err = uploadClients(rotated, rotated.Clients, plan, snap, Changes{ClientTOMLs: clientNames(rotated.Clients)}, events)
+	}
+	if err == nil {
+		err = Restart(rotated, RestartOptions{Client: true}, events)
+	}
+	if err == nil {
+		err = waitForTunnel(rotated, plan.Services, opts.Timeout, events)
+	}
+	if err != nil {
+		return nil, rollBackRotation(cfg, current, opts.Timeout, err, events)
+	}
+
+	emit(events, Event{Step: StepRecord, Target: TargetLocal, Status: StatusRunning})
+	if err := saveLocalSnapshot(snap); err != nil {
+		// The new credentials are live, so this doesn't undo the rotation
+		emit(events, Event{Step: StepRecord, Target: TargetLocal, Status: StatusFailed, Message: "Couldn't record deployment snapshot", Err: err})
+	} else {
+		emit(events, Event{Step: StepRecord, Target: TargetLocal, Status: StatusDone, Message: snap.ID})
+		result.SnapshotID = snap.ID
+	}
+	return result, nil
+}
+
+// rotatedConfig returns a copy of cfg with new credentials, and the
+// settings to store them under
+func rotatedConfig(cfg *config.Config, opts RotateOptions) (*config.Config, *RotateResult, error) {
+	rotated := *cfg
+	result := &RotateResult{}
+	if opts.Token {
+		token, err := generator.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		rotated.Rathole.Token = token
+		result.Settings = append(result.Settings, config.Setting{Key: "token", Value: token})
+	}
+	if opts.Keys {
+		keys, err := generator.NoiseKeypair()
+		if err != nil {
+			return nil, nil, err
+		}
+		rotated.Rathole.ServerPrivateKey = keys.Private
+		rotated.Rathole.ServerPublicKey = keys.Public
+		result.Settings = append(result.Settings,
+			config.Setting{Key: "server_private_key", Value: keys.Private},
+			config.Setting{Key: "server_public_key", Value: keys.Public})
+	}
+	return &rotated, result, nil
+}
+
+// waitForTunnel waits up to timeout until the VPS listens on the port of
+// every service. rathole-server only opens a service's port once the
+// client has connected with valid credentials, so that shows the tunnel
+// is up.
+func waitForTunnel(cfg *config.Config, services []parser.Service, timeout time.Duration, events chan<- Event) error {
+	emit(events, Event{Step: StepTunnel, Target: TargetServer, Status: StatusRunning})
+	if len(services) == 0 {
+		emit(events, Event{Step: StepTunnel, Target: TargetServer, Status: StatusDone, Message: "no services to check"})
+		return nil
+	}
+
+	client, err := connectServer(cfg)
+	if err != nil {
+		return fail(events, StepTunnel, TargetServer, fmt.Sprintf("Couldn't connect to server (%s)", cfg.Server.Host), err)
+	}
+	// Don't close - connection is pooled and reused
+
+	deadline := time.Now().Add(timeout)
+	for {
+		tcp, err := client.ListeningPorts()
+		if err != nil {
+			return fail(events, StepTunnel, TargetServer, "Couldn't check the tunnel", err)
+		}
+		udp, err := client.ListeningUDPPorts()
+		if err != nil {
+			return fail(events, StepTunnel, TargetServer, "Couldn't check the tunnel", err)
+		}
+		down := closedPorts(tcp, udp, services)
+		if len(down) == 0 {
+			emit(events, Event{Step: StepTunnel, Target: TargetServer, Status: StatusDone, Message: fmt.Sprintf("%d services up", len(services))})
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fail(events, StepTunnel, TargetServer, "Tunnel isn't up",
+				fmt.Errorf("nothing listens on %s", strings.Join(down, ", ")))
+		}
+		time.Sleep(tunnelPoll)
+	}
+}
+
+// closedPorts returns the services whose VPS port nothing listens on
+func closedPorts(tcp, udp map[int]bool, services []parser.Service) []string {
+	var down []string
+	for _, svc := range services {
+		listening := tcp
+		if svc.Protocol == parser.ProtocolUDP {
+			listening = udp
+		}
+		if !listening[svc.VPSPort] {
+			down = append(down, fmt.Sprintf("%s (%s)", portOf(svc), svc.Name))
+		}
+	}
+	return down
+}
+
+// rollBackRotation deploys the configs from before the rotation again and
+// returns the error that caused it, noting whether the rollback worked
+func rollBackRotation(cfg *config.Config, previous *SyncPlan, timeout time.Duration, cause error, events chan<- Event) error {
+	emit(events, Event{Step: StepRollback, Target: TargetLocal, Status: StatusRunning})
+	_, err := Sync(cfg, previous, SyncOptions{}, events)
+	if err == nil {
+		err = waitForTunnel(cfg, previous.Services, timeout, events)
+	}
+	if err != nil {
+		return fail(events, StepRollback, TargetLocal, "Rotation failed and so did the rollback, check both machines",
+			fmt.Errorf("%w; rollback: %v", cause, err))
+	}
+	emit(events, Event{Step: StepRollback, Target: TargetLocal, Status: StatusDone, Message: "previous credentials restored"})
+
+	var e *Error
+	if errors.As(cause, &e) {
+		return &Error{Step: e.Step, Target: e.Target, Friendly: e.Friendly + ", the previous credentials were restored", Err: e.Err}
+	}
+	return cause
+}
+
+// changedFiles lists the files in changes
+func changedFiles(cfg *config.Config, c Changes) string {
+	var files []string
+	if c.ServerTOML {
+		files = append(files, "server.toml")
+	}
+	if c.Caddyfile {
+		files = append(files, "Caddyfile")
+	}
+	files = append(files, c.Imports...)
+	for _, name := range c.ClientTOMLs {
+		client, _ := cfg.ClientNamed(name)
+		files = append(files, clientFile(cfg, client))
+	}
+	return strings.Join(files, ", ")
+}
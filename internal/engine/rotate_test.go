@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/parser"
+)
+
+func TestRotatedConfig(t *testing.T) {
+	cfg := &config.Config{Rathole: config.RatholeConfig{Token: "old", ServerPrivateKey: "old-private", ServerPublicKey: "old-public"}}
+
+	rotated, result, err := rotatedConfig(cfg, RotateOptions{Keys: true})
+	if err != nil {
+		t.Fatalf("rotatedConfig failed: %v", err)
+	}
+	if cfg.Rathole.ServerPrivateKey != "old-private" {
+		t.Errorf("rotatedConfig changed the original config")
+	}
+	if rotated.Rathole.Token != "old" {
+		t.Errorf("token rotated without --token: %q", rotated.Rathole.Token)
+	}
+	if rotated.Rathole.ServerPrivateKey == "old-private" || rotated.Rathole.ServerPublicKey == "old-public" {
+		t.Errorf("keypair not rotated")
+	}
+	want := []config.Setting{
+		{Key: "server_private_key", Value: rotated.Rathole.ServerPrivateKey},
+		{Key: "server_public_key", Value: rotated.Rathole.ServerPublicKey},
+	}
+	if !reflect.DeepEqual(result.Settings, want) {
+		t.Errorf("Settings = %v, want %v", result.Settings, want)
+	}
+}
+
+func TestClosedPorts(t *testing.T) {
+	services := []parser.Service{
+		{Name: "web", VPSPort: 8001, Protocol: parser.ProtocolTCP},
+		{Name: "api", VPSPort: 8002, Protocol: parser.ProtocolTCP},
+		{Name: "wireguard", VPSPort: 51820, Protocol: parser.ProtocolUDP},
+		{Name: "dns", VPSPort: 8001, Protocol: parser.ProtocolUDP},
+	}
+	tcp := map[int]bool{8001: true, 51820: true}
+	udp := map[int]bool{51820: true}
+
+	got := closedPorts(tcp, udp, services)
+	want := []string{"8002 (api)", "8001/udp (dns)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("closedPorts() = %v, want %v", got, want)
+	}
+}
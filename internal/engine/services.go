@@ -0,0 +1,242 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/generator"
+	"github.com/AhmedAburady/rcm-go/internal/parser"
+)
+
+// NewService describes a service to add to the local Caddyfile
+type NewService struct {
+	Name         string
+	LocalAddr    string   // Address of the service on the home network, host:port
+	Domains      []string // Site addresses Caddy serves it on
+	HTTPSBackend bool     // The service speaks HTTPS, usually with a self-signed certificate
+	Template     string   // Site template, "default" when empty
+	Client       string   // Name of the client it runs on, the first one when empty
+}
+
+// AddService appends an annotated site block for svc to the local
+// Caddyfile, proxying to the lowest free VPS port in the configured range.
+// With check_ports on, ports in use on the VPS are skipped too, if it can
+// be reached. Nothing is deployed.
+func AddService(cfg *config.Config, svc NewService) (*parser.Service, error) {
+	if err := checkNewService(svc); err != nil {
+		return nil, err
+	}
+	if _, ok := cfg.ClientNamed(svc.Client); !ok {
+		return nil, fmt.Errorf("no client named %s under clients: in config.yaml", svc.Client)
+	}
+
+	c, err := parser.Load(cfg.Paths.Caddyfile, parser.OSFS)
+	if err != nil {
+		return nil, fmt.Errorf("parse caddyfile: %w", err)
+	}
+	for _, existing := range c.Services() {
+		if existing.Name == svc.Name {
+			return nil, fmt.Errorf("service %s already exists (%s)", svc.Name, existing.Pos)
+		}
+	}
+	for _, existing := range cfg.Services {
+		if existing.Name == svc.Name {
+			return nil, fmt.Errorf("service %s already exists in config.yaml", svc.Name)
+		}
+	}
+	for _, b := range c.Sites() {
+		for _, addr := range b.Addresses() {
+			for _, domain := range svc.Domains {
+				if addr == domain {
+					return nil, fmt.Errorf("%s is already served by the site at %s", domain, b.Pos)
+				}
+			}
+		}
+	}
+
+	listening, _ := listeningPorts(cfg)
+	port, err := allocatePort(cfg, c, listening)
+	if err != nil {
+		return nil, err
+	}
+
+	main := c.Files[0]
+	data := generator.SiteData{
+		Name:         svc.Name,
+		LocalAddr:    svc.LocalAddr,
+		Domains:      svc.Domains,
+		Port:         port,
+		Upstream:     fmt.Sprintf("127.0.0.1:%d", port),
+		HTTPSBackend: svc.HTTPSBackend,
+		Indent:       main.Indent(),
+	}
+	if svc.HTTPSBackend {
+		data.Upstream = "https://" + data.Upstream
+	}
+	template := svc.Template
+	if template == "" {
+		template = "default"
+	}
+	site, err := generator.GenerateSite(template, templatesDir(), data)
+	if err != nil {
+		return nil, err
+	}
+
+	e := parser.NewEditor(main)
+	name := svc.Name
+	if svc.Client != "" {
+		name += "@" + svc.Client
+	}
+	e.AppendSite(fmt.Sprintf("# %s: %s\n%s", name, svc.LocalAddr, site))
+	src, err := e.Source()
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %w", template, err)
+	}
+
+	// Parse the result the way sync will, so a template that doesn't proxy
+	// to the tunnel is caught before the file is written
+	edited, err := parser.Load(main.Name, overlayFS{name: main.Name, content: src})
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %w", template, err)
+	}
+	for _, added := range edited.Services() {
+		if added.Name != svc.Name {
+			continue
+		}
+		if added.VPSPort != port {
+			return nil, fmt.Errorf("template %s: service proxies to port %d instead of %d", template, added.VPSPort, port)
+		}
+		if err := writeCaddyfile(main.Name, src); err != nil {
+			return nil, err
+		}
+		return &added, nil
+	}
+	return nil, fmt.Errorf("template %s: the site doesn't reverse_proxy to 127.0.0.1:{{ .Port }}", template)
+}
+
+// checkNewService validates the name and addresses of a new service
+func checkNewService(svc NewService) error {
+	if name, _, ok := parser.ParseServiceComment("# " + svc.Name + ": x"); !ok || name != svc.Name {
+		return fmt.Errorf("invalid service name %q: use letters, digits, _ and -", svc.Name)
+	}
+
+	_, port, err := net.SplitHostPort(svc.LocalAddr)
+	if err != nil {
+		return fmt.Errorf("invalid local address %q: want host:port", svc.LocalAddr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid local address %q: bad port", svc.LocalAddr)
+	}
+
+	if len(svc.Domains) == 0 {
+		return errors.New("at least one domain is required")
+	}
+	return nil
+}
+
+// RemoveService deletes the site blocks of a service, with their service
+// and option comments, or the comments of a raw service, from the local
+// Caddyfile and the files it imports. It returns the files it changed.
+// Nothing is deployed.
+func RemoveService(cfg *config.Config, name string) ([]string, error) {
+	c, err := parser.Load(cfg.Paths.Caddyfile, parser.OSFS)
+	if err != nil {
+		return nil, fmt.Errorf("parse caddyfile: %w", err)
+	}
+
+	edited := make(map[string]string)
+	var files []string
+	for _, f := range c.Files {
+		e := parser.NewEditor(f)
+		found := false
+		for _, b := range f.Sites() {
+			if b.ServiceName() == name {
+				e.RemoveSite(b)
+				found = true
+			}
+		}
+		for _, comment := range f.Comments {
+			if raw, ok := parser.ParseRawServiceComment(comment.Text); ok && raw.Name == name && !f.Nested {
+				for _, opts := range c.OptionComments(comment) {
+					e.RemoveComment(opts)
+				}
+				e.RemoveComment(comment)
+				found = true
+			}
+		}
+		if !found {
+			continue
+		}
+		src, err := e.Source()
+		if err != nil {
+			return nil, err
+		}
+		edited[f.Name] = src
+		files = append(files, f.Name)
+	}
+
+	if len(files) == 0 {
+		for _, svc := range c.Services() {
+			if svc.Name == name {
+				return nil, fmt.Errorf("service %s comes from a snippet (%s), remove it by hand", name, svc.Pos)
+			}
+		}
+		for _, svc := range cfg.Services {
+			if svc.Name == name {
+				return nil, fmt.Errorf("service %s is declared under services: in config.yaml, remove it there", name)
+			}
+		}
+		return nil, fmt.Errorf("no service named %s in %s", name, cfg.Paths.Caddyfile)
+	}
+
+	// Every file is edited before any is written, so a failure leaves all
+	// of them as they were
+	for _, path := range files {
+		if err := writeCaddyfile(path, edited[path]); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// templatesDir holds user site templates, next to the config file
+func templatesDir() string {
+	if config.ConfigPath() == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(config.ConfigPath()), "templates")
+}
+
+// writeCaddyfile replaces a Caddyfile, keeping its mode
+func writeCaddyfile(path, content string) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.WriteFile(path, []byte(content), mode); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// overlayFS reads one file from memory and the rest from disk
+type overlayFS struct {
+	name    string
+	content string
+}
+
+func (o overlayFS) ReadFile(name string) ([]byte, error) {
+	if name == o.name {
+		return []byte(o.content), nil
+	}
+	return parser.OSFS.ReadFile(name)
+}
+
+func (o overlayFS) Glob(pattern string) ([]string, error) {
+	return parser.OSFS.Glob(pattern)
+}
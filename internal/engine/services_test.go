@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+)
+
+func TestAddAndRemoveService(t *testing.T) {
+	dir := t.TempDir()
+	caddyfile := filepath.Join(dir, "Caddyfile")
+	original := `# plex: 192.168.1.100:32400
+plex.example.com {
+  reverse_proxy localhost:8001
+}
+
+import sites/*.caddy
+`
+	os.WriteFile(caddyfile, []byte(original), 0600)
+	os.Mkdir(filepath.Join(dir, "sites"), 0755)
+	os.WriteFile(filepath.Join(dir, "sites", "ha.caddy"), []byte("# ha: 192.168.1.100:8123\nha.example.com {\n  reverse_proxy 127.0.0.1:8004\n}\n"), 0644)
+
+	cfg := &config.Config{}
+	cfg.Paths.Caddyfile = caddyfile
+	cfg.Rathole.PortRangeStart = 8001
+	cfg.Rathole.PortRangeEnd = 8999
+
+	svc, err := AddService(cfg, NewService{Name: "nas", LocalAddr: "192.168.1.20:5001", Domains: []string{"nas.example.com"}, HTTPSBackend: true})
+	if err != nil {
+		t.Fatalf("AddService failed: %v", err)
+	}
+	if svc.VPSPort != 8002 {
+		t.Errorf("Expected the lowest free port in the range, got %d", svc.VPSPort)
+	}
+
+	got, _ := os.ReadFile(caddyfile)
+	want := original + `
+# nas: 192.168.1.20:5001
+nas.example.com {
+  reverse_proxy https://127.0.0.1:8002 {
+    transport http {
+      tls_insecure_skip_verify
+    }
+  }
+}
+`
+	if string(got) != want {
+		t.Errorf("Unexpected Caddyfile:\n%s", got)
+	}
+	if info, _ := os.Stat(caddyfile); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the file mode to be kept, got %o", info.Mode().Perm())
+	}
+
+	for _, bad := range []NewService{
+		{Name: "plex", LocalAddr: "192.168.1.1:80", Domains: []string{"other.example.com"}},
+		{Name: "new", LocalAddr: "192.168.1.1:80", Domains: []string{"ha.example.com"}},
+		{Name: "bad name", LocalAddr: "192.168.1.1:80", Domains: []string{"x.example.com"}},
+		{Name: "new", LocalAddr: "192.168.1.1", Domains: []string{"x.example.com"}},
+		{Name: "new", LocalAddr: "192.168.1.1:80"},
+		{Name: "new", LocalAddr: "192.168.1.1:80", Domains: []string{"x.example.com"}, Client: "pi"},
+	} {
+		if _, err := AddService(cfg, bad); err == nil {
+			t.Errorf("Expected an error adding %+v", bad)
+		}
+	}
+
+	// Removing works in imported files too
+	files, err := RemoveService(cfg, "ha")
+	if err != nil {
+		t.Fatalf("RemoveService failed: %v", err)
+	}
+	if len(files) != 1 || !strings.HasSuffix(files[0], "ha.caddy") {
+		t.Errorf("Expected only ha.caddy to change, got %v", files)
+	}
+
+	if _, err := RemoveService(cfg, "nas"); err != nil {
+		t.Fatalf("RemoveService failed: %v", err)
+	}
+	if got, _ := os.ReadFile(caddyfile); string(got) != original {
+		t.Errorf("Expected the original Caddyfile back, got:\n%s", got)
+	}
+
+	if _, err := RemoveService(cfg, "nas"); err == nil {
+		t.Error("Expected an error removing a missing service")
+	}
+
+	// A raw service is just its comment
+	os.WriteFile(caddyfile, []byte("# wireguard: 192.168.1.5:51820 udp\n"+original), 0600)
+	if _, err := RemoveService(cfg, "wireguard"); err != nil {
+		t.Fatalf("RemoveService failed: %v", err)
+	}
+	if got, _ := os.ReadFile(caddyfile); string(got) != original {
+		t.Errorf("Expected the raw service comment to go, got:\n%s", got)
+	}
+}
@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/parser"
+	"github.com/AhmedAburady/rcm-go/internal/ssh"
+)
+
+// ServiceHealth holds health information for a service
+type ServiceHealth struct {
+	Name    string
+	Running bool
+	Status  string
+}
+
+// MachineStatus holds status for a machine
+type MachineStatus struct {
+	Name     string // Client name, empty for the server and a lone client
+	Host     string
+	Online   bool
+	Err      error // Connection error when offline
+	Services []ServiceHealth
+}
+
+// StatusReport holds the status of the server and every client
+type StatusReport struct {
+	Server  MachineStatus
+	Clients []MachineStatus // In the order of config.yaml
+}
+
+// Status checks rathole and Caddy on all machines concurrently
+func Status(cfg *config.Config, events chan<- Event) *StatusReport {
+	report := &StatusReport{Clients: make([]MachineStatus, len(cfg.Clients))}
+
+	_ = runParallel(
+		func() error {
+			emit(events, Event{Step: StepCheck, Target: TargetServer, Status: StatusRunning})
+			report.Server = checkMachine(
+				serverEndpoint(cfg),
+				[]string{"rathole-server"},
+				cfg.Server.CaddyComposeDir,
+			)
+			if report.Server.Online {
+				report.Server.Services = append(report.Server.Services, checkRawServices(cfg)...)
+			}
+			emitCheck(events, TargetServer, report.Server)
+			return nil
+		},
+		func() error {
+			emit(events, Event{Step: StepCheck, Target: TargetClient, Status: StatusRunning})
+			tasks := make([]func() error, len(cfg.Clients))
+			for i, c := range cfg.Clients {
+				tasks[i] = func() error {
+					report.Clients[i] = checkMachine(clientEndpoint(cfg, c), []string{"rathole-client"}, "")
+					report.Clients[i].Name = c.Name
+					return nil
+				}
+			}
+			_ = runParallel(tasks...)
+			emitCheck(events, TargetClient, report.Clients...)
+			return nil
+		},
+	)
+
+	return report
+}
+
+// emitCheck reports the machines of target as one event, failed if any of
+// them is offline
+func emitCheck(events chan<- Event, target Target, machines ...MachineStatus) {
+	var hosts []string
+	var offline error
+	for _, m := range machines {
+		hosts = append(hosts, m.Host)
+		if !m.Online && offline == nil {
+			offline = m.Err
+			if len(machines) > 1 {
+				offline = fmt.Errorf("%s: %w", m.Host, m.Err)
+			}
+		}
+	}
+	message := strings.Join(hosts, ", ")
+	if offline != nil {
+		emit(events, Event{Step: StepCheck, Target: target, Status: StatusFailed, Message: message, Err: offline})
+		return
+	}
+	emit(events, Event{Step: StepCheck, Target: target, Status: StatusDone, Message: message})
+}
+
+// checkRawServices reports whether something listens on the VPS port of
+// each raw TCP or UDP service, which rathole-server does once it has
+// loaded the service
+func checkRawServices(cfg *config.Config) []ServiceHealth {
+	local, err := LocalServices(cfg)
+	if err != nil {
+		local = configServices(cfg)
+	}
+	var raw []parser.Service
+	for _, svc := range local {
+		if svc.Raw {
+			raw = append(raw, svc)
+		}
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+
+	client, err := connectServer(cfg)
+	if err != nil {
+		return nil
+	}
+	// Don't close - connection is pooled and reused
+	tcp, tcpErr := client.ListeningPorts()
+	udp, udpErr := client.ListeningUDPPorts()
+
+	var health []ServiceHealth
+	for _, svc := range raw {
+		listening, err := tcp[svc.VPSPort], tcpErr
+		if svc.Protocol == parser.ProtocolUDP {
+			listening, err = udp[svc.VPSPort], udpErr
+		}
+		status := "not listening"
+		switch {
+		case err != nil:
+			status = "unknown"
+		case listening:
+			status = "listening"
+		}
+		health = append(health, ServiceHealth{
+			Name:    fmt.Sprintf("%s (%s %s)", svc.Name, svc.Protocol, svc.VPSAddr()),
+			Running: listening,
+			Status:  status,
+		})
+	}
+	return health
+}
+
+func checkMachine(ep ssh.Endpoint, services []string, composeDir string) MachineStatus {
+	status := MachineStatus{
+		Host:     ep.Host,
+		Online:   false,
+		Services: []ServiceHealth{},
+	}
+
+	client, err := ssh.GetClient(ep)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	// Don't close - connection is pooled and reused
+
+	status.Online = true
+
+	// Check systemd services
+	for _, svc := range services {
+		running, statusText, _ := client.GetServiceStatus(svc)
+		if statusText == "" {
+			statusText = "unknown"
+		}
+		status.Services = append(status.Services, ServiceHealth{
+			Name:    svc,
+			Running: running,
+			Status:  statusText,
+		})
+	}
+
+	// Check docker compose if configured
+	if composeDir != "" {
+		running, _, _ := client.GetDockerComposeStatus(composeDir)
+		statusText := "stopped"
+		if running {
+			statusText = "running"
+		}
+		status.Services = append(status.Services, ServiceHealth{
+			Name:    "caddy (docker)",
+			Running: running,
+			Status:  statusText,
+		})
+	}
+
+	return status
+}
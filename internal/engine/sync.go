@@ -0,0 +1,360 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/ssh"
+)
+
+// SyncOptions controls how a plan is deployed
+type SyncOptions struct {
+	Force bool // Upload and restart everything, even if nothing changed
+}
+
+// SyncResult reports what a sync did
+type SyncResult struct {
+	Changes    Changes
+	SnapshotID string // Recorded snapshot, empty when nothing changed
+}
+
+// Changes records which deployed files differ from the generated ones
+type Changes struct {
+	ServerTOML  bool
+	Caddyfile   bool
+	Imports     []string // Changed Caddyfile imports, by path relative to it
+	ClientTOMLs []string // Clients whose client.toml changed, by name
+}
+
+// Any reports whether any file needs uploading
+func (c Changes) Any() bool {
+	return c.server() || len(c.ClientTOMLs) > 0
+}
+
+// caddy reports whether the Caddyfile or any file it imports changed
+func (c Changes) caddy() bool {
+	return c.Caddyfile || len(c.Imports) > 0
+}
+
+// server reports whether any file on the VPS needs uploading
+func (c Changes) server() bool {
+	return c.ServerTOML || c.caddy()
+}
+
+// Sync deploys a plan: it compares the generated configs with the
+// deployed ones, uploads the changed files to the server and the clients
+// concurrently, records the deployment for rollback, then restarts only
+// the services whose config changed. A changed Caddyfile is validated by
+// caddy before anything is uploaded, and Caddy is reloaded in place
+// unless configured otherwise. When nothing changed, nothing is uploaded
+// or restarted.
+func Sync(cfg *config.Config, plan *SyncPlan, opts SyncOptions, events chan<- Event) (*SyncResult, error) {
+	emit(events, Event{Step: StepUpload, Target: TargetServer, Status: StatusRunning})
+	emit(events, Event{Step: StepUpload, Target: TargetClient, Status: StatusRunning})
+
+	changes := Changes{ServerTOML: true, ClientTOMLs: clientNames(cfg.Clients)}
+	if cfg.Server.Caddyfile != "" {
+		changes.Caddyfile = true
+		changes.Imports = sortedPaths(plan.Imports)
+	}
+	if !opts.Force {
+		var err error
+		if changes, err = detectChanges(cfg, plan, events); err != nil {
+			return nil, err
+		}
+	}
+	result := &SyncResult{Changes: changes}
+
+	restart := RestartOptions{
+		Server:      changes.ServerTOML,
+		Client:      len(changes.ClientTOMLs) > 0,
+		Clients:     changes.ClientTOMLs,
+		Caddy:       caddyChanged(cfg, changes),
+		ReloadCaddy: cfg.Server.CaddyReload != config.CaddyReloadRestart,
+	}
+
+	if !changes.Any() {
+		emit(events, Event{Step: StepValidate, Target: TargetCaddy, Status: StatusUnchanged})
+		emit(events, Event{Step: StepUpload, Target: TargetServer, Status: StatusUnchanged, Message: cfg.Server.Host})
+		emit(events, Event{Step: StepUpload, Target: TargetClient, Status: StatusUnchanged, Message: cfg.ClientHosts()})
+		emit(events, Event{Step: StepRecord, Target: TargetLocal, Status: StatusUnchanged})
+		emitUnchangedRestarts(cfg, restart, events)
+		return result, nil
+	}
+
+	if changes.caddy() {
+		if err := validateCaddyfile(cfg, plan, events); err != nil {
+			return nil, err
+		}
+	} else {
+		emit(events, Event{Step: StepValidate, Target: TargetCaddy, Status: StatusUnchanged})
+	}
+
+	snap := newSnapshot(cfg, plan)
+	if err := upload(cfg, plan, snap, changes, events); err != nil {
+		return nil, err
+	}
+
+	emit(events, Event{Step: StepRecord, Target: TargetLocal, Status: StatusRunning})
+	if err := saveLocalSnapshot(snap); err != nil {
+		return nil, fail(events, StepRecord, TargetLocal, "Couldn't record deployment snapshot", err)
+	}
+	emit(events, Event{Step: StepRecord, Target: TargetLocal, Status: StatusDone, Message: snap.ID})
+	result.SnapshotID = snap.ID
+
+	emitUnchangedRestarts(cfg, restart, events)
+	if err := Restart(cfg, restart, events); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// caddyChanged reports whether Caddy needs restarting. When rcm doesn't
+// manage the Caddyfile it can't tell, so Caddy follows the server config.
+func caddyChanged(cfg *config.Config, changes Changes) bool {
+	if cfg.Server.CaddyComposeDir == "" {
+		return false
+	}
+	if cfg.Server.Caddyfile == "" {
+		return changes.ServerTOML
+	}
+	return changes.caddy()
+}
+
+// emitUnchangedRestarts reports the restarts skipped by opts
+func emitUnchangedRestarts(cfg *config.Config, opts RestartOptions, events chan<- Event) {
+	if !opts.Server {
+		emit(events, Event{Step: StepRestart, Target: TargetServer, Status: StatusUnchanged})
+	}
+	if !opts.Caddy && cfg.Server.CaddyComposeDir != "" {
+		emit(events, Event{Step: StepRestart, Target: TargetCaddy, Status: StatusUnchanged})
+	}
+	if !opts.Client {
+		emit(events, Event{Step: StepRestart, Target: TargetClient, Status: StatusUnchanged})
+	}
+}
+
+// detectChanges hashes the deployed configs on the server and every client
+// and compares them with the generated ones
+func detectChanges(cfg *config.Config, plan *SyncPlan, events chan<- Event) (Changes, error) {
+	var changes Changes
+	clientChanged := make([]bool, len(cfg.Clients))
+	tasks := []func() error{
+		func() error {
+			client, err := connectServer(cfg)
+			if err != nil {
+				return fail(events, StepUpload, TargetServer, fmt.Sprintf("Couldn't connect to server (%s)", cfg.Server.Host), err)
+			}
+			// Don't close - connection is pooled and reused
+
+			if changes.ServerTOML, err = fileChanged(client, cfg.Server.RatholeConfig, plan.ServerTOML); err != nil {
+				return fail(events, StepUpload, TargetServer, "Couldn't read rathole config on server", err)
+			}
+			if cfg.Server.Caddyfile != "" {
+				if changes.Caddyfile, err = fileChanged(client, cfg.Server.Caddyfile, plan.Caddyfile); err != nil {
+					return fail(events, StepUpload, TargetServer, "Couldn't read Caddyfile on server", err)
+				}
+				for _, rel := range sortedPaths(plan.Imports) {
+					changed, err := fileChanged(client, remoteImportPath(cfg, rel), plan.Imports[rel])
+					if err != nil {
+						return fail(events, StepUpload, TargetServer, fmt.Sprintf("Couldn't read %s on server", rel), err)
+					}
+					if changed {
+						changes.Imports = append(changes.Imports, rel)
+					}
+				}
+			}
+			return nil
+		},
+	}
+	for i, c := range cfg.Clients {
+		tasks = append(tasks, func() error {
+			client, err := connectClient(cfg, c)
+			if err != nil {
+				return fail(events, StepUpload, TargetClient, fmt.Sprintf("Couldn't connect to client (%s)", c.Host), err)
+			}
+			// Don't close - connection is pooled and reused
+
+			if clientChanged[i], err = fileChanged(client, c.RatholeConfig, plan.ClientTOMLs[c.Name]); err != nil {
+				return fail(events, StepUpload, TargetClient, fmt.Sprintf("Couldn't read config on client (%s)", c.Host), err)
+			}
+			return nil
+		})
+	}
+	err := runParallel(tasks...)
+
+	for i, c := range cfg.Clients {
+		if clientChanged[i] {
+			changes.ClientTOMLs = append(changes.ClientTOMLs, c.Name)
+		}
+	}
+	return changes, err
+}
+
+// fileChanged reports whether the remote file differs from content
+func fileChanged(client *ssh.Client, remotePath, content string) (bool, error) {
+	remote, err := client.FileHash(remotePath)
+	if err != nil {
+		return false, err
+	}
+	return remote != contentHash(content), nil
+}
+
+// remoteImportPath returns where a Caddyfile import is deployed
+func remoteImportPath(cfg *config.Config, rel string) string {
+	return path.Join(path.Dir(cfg.Server.Caddyfile), rel)
+}
+
+// contentHash returns the hex SHA-256 of content, as printed by sha256sum
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// validateCaddyfile uploads the Caddyfile and its imports to a scratch
+// directory on the server and has caddy check them there, so an invalid
+// file never goes live
+func validateCaddyfile(cfg *config.Config, plan *SyncPlan, events chan<- Event) error {
+	mode := cfg.Server.CaddyValidate
+	if mode == config.CaddyValidateOff {
+		return nil
+	}
+	emit(events, Event{Step: StepValidate, Target: TargetCaddy, Status: StatusRunning})
+
+	client, err := connectServer(cfg)
+	if err != nil {
+		return fail(events, StepValidate, TargetCaddy, fmt.Sprintf("Couldn't connect to server (%s)", cfg.Server.Host), err)
+	}
+	// Don't close - connection is pooled and reused
+
+	staging, err := client.MakeTempDir()
+	if err != nil {
+		return fail(events, StepValidate, TargetCaddy, "Couldn't upload Caddyfile for validation", err)
+	}
+	defer client.RemoveDir(staging) // Best effort; it's under /tmp anyway
+
+	caddyfile := path.Join(staging, path.Base(cfg.Server.Caddyfile))
+	files := map[string]string{caddyfile: plan.Caddyfile}
+	for rel, content := range plan.Imports {
+		files[path.Join(staging, rel)] = content
+	}
+	for name, content := range files {
+		if err := client.UploadContent(content, name, ssh.UploadOptions{AsUser: true}); err != nil {
+			return fail(events, StepValidate, TargetCaddy, "Couldn't upload Caddyfile for validation", err)
+		}
+	}
+
+	composeDir := ""
+	if mode == config.CaddyValidateCompose || mode == config.CaddyValidateAuto {
+		composeDir = cfg.Server.CaddyComposeDir
+	}
+	err = client.ValidateCaddyfile(caddyfile, composeDir, cfg.Server.CaddyService)
+
+	var invalid *ssh.CaddyfileError
+	switch {
+	case err == nil:
+		emit(events, Event{Step: StepValidate, Target: TargetCaddy, Status: StatusDone})
+	case errors.As(err, &invalid):
+		return fail(events, StepValidate, TargetCaddy, "Caddyfile rejected by caddy, nothing was deployed", err)
+	case errors.Is(err, ssh.ErrNoCaddy) && mode == config.CaddyValidateAuto:
+		emit(events, Event{Step: StepValidate, Target: TargetCaddy, Status: StatusDone, Message: "skipped, caddy not found"})
+	default:
+		return fail(events, StepValidate, TargetCaddy, "Couldn't validate Caddyfile", err)
+	}
+	return nil
+}
+
+// upload writes the changed configs, and each machine's part of the
+// snapshot, to the server and every client. A machine whose configs are
+// unchanged still gets its part of the snapshot so rollbacks can be
+// assembled from the remotes.
+func upload(cfg *config.Config, plan *SyncPlan, snap *Snapshot, changes Changes, events chan<- Event) error {
+	return runParallel(
+		func() error { return uploadServer(cfg, plan, snap, changes, events) },
+		func() error { return uploadClients(cfg, cfg.Clients, plan, snap, changes, events) },
+	)
+}
+
+func uploadServer(cfg *config.Config, plan *SyncPlan, snap *Snapshot, changes Changes, events chan<- Event) error {
+	client, err := connectServer(cfg)
+	if err != nil {
+		return fail(events, StepUpload, TargetServer, fmt.Sprintf("Couldn't connect to server (%s)", cfg.Server.Host), err)
+	}
+	// Don't close - connection is pooled and reused
+
+	if changes.ServerTOML {
+		if err := client.UploadContent(plan.ServerTOML, cfg.Server.RatholeConfig, serverUpload(cfg)); err != nil {
+			return fail(events, StepUpload, TargetServer, "Couldn't upload rathole config to server", err)
+		}
+	}
+
+	if changes.Caddyfile {
+		if err := client.UploadContent(plan.Caddyfile, cfg.Server.Caddyfile, caddyUpload(cfg)); err != nil {
+			return fail(events, StepUpload, TargetServer, "Couldn't upload Caddyfile to server", err)
+		}
+	}
+
+	for _, rel := range changes.Imports {
+		if err := client.UploadContent(plan.Imports[rel], remoteImportPath(cfg, rel), caddyUpload(cfg)); err != nil {
+			return fail(events, StepUpload, TargetServer, fmt.Sprintf("Couldn't upload %s to server", rel), err)
+		}
+	}
+
+	if err := saveRemoteSnapshot(client, snap.serverPart()); err != nil {
+		return fail(events, StepUpload, TargetServer, "Couldn't record snapshot on server", err)
+	}
+
+	status := StatusDone
+	if !changes.server() {
+		status = StatusUnchanged
+	}
+	emit(events, Event{Step: StepUpload, Target: TargetServer, Status: status, Message: cfg.Server.Host})
+	return nil
+}
+
+// uploadClients uploads the changed client.toml files to clients
+// concurrently, with each client's part of the snapshot
+func uploadClients(cfg *config.Config, clients []config.ClientConfig, plan *SyncPlan, snap *Snapshot, changes Changes, events chan<- Event) error {
+	return eachClient(clients, StepUpload, hostsOf(clients), events, func(c config.ClientConfig) (bool, error) {
+		client, err := connectClient(cfg, c)
+		if err != nil {
+			return false, fail(events, StepUpload, TargetClient, fmt.Sprintf("Couldn't connect to client (%s)", c.Host), err)
+		}
+		// Don't close - connection is pooled and reused
+
+		changed := slices.Contains(changes.ClientTOMLs, c.Name)
+		if changed {
+			if err := client.UploadContent(plan.ClientTOMLs[c.Name], c.RatholeConfig, clientUpload(c)); err != nil {
+				return false, fail(events, StepUpload, TargetClient, fmt.Sprintf("Couldn't upload config to client (%s)", c.Host), err)
+			}
+		}
+
+		if err := saveRemoteSnapshot(client, snap.clientPart(c.Name)); err != nil {
+			return false, fail(events, StepUpload, TargetClient, fmt.Sprintf("Couldn't record snapshot on client (%s)", c.Host), err)
+		}
+		return changed, nil
+	})
+}
+
+// runParallel runs tasks concurrently and returns the first error reported
+func runParallel(tasks ...func() error) error {
+	errCh := make(chan error, len(tasks))
+	for _, task := range tasks {
+		go func() {
+			errCh <- task()
+		}()
+	}
+
+	var firstErr error
+	for range tasks {
+		if err := <-errCh; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,41 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+)
+
+func TestContentHashMatchesSha256sum(t *testing.T) {
+	// printf 'abc' | sha256sum
+	want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if got := contentHash("abc"); got != want {
+		t.Errorf("contentHash(\"abc\") = %s, want %s", got, want)
+	}
+}
+
+func TestCaddyChanged(t *testing.T) {
+	tests := []struct {
+		name       string
+		caddyfile  string
+		composeDir string
+		changes    Changes
+		want       bool
+	}{
+		{"no compose dir", "/etc/caddy/Caddyfile", "", Changes{Caddyfile: true}, false},
+		{"caddyfile changed", "/etc/caddy/Caddyfile", "/opt/caddy", Changes{Caddyfile: true}, true},
+		{"import changed", "/etc/caddy/Caddyfile", "/opt/caddy", Changes{Imports: []string{"sites/a.caddy"}}, true},
+		{"only rathole changed", "/etc/caddy/Caddyfile", "/opt/caddy", Changes{ServerTOML: true}, false},
+		{"unmanaged caddyfile follows server", "", "/opt/caddy", Changes{ServerTOML: true}, true},
+		{"unmanaged caddyfile, client only", "", "/opt/caddy", Changes{ClientTOMLs: []string{""}}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := &config.Config{}
+		cfg.Server.Caddyfile = tt.caddyfile
+		cfg.Server.CaddyComposeDir = tt.composeDir
+		if got := caddyChanged(cfg, tt.changes); got != tt.want {
+			t.Errorf("%s: caddyChanged = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
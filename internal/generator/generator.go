@@ -4,6 +4,12 @@ import (
 	"bytes"
 	"embed"
 	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"text/template"
 
 	"github.com/AhmedAburady/rcm-go/internal/config"
@@ -21,22 +27,66 @@ func GenerateServerTOML(cfg *config.Config, services []parser.Service) (string,
 	})
 }
 
-// GenerateClientTOML generates client.toml content
-func GenerateClientTOML(cfg *config.Config, services []parser.Service) (string, error) {
+// GenerateClientTOML generates the client.toml of client, which runs
+// services and reaches rathole on the VPS at tunnelHost
+func GenerateClientTOML(cfg *config.Config, client config.ClientConfig, tunnelHost string, services []parser.Service) (string, error) {
 	return executeTemplate("templates/client.toml.tmpl", map[string]interface{}{
-		"Server":   cfg.Server,
-		"Rathole":  cfg.Rathole,
-		"Services": services,
+		"RemoteAddr": net.JoinHostPort(tunnelHost, strconv.Itoa(cfg.Rathole.BindPort)),
+		"TunnelHost": tunnelHost,
+		"Client":     client,
+		"Rathole":    cfg.Rathole,
+		"Services":   services,
 	})
 }
 
+// SiteData is what a site template is rendered with
+type SiteData struct {
+	Name         string
+	LocalAddr    string
+	Domains      []string
+	Port         int    // VPS port the tunnel ends on
+	Upstream     string // reverse_proxy upstream, e.g. 127.0.0.1:8001
+	HTTPSBackend bool   // The local service speaks HTTPS, usually self-signed
+	Indent       string // One level of indentation, as used in the Caddyfile
+}
+
+// In returns n levels of indentation
+func (d SiteData) In(n int) string {
+	return strings.Repeat(d.Indent, n)
+}
+
+// GenerateSite renders the site block of a new service from the named
+// template. A <name>.caddy.tmpl file in dir takes precedence over the
+// built-in templates.
+func GenerateSite(name, dir string, data SiteData) (string, error) {
+	if dir != "" {
+		path := filepath.Join(dir, name+".caddy.tmpl")
+		content, err := os.ReadFile(path)
+		if err == nil {
+			return renderTemplate(path, string(content), data)
+		}
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("read template %s: %w", path, err)
+		}
+	}
+
+	builtin := "templates/site-" + name + ".caddy.tmpl"
+	if _, err := fs.Stat(templateFS, builtin); err != nil {
+		return "", fmt.Errorf("unknown site template %q", name)
+	}
+	return executeTemplate(builtin, data)
+}
+
 func executeTemplate(name string, data interface{}) (string, error) {
 	tmplContent, err := templateFS.ReadFile(name)
 	if err != nil {
 		return "", fmt.Errorf("read template %s: %w", name, err)
 	}
+	return renderTemplate(name, string(tmplContent), data)
+}
 
-	tmpl, err := template.New(name).Parse(string(tmplContent))
+func renderTemplate(name, content string, data interface{}) (string, error) {
+	tmpl, err := template.New(name).Funcs(template.FuncMap{"join": strings.Join}).Parse(content)
 	if err != nil {
 		return "", fmt.Errorf("parse template %s: %w", name, err)
 	}
@@ -1,6 +1,8 @@
 package generator
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -13,6 +15,7 @@ func TestGenerateServerTOML(t *testing.T) {
 		Rathole: config.RatholeConfig{
 			BindPort:         2333,
 			Token:            "test-token",
+			Transport:        config.TransportNoise,
 			ServerPrivateKey: "test-private-key",
 		},
 	}
@@ -50,6 +53,7 @@ func TestGenerateClientTOML(t *testing.T) {
 		Rathole: config.RatholeConfig{
 			BindPort:        2333,
 			Token:           "test-token",
+			Transport:       config.TransportNoise,
 			ServerPublicKey: "test-public-key",
 		},
 	}
@@ -58,7 +62,7 @@ func TestGenerateClientTOML(t *testing.T) {
 		{Name: "web", LocalAddr: "192.168.1.10:8080", VPSPort: 8001},
 	}
 
-	output, err := GenerateClientTOML(cfg, services)
+	output, err := GenerateClientTOML(cfg, cfg.Client, cfg.Server.Host, services)
 	if err != nil {
 		t.Fatalf("GenerateClientTOML failed: %v", err)
 	}
@@ -74,3 +78,178 @@ func TestGenerateClientTOML(t *testing.T) {
 		t.Error("Missing local_addr")
 	}
 }
+
+func TestGenerateClientTOMLTunnelHost(t *testing.T) {
+	tests := []struct {
+		name string
+		host string
+		want string
+	}{
+		{"hostname", "tunnel.example.com", "tunnel.example.com:2333"},
+		{"ipv4", "203.0.113.50", "203.0.113.50:2333"},
+		{"ipv6", "2001:db8::1", "[2001:db8::1]:2333"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Server: config.ServerConfig{Host: "vps"}, Rathole: config.RatholeConfig{BindPort: 2333, Transport: config.TransportTLS}}
+			output, err := GenerateClientTOML(cfg, cfg.Client, tt.host, nil)
+			if err != nil {
+				t.Fatalf("GenerateClientTOML failed: %v", err)
+			}
+			if !strings.Contains(output, "remote_addr = \""+tt.want+"\"") {
+				t.Errorf("Expected remote_addr %s, got:\n%s", tt.want, output)
+			}
+			if !strings.Contains(output, "hostname = \""+tt.host+"\"") {
+				t.Errorf("Expected TLS hostname %s, got:\n%s", tt.host, output)
+			}
+		})
+	}
+}
+
+func TestGenerateTransports(t *testing.T) {
+	tls := config.TLSConfig{PKCS12: "/etc/rathole/identity.pfx", PKCS12Password: "secret", TrustedRoot: "/etc/rathole/ca.pem"}
+	tests := []struct {
+		name   string
+		cfg    config.RatholeConfig
+		server string
+		client string
+	}{
+		{
+			name:   "tcp",
+			cfg:    config.RatholeConfig{Transport: config.TransportTCP},
+			server: "[server.transport]\ntype = \"tcp\"\n\n[server.services",
+			client: "[client.transport]\ntype = \"tcp\"\n\n[client.services",
+		},
+		{
+			name:   "noise with client keys",
+			cfg:    config.RatholeConfig{Transport: config.TransportNoise, ServerPrivateKey: "spriv", ServerPublicKey: "spub", Noise: config.NoiseConfig{Pattern: "Noise_KK_25519_ChaChaPoly_BLAKE2s", ClientPrivateKey: "cpriv", ClientPublicKey: "cpub"}},
+			server: "[server.transport.noise]\npattern = \"Noise_KK_25519_ChaChaPoly_BLAKE2s\"\nlocal_private_key = \"spriv\"\nremote_public_key = \"cpub\"\n",
+			client: "[client.transport.noise]\npattern = \"Noise_KK_25519_ChaChaPoly_BLAKE2s\"\nlocal_private_key = \"cpriv\"\nremote_public_key = \"spub\"\n",
+		},
+		{
+			name:   "tls",
+			cfg:    config.RatholeConfig{Transport: config.TransportTLS, TLS: tls},
+			server: "type = \"tls\"\n\n[server.transport.tls]\npkcs12 = \"/etc/rathole/identity.pfx\"\npkcs12_password = \"secret\"\n",
+			client: "type = \"tls\"\n\n[client.transport.tls]\ntrusted_root = \"/etc/rathole/ca.pem\"\nhostname = \"vps.example.com\"\n",
+		},
+		{
+			name:   "websocket over tls",
+			cfg:    config.RatholeConfig{Transport: config.TransportWebsocket, Websocket: config.WebsocketConfig{TLS: true}, TLS: tls},
+			server: "type = \"websocket\"\n\n[server.transport.websocket]\ntls = true\n\n[server.transport.tls]\npkcs12",
+			client: "type = \"websocket\"\n\n[client.transport.websocket]\ntls = true\n\n[client.transport.tls]\ntrusted_root",
+		},
+	}
+
+	services := []parser.Service{{Name: "web", LocalAddr: "192.168.1.10:8080", VPSPort: 8001}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Server: config.ServerConfig{Host: "vps.example.com"}, Rathole: tt.cfg}
+			server, err := GenerateServerTOML(cfg, services)
+			if err != nil {
+				t.Fatalf("GenerateServerTOML failed: %v", err)
+			}
+			if !strings.Contains(server, tt.server) {
+				t.Errorf("server.toml missing %q, got:\n%s", tt.server, server)
+			}
+			client, err := GenerateClientTOML(cfg, cfg.Client, cfg.Server.Host, services)
+			if err != nil {
+				t.Fatalf("GenerateClientTOML failed: %v", err)
+			}
+			if !strings.Contains(client, tt.client) {
+				t.Errorf("client.toml missing %q, got:\n%s", tt.client, client)
+			}
+		})
+	}
+}
+
+func TestGenerateUDPService(t *testing.T) {
+	cfg := &config.Config{Rathole: config.RatholeConfig{BindPort: 2333}}
+	services := []parser.Service{
+		{Name: "web", LocalAddr: "192.168.1.10:8080", VPSPort: 8001, Protocol: parser.ProtocolTCP},
+		{Name: "wireguard", LocalAddr: "192.168.1.5:51820", VPSPort: 51820, Protocol: parser.ProtocolUDP},
+	}
+
+	server, err := GenerateServerTOML(cfg, services)
+	if err != nil {
+		t.Fatalf("GenerateServerTOML failed: %v", err)
+	}
+	if !strings.Contains(server, "[server.services.web]\nbind_addr") {
+		t.Errorf("Expected no type for a TCP service, got:\n%s", server)
+	}
+	if !strings.Contains(server, "[server.services.wireguard]\ntype = \"udp\"\nbind_addr = \"0.0.0.0:51820\"") {
+		t.Errorf("Expected a UDP server service, got:\n%s", server)
+	}
+
+	client, err := GenerateClientTOML(cfg, cfg.Client, cfg.Server.Host, services)
+	if err != nil {
+		t.Fatalf("GenerateClientTOML failed: %v", err)
+	}
+	if !strings.Contains(client, "[client.services.wireguard]\ntype = \"udp\"\nlocal_addr = \"192.168.1.5:51820\"") {
+		t.Errorf("Expected a UDP client service, got:\n%s", client)
+	}
+}
+
+func TestGenerateServiceOptions(t *testing.T) {
+	cfg := &config.Config{Rathole: config.RatholeConfig{BindPort: 2333}}
+	nodelay := false
+	services := []parser.Service{
+		{Name: "ssh", LocalAddr: "192.168.1.2:22", VPSPort: 2222, Protocol: parser.ProtocolTCP, Options: parser.Options{Token: "s3cret", Nodelay: &nodelay, RetryInterval: 5}},
+	}
+
+	server, err := GenerateServerTOML(cfg, services)
+	if err != nil {
+		t.Fatalf("GenerateServerTOML failed: %v", err)
+	}
+	if !strings.Contains(server, "[server.services.ssh]\ntoken = \"s3cret\"\nnodelay = false\nbind_addr = \"0.0.0.0:2222\"") {
+		t.Errorf("Expected service options on the server, got:\n%s", server)
+	}
+	if strings.Contains(server, "retry_interval") {
+		t.Errorf("retry_interval is a client option, got:\n%s", server)
+	}
+
+	client, err := GenerateClientTOML(cfg, cfg.Client, cfg.Server.Host, services)
+	if err != nil {
+		t.Fatalf("GenerateClientTOML failed: %v", err)
+	}
+	if !strings.Contains(client, "[client.services.ssh]\ntoken = \"s3cret\"\nnodelay = false\nretry_interval = 5\nlocal_addr") {
+		t.Errorf("Expected service options on the client, got:\n%s", client)
+	}
+}
+
+func TestGenerateSite(t *testing.T) {
+	data := SiteData{
+		Name:         "portainer",
+		Domains:      []string{"portainer.example.com", "p.example.com"},
+		Upstream:     "https://127.0.0.1:8003",
+		HTTPSBackend: true,
+		Indent:       "    ",
+	}
+
+	output, err := GenerateSite("default", "", data)
+	if err != nil {
+		t.Fatalf("GenerateSite failed: %v", err)
+	}
+	want := `portainer.example.com, p.example.com {
+    reverse_proxy https://127.0.0.1:8003 {
+        transport http {
+            tls_insecure_skip_verify
+        }
+    }
+}
+`
+	if output != want {
+		t.Errorf("Unexpected site:\n%s", output)
+	}
+
+	// A template in the templates directory wins over the built-in one
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "default.caddy.tmpl"), []byte("{{ index .Domains 0 }} {\n\tredir https://example.com\n}\n"), 0644)
+	output, err = GenerateSite("default", dir, data)
+	if err != nil || !strings.Contains(output, "redir") {
+		t.Errorf("Expected the custom template, got %q (%v)", output, err)
+	}
+
+	if _, err := GenerateSite("nope", dir, data); err == nil {
+		t.Error("Expected an error for an unknown template")
+	}
+}
@@ -0,0 +1,36 @@
+package generator
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+// Keypair is an X25519 noise keypair, base64 encoded the way
+// rathole --genkey prints it
+type Keypair struct {
+	Private string
+	Public  string
+}
+
+// NoiseKeypair generates a keypair for rathole's noise transport
+func NoiseKeypair() (Keypair, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return Keypair{}, fmt.Errorf("generate noise keypair: %w", err)
+	}
+	return Keypair{
+		Private: base64.StdEncoding.EncodeToString(key.Bytes()),
+		Public:  base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()),
+	}, nil
+}
+
+// Token generates a random rathole token, like openssl rand -base64 32
+func Token() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
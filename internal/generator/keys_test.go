@@ -0,0 +1,37 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+func TestNoiseKeypair(t *testing.T) {
+	keys, err := NoiseKeypair()
+	if err != nil {
+		t.Fatalf("NoiseKeypair failed: %v", err)
+	}
+	private, err := base64.StdEncoding.DecodeString(keys.Private)
+	if err != nil || len(private) != 32 {
+		t.Fatalf("private key %q isn't 32 bytes of base64", keys.Private)
+	}
+	public, err := base64.StdEncoding.DecodeString(keys.Public)
+	if err != nil || len(public) != 32 {
+		t.Fatalf("public key %q isn't 32 bytes of base64", keys.Public)
+	}
+
+	want, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		t.Fatalf("X25519 failed: %v", err)
+	}
+	if !bytes.Equal(public, want) {
+		t.Errorf("public key doesn't belong to the private key")
+	}
+
+	other, _ := NoiseKeypair()
+	if other.Private == keys.Private {
+		t.Errorf("two keypairs are the same")
+	}
+}
@@ -0,0 +1,114 @@
+package parser
+
+import "strings"
+
+// File is a parsed Caddyfile
+type File struct {
+	Name     string
+	Src      string
+	Blocks   []*ServerBlock // Global options, snippets and sites, in source order
+	Comments []Comment      // Every comment, in source order
+	Nested   bool           // Imported inside a block, so it holds directives
+}
+
+// ServerBlock is a top level block: the global options block (no keys), a
+// snippet definition "(name)", a site with its addresses, or an import line
+type ServerBlock struct {
+	Keys     []Token    // One token per address, commas removed
+	Body     Block      // Directives of the block
+	Import   *Directive // Set for a top level import line, which has no keys or body
+	Comments []Comment  // Comments between the previous block and this one
+	Inner    []Comment  // Comments inside the block
+	Snippet  string     // Snippet a site imported at the top level comes from, which keeps its comments
+	Pos      Pos        // First key, or the opening brace of global options
+	End      Pos        // Just past the closing brace, or the last token
+}
+
+// Block is the body of a server block or directive
+type Block struct {
+	Open       *Token // nil for a lone site written without braces
+	Close      *Token
+	Directives []*Directive
+}
+
+// Directive is a logical line inside a block: a name, its arguments and an
+// optional nested block
+type Directive struct {
+	Name  Token
+	Args  []Token
+	Block *Block // nil when the directive has no braces
+	Pos   Pos
+	End   Pos // Just past the last argument or the closing brace
+}
+
+// inBlock reports whether pos is inside one of the file's blocks
+func (f *File) inBlock(pos Pos) bool {
+	if f.Nested {
+		return true
+	}
+	for _, b := range f.Blocks {
+		if pos.Offset > b.Pos.Offset && pos.Offset < b.End.Offset {
+			return true
+		}
+	}
+	return false
+}
+
+// IsGlobal reports whether the block is the global options block
+func (b *ServerBlock) IsGlobal() bool {
+	return len(b.Keys) == 0 && b.Import == nil
+}
+
+// IsSnippet reports whether the block defines a snippet, e.g. (headers)
+func (b *ServerBlock) IsSnippet() bool {
+	return b.SnippetName() != ""
+}
+
+// SnippetName returns the name of a snippet definition, or ""
+func (b *ServerBlock) SnippetName() string {
+	if len(b.Keys) != 1 {
+		return ""
+	}
+	key := b.Keys[0].Text
+	if len(key) > 2 && strings.HasPrefix(key, "(") && strings.HasSuffix(key, ")") {
+		return key[1 : len(key)-1]
+	}
+	return ""
+}
+
+// Addresses returns the site addresses of the block
+func (b *ServerBlock) Addresses() []string {
+	addrs := make([]string, len(b.Keys))
+	for i, key := range b.Keys {
+		addrs[i] = key.Text
+	}
+	return addrs
+}
+
+// Sites returns the site blocks of the file itself, leaving out global
+// options, snippets and imports
+func (f *File) Sites() []*ServerBlock {
+	return siteBlocks(f.Blocks)
+}
+
+// siteBlocks filters out global options, snippets and imports
+func siteBlocks(blocks []*ServerBlock) []*ServerBlock {
+	var out []*ServerBlock
+	for _, b := range blocks {
+		if !b.IsGlobal() && !b.IsSnippet() && b.Import == nil {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// Walk calls fn for every directive in the block, nested ones included,
+// in source order
+func (b *Block) Walk(fn func(d *Directive)) {
+	for _, d := range b.Directives {
+		fn(d)
+		if d.Block != nil {
+			d.Block.Walk(fn)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package parser
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxImportDepth limits how deep imports may nest, so an import cycle
+// fails instead of recursing forever
+const maxImportDepth = 16
+
+// argsRe matches snippet argument placeholders: {args[0]}, {args[1:]},
+// {args[:]} and the older {args.0}
+var argsRe = regexp.MustCompile(`\{args(?:\[(\d*)(:?)(\d*)\]|\.(\d+))\}`)
+
+// FS gives the parser access to the files a Caddyfile imports
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+	Glob(pattern string) ([]string, error)
+}
+
+// OSFS reads imported files from the local disk
+var OSFS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) ReadFile(name string) ([]byte, error)  { return os.ReadFile(name) }
+func (osFS) Glob(pattern string) ([]string, error) { return filepath.Glob(pattern) }
+
+// MapFS serves files from memory, keyed by path
+type MapFS map[string]string
+
+func (m MapFS) ReadFile(name string) ([]byte, error) {
+	content, ok := m[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return []byte(content), nil
+}
+
+func (m MapFS) Glob(pattern string) ([]string, error) {
+	var matches []string
+	for name := range m {
+		ok, err := path.Match(pattern, name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// Caddyfile is a Caddyfile with its snippets and imports expanded
+type Caddyfile struct {
+	Files  []*File        // The Caddyfile first, then each imported file once
+	Blocks []*ServerBlock // Server blocks with every import replaced by what it imports
+}
+
+// Sites returns the site blocks, including imported ones
+func (c *Caddyfile) Sites() []*ServerBlock {
+	return siteBlocks(c.Blocks)
+}
+
+// Imports returns the files imported by the Caddyfile, directly or not
+func (c *Caddyfile) Imports() []*File {
+	return c.Files[1:]
+}
+
+// Load parses the Caddyfile at path, read from fsys, and expands its
+// snippets and imports like Caddy does. Import paths and globs are
+// resolved relative to the importing file.
+func Load(path string, fsys FS) (*Caddyfile, error) {
+	e := &expander{
+		fsys:     fsys,
+		snippets: make(map[string]snippet),
+		seen:     make(map[string]bool),
+	}
+
+	f, err := e.parseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := e.blocks(f, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Caddyfile{Files: e.files, Blocks: blocks}, nil
+}
+
+// snippet is a snippet definition and the directory its imports are
+// relative to
+type snippet struct {
+	block *ServerBlock
+	dir   string
+}
+
+// expander replaces imports with the snippets and files they name
+type expander struct {
+	fsys     FS
+	snippets map[string]snippet
+	files    []*File
+	seen     map[string]bool
+}
+
+func (e *expander) read(name string) (string, error) {
+	content, err := e.fsys.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// record adds a file to the list of files the Caddyfile is made of
+func (e *expander) record(f *File) {
+	if !e.seen[f.Name] {
+		e.seen[f.Name] = true
+		e.files = append(e.files, f)
+	}
+}
+
+func (e *expander) parseFile(name string) (*File, error) {
+	content, err := e.read(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := Parse(name, content)
+	if err != nil {
+		return nil, err
+	}
+	e.record(f)
+	return f, nil
+}
+
+// blocks expands the server blocks of a file. args are the arguments of
+// the import that brought the file in, nil for the Caddyfile itself.
+func (e *expander) blocks(f *File, args []string, depth int) ([]*ServerBlock, error) {
+	dir := filepath.Dir(f.Name)
+	for _, b := range f.Blocks {
+		if name := b.SnippetName(); name != "" {
+			e.snippets[name] = snippet{block: b, dir: dir}
+		}
+	}
+
+	var out []*ServerBlock
+	for _, b := range f.Blocks {
+		switch {
+		case b.IsSnippet():
+		case b.Import != nil:
+			imp := substitute(b.Import, args)
+			if imp == nil {
+				continue
+			}
+			imported, err := e.importBlocks(imp, dir, depth)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, imported...)
+		default:
+			body, err := e.directives(b.Body.Directives, dir, args, depth)
+			if err != nil {
+				return nil, err
+			}
+			expanded := *b
+			expanded.Keys = substituteTokens(b.Keys, args)
+			expanded.Body.Directives = body
+			out = append(out, &expanded)
+		}
+	}
+	return out, nil
+}
+
+// importBlocks expands a top level import, which brings in whole server
+// blocks
+func (e *expander) importBlocks(d *Directive, dir string, depth int) ([]*ServerBlock, error) {
+	pattern, args, err := importTarget(d, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	// A snippet imported at the top level holds site blocks
+	if sn, ok := e.snippets[pattern]; ok {
+		dirs, err := e.directives(sn.block.Body.Directives, sn.dir, args, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		var out []*ServerBlock
+		for _, site := range dirs {
+			if site.Block == nil {
+				return nil, &SyntaxError{Pos: site.Pos, Msg: fmt.Sprintf("snippet %s is imported outside a block, so it must only hold site blocks", pattern)}
+			}
+			var keys []Token
+			for _, tok := range append([]Token{site.Name}, site.Args...) {
+				keys = append(keys, splitKeys(tok)...)
+			}
+			out = append(out, &ServerBlock{Keys: keys, Body: *site.Block, Pos: site.Pos, End: site.End, Snippet: pattern})
+		}
+		return out, nil
+	}
+
+	names, err := e.resolve(pattern, dir, d)
+	if err != nil {
+		return nil, err
+	}
+	var out []*ServerBlock
+	for _, name := range names {
+		f, err := e.parseFile(name)
+		if err != nil {
+			return nil, err
+		}
+		blocks, err := e.blocks(f, args, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, blocks...)
+	}
+	return out, nil
+}
+
+// directives expands the imports among dirs, nested blocks included
+func (e *expander) directives(dirs []*Directive, dir string, args []string, depth int) ([]*Directive, error) {
+	var out []*Directive
+	for _, d := range dirs {
+		d = substitute(d, args)
+		if d == nil {
+			continue
+		}
+
+		if d.Name.Text == "import" && d.Name.Kind == TokenWord {
+			imported, err := e.importDirectives(d, dir, depth)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, imported...)
+			continue
+		}
+
+		if d.Block != nil {
+			body, err := e.directives(d.Block.Directives, dir, args, depth)
+			if err != nil {
+				return nil, err
+			}
+			block := *d.Block
+			block.Directives = body
+			d.Block = &block
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// importDirectives expands an import inside a block, which brings in the
+// directives of a snippet or of each matching file
+func (e *expander) importDirectives(d *Directive, dir string, depth int) ([]*Directive, error) {
+	pattern, args, err := importTarget(d, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	if sn, ok := e.snippets[pattern]; ok {
+		return e.directives(sn.block.Body.Directives, sn.dir, args, depth+1)
+	}
+
+	names, err := e.resolve(pattern, dir, d)
+	if err != nil {
+		return nil, err
+	}
+	var out []*Directive
+	for _, name := range names {
+		content, err := e.read(name)
+		if err != nil {
+			return nil, err
+		}
+		dirs, comments, err := parseDirectives(name, content)
+		if err != nil {
+			return nil, err
+		}
+		e.record(&File{Name: name, Src: content, Comments: comments, Nested: true})
+
+		expanded, err := e.directives(dirs, filepath.Dir(name), args, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, expanded...)
+	}
+	return out, nil
+}
+
+// importTarget returns what an import names and the arguments it passes
+func importTarget(d *Directive, depth int) (string, []string, error) {
+	if depth >= maxImportDepth {
+		return "", nil, &SyntaxError{Pos: d.Pos, Msg: fmt.Sprintf("imports nested more than %d deep, is there an import cycle?", maxImportDepth)}
+	}
+	if len(d.Args) == 0 {
+		return "", nil, &SyntaxError{Pos: d.Pos, Msg: "import needs a snippet name or file path"}
+	}
+
+	args := make([]string, 0, len(d.Args)-1)
+	for _, tok := range d.Args[1:] {
+		args = append(args, tok.Text)
+	}
+	return d.Args[0].Text, args, nil
+}
+
+// resolve returns the files an import pattern names, relative to dir. A
+// glob may match nothing; a plain path must exist.
+func (e *expander) resolve(pattern, dir string, d *Directive) ([]string, error) {
+	name := pattern
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+
+	if !strings.ContainsAny(pattern, "*?[") {
+		if _, err := e.fsys.ReadFile(name); err != nil {
+			return nil, &SyntaxError{Pos: d.Pos, Msg: fmt.Sprintf("import %s: %v", pattern, err)}
+		}
+		return []string{name}, nil
+	}
+
+	matches, err := e.fsys.Glob(name)
+	if err != nil {
+		return nil, &SyntaxError{Pos: d.Pos, Msg: fmt.Sprintf("import %s: %v", pattern, err)}
+	}
+	return matches, nil
+}
+
+// substitute returns a copy of d with snippet arguments filled in, or nil
+// if the directive's name expanded to nothing. Nested blocks are handled
+// by the caller as it walks into them.
+func substitute(d *Directive, args []string) *Directive {
+	copied := *d
+	if args == nil {
+		return &copied
+	}
+
+	tokens := substituteTokens(append([]Token{d.Name}, d.Args...), args)
+	if len(tokens) == 0 {
+		return nil
+	}
+	copied.Name, copied.Args = tokens[0], tokens[1:]
+	return &copied
+}
+
+// substituteTokens replaces {args[...]} placeholders. A token that is only
+// a range such as {args[1:]} becomes one token per argument.
+func substituteTokens(tokens []Token, args []string) []Token {
+	if args == nil {
+		return tokens
+	}
+
+	var out []Token
+	for _, tok := range tokens {
+		if m := argsRe.FindStringSubmatch(tok.Text); m != nil && m[0] == tok.Text && m[2] == ":" {
+			for _, arg := range argRange(args, m[1], m[3]) {
+				expanded := tok
+				expanded.Text = arg
+				out = append(out, expanded)
+			}
+			continue
+		}
+
+		tok.Text = argsRe.ReplaceAllStringFunc(tok.Text, func(placeholder string) string {
+			m := argsRe.FindStringSubmatch(placeholder)
+			if m[2] == ":" {
+				return strings.Join(argRange(args, m[1], m[3]), " ")
+			}
+			i := argIndex(m[1]+m[4], -1)
+			if i < 0 || i >= len(args) {
+				return ""
+			}
+			return args[i]
+		})
+		out = append(out, tok)
+	}
+	return out
+}
+
+// argRange returns the arguments selected by a [from:to] placeholder
+func argRange(args []string, from, to string) []string {
+	i := min(argIndex(from, 0), len(args))
+	j := min(argIndex(to, len(args)), len(args))
+	if i >= j {
+		return nil
+	}
+	return args[i:j]
+}
+
+// argIndex parses an index from a placeholder, or returns def if empty
+func argIndex(s string, def int) int {
+	if s == "" {
+		return def
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return def
+	}
+	return i
+}
@@ -0,0 +1,129 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoadExpandsImportsAndSnippets(t *testing.T) {
+	fsys := MapFS{
+		"/etc/caddy/Caddyfile": `(proxy) {
+	reverse_proxy {args[0]} {
+		header_up Host {args[1]}
+	}
+}
+
+(tunnel) {
+	# {args[0]}: {args[1]}
+	{args[2]} {
+		import proxy 127.0.0.1:{args[3]} {host}
+	}
+}
+
+import snippets/*.caddy
+import sites/*.caddy
+import tunnel nas 192.168.1.20:5000 nas.example.com 8004
+`,
+		"/etc/caddy/snippets/common.caddy": `(logging) {
+	log
+}
+`,
+		"/etc/caddy/sites/a.caddy": `# plex: 192.168.1.100:32400
+plex.example.com {
+	import logging
+	import proxy localhost:8001 plex
+}
+`,
+		"/etc/caddy/sites/b.caddy": `# ha: 192.168.1.100:8123
+ha.example.com {
+	import ../handlers/ha
+}
+`,
+		"/etc/caddy/handlers/ha": `handle {
+	reverse_proxy 127.0.0.1:8002
+}
+`,
+	}
+
+	c, err := Load("/etc/caddy/Caddyfile", fsys)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	var names []string
+	for _, f := range c.Imports() {
+		names = append(names, f.Name)
+	}
+	wantFiles := []string{
+		"/etc/caddy/snippets/common.caddy",
+		"/etc/caddy/sites/a.caddy",
+		"/etc/caddy/sites/b.caddy",
+		"/etc/caddy/handlers/ha",
+	}
+	if !reflect.DeepEqual(names, wantFiles) {
+		t.Errorf("Imports() = %v, want %v", names, wantFiles)
+	}
+
+	services := c.Services()
+	if len(services) != 2 {
+		t.Fatalf("Expected 2 services, got %+v", services)
+	}
+	if services[0].Name != "plex" || services[0].VPSPort != 8001 || services[0].Pos.File != "/etc/caddy/sites/a.caddy" {
+		t.Errorf("Unexpected plex service %+v", services[0])
+	}
+	if services[1].Name != "ha" || services[1].VPSPort != 8002 || services[1].ProxyPos.File != "/etc/caddy/handlers/ha" {
+		t.Errorf("Unexpected ha service %+v", services[1])
+	}
+
+	// The snippet imported at the top level becomes a site, but its
+	// comment stays in the snippet, so it isn't a service
+	sites := c.Sites()
+	if len(sites) != 3 || sites[2].Addresses()[0] != "nas.example.com" {
+		t.Fatalf("Expected the tunnel snippet to add a site, got %d sites", len(sites))
+	}
+	port, _, _ := LocalUpstream(sites[2])
+	if port.Text != "127.0.0.1:8004" {
+		t.Errorf("Expected snippet arguments in nested imports, got %q", port.Text)
+	}
+
+	// Lint says why the snippet's site gets no tunnel
+	var snippetSites []Diagnostic
+	for _, d := range c.Lint() {
+		if d.Rule == RuleSnippetSite {
+			snippetSites = append(snippetSites, d)
+		}
+	}
+	if len(snippetSites) != 1 || snippetSites[0].Pos != sites[2].Pos {
+		t.Errorf("Expected a snippet-site warning for %s, got %v", sites[2].Addresses()[0], snippetSites)
+	}
+}
+
+func TestLoadImportErrors(t *testing.T) {
+	if _, err := ParseContent("import missing.caddy\n"); err == nil {
+		t.Error("Expected an error for a missing import")
+	}
+
+	loop := MapFS{"Caddyfile": "(a) {\n\timport a\n}\nexample.com {\n\timport a\n}\n"}
+	if _, err := Load("Caddyfile", loop); err == nil {
+		t.Error("Expected an error for a snippet importing itself")
+	}
+
+	// A glob that matches nothing is fine, as in Caddy
+	if _, err := ParseContent("import sites/*.caddy\n"); err != nil {
+		t.Errorf("Unexpected error for an empty glob: %v", err)
+	}
+}
+
+func TestSubstituteTokens(t *testing.T) {
+	tokens := []Token{{Text: "{args[0]}"}, {Text: "x-{args.1}-{args[5]}"}, {Text: "{args[1:]}"}}
+	got := substituteTokens(tokens, []string{"a", "b", "c"})
+
+	var texts []string
+	for _, tok := range got {
+		texts = append(texts, tok.Text)
+	}
+	want := []string{"a", "x-b-", "b", "c"}
+	if !reflect.DeepEqual(texts, want) {
+		t.Errorf("substituteTokens() = %q, want %q", texts, want)
+	}
+}
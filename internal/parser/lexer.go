@@ -0,0 +1,289 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pos is a location in a Caddyfile
+type Pos struct {
+	File   string
+	Offset int // Byte offset from the start of the file
+	Line   int // 1-based
+	Column int // 1-based, in bytes
+}
+
+func (p Pos) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// TokenKind classifies a token
+type TokenKind int
+
+const (
+	TokenWord       TokenKind = iota // Unquoted text
+	TokenQuoted                      // "double quoted"
+	TokenBacktick                    // `backtick quoted`
+	TokenHeredoc                     // <<MARKER ... MARKER
+	TokenOpenBrace                   // A lone {
+	TokenCloseBrace                  // A lone }
+)
+
+// Token is a single Caddyfile token
+type Token struct {
+	Kind    TokenKind
+	Text    string // Value with quotes removed and escapes resolved
+	Pos     Pos    // First byte of the token
+	End     Pos    // Just past the last byte of the token
+	NewLine bool   // First token of a logical line
+}
+
+// Comment is a # comment, which runs to the end of the line
+type Comment struct {
+	Text string // Including the leading #
+	Pos  Pos
+	End  Pos
+}
+
+// SyntaxError reports malformed Caddyfile input
+type SyntaxError struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// lexer splits Caddyfile source into tokens and comments following Caddy's
+// rules: tokens are separated by whitespace, quotes and backticks may span
+// lines, # starts a comment only at the start of a token, and a backslash
+// before a newline continues the logical line.
+type lexer struct {
+	src      string
+	pos      Pos
+	tokens   []Token
+	comments []Comment
+	newLine  bool // No token yet on the current logical line
+}
+
+// lex tokenizes a whole Caddyfile
+func lex(file, src string) ([]Token, []Comment, error) {
+	l := &lexer{
+		src:     src,
+		pos:     Pos{File: file, Line: 1, Column: 1},
+		newLine: true,
+	}
+	if err := l.run(); err != nil {
+		return nil, nil, err
+	}
+	return l.tokens, l.comments, nil
+}
+
+func (l *lexer) eof() bool {
+	return l.pos.Offset >= len(l.src)
+}
+
+func (l *lexer) peek(n int) byte {
+	if l.pos.Offset+n >= len(l.src) {
+		return 0
+	}
+	return l.src[l.pos.Offset+n]
+}
+
+// advance moves past one byte, keeping line and column up to date
+func (l *lexer) advance() byte {
+	ch := l.src[l.pos.Offset]
+	l.pos.Offset++
+	if ch == '\n' {
+		l.pos.Line++
+		l.pos.Column = 1
+	} else {
+		l.pos.Column++
+	}
+	return ch
+}
+
+func (l *lexer) run() error {
+	for !l.eof() {
+		ch := l.peek(0)
+		switch {
+		case ch == '\n':
+			l.advance()
+			l.newLine = true
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			l.advance()
+		case ch == '\\' && (l.peek(1) == '\n' || (l.peek(1) == '\r' && l.peek(2) == '\n')):
+			// Line continuation: the next line carries on this one
+			for l.peek(0) != '\n' {
+				l.advance()
+			}
+			l.advance()
+		case ch == '#':
+			l.lexComment()
+		case ch == '"':
+			if err := l.lexQuoted('"', TokenQuoted); err != nil {
+				return err
+			}
+		case ch == '`':
+			if err := l.lexQuoted('`', TokenBacktick); err != nil {
+				return err
+			}
+		case ch == '<' && l.peek(1) == '<' && isMarkerByte(l.peek(2)):
+			if err := l.lexHeredoc(); err != nil {
+				return err
+			}
+		default:
+			l.lexWord()
+		}
+	}
+	return nil
+}
+
+func (l *lexer) emit(tok Token) {
+	tok.End = l.pos
+	tok.NewLine = l.newLine
+	l.newLine = false
+	l.tokens = append(l.tokens, tok)
+}
+
+func (l *lexer) lexComment() {
+	start := l.pos
+	for !l.eof() && l.peek(0) != '\n' {
+		l.advance()
+	}
+	text := strings.TrimRight(l.src[start.Offset:l.pos.Offset], "\r")
+	l.comments = append(l.comments, Comment{Text: text, Pos: start, End: l.pos})
+}
+
+// lexWord reads unquoted text up to the next whitespace. Quotes and #
+// inside a word are literal.
+func (l *lexer) lexWord() {
+	start := l.pos
+	var b strings.Builder
+	for !l.eof() {
+		ch := l.peek(0)
+		if ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n' {
+			break
+		}
+		if ch == '\\' && (l.peek(1) == '\n' || (l.peek(1) == '\r' && l.peek(2) == '\n')) {
+			break
+		}
+		b.WriteByte(l.advance())
+	}
+
+	kind := TokenWord
+	switch b.String() {
+	case "{":
+		kind = TokenOpenBrace
+	case "}":
+		kind = TokenCloseBrace
+	}
+	l.emit(Token{Kind: kind, Text: b.String(), Pos: start})
+}
+
+// lexQuoted reads a quoted token. In double quotes \" is an escaped quote
+// and any other backslash is kept; backticks have no escapes.
+func (l *lexer) lexQuoted(quote byte, kind TokenKind) error {
+	start := l.pos
+	l.advance()
+
+	var b strings.Builder
+	for {
+		if l.eof() {
+			return &SyntaxError{Pos: start, Msg: "unterminated quoted string"}
+		}
+		ch := l.advance()
+		if ch == quote {
+			break
+		}
+		if quote == '"' && ch == '\\' && l.peek(0) == '"' {
+			ch = l.advance()
+		}
+		b.WriteByte(ch)
+	}
+
+	l.emit(Token{Kind: kind, Text: b.String(), Pos: start})
+	return nil
+}
+
+// lexHeredoc reads <<MARKER, the lines after it, and the closing MARKER,
+// which starts a line and may be followed by more arguments. The closing
+// marker's indentation is removed from every line.
+func (l *lexer) lexHeredoc() error {
+	start := l.pos
+	l.advance()
+	l.advance()
+
+	markerStart := l.pos.Offset
+	for !l.eof() && isMarkerByte(l.peek(0)) {
+		l.advance()
+	}
+	marker := l.src[markerStart:l.pos.Offset]
+
+	if l.peek(0) == '\r' {
+		l.advance()
+	}
+	if l.eof() || l.peek(0) != '\n' {
+		return &SyntaxError{Pos: start, Msg: fmt.Sprintf("heredoc marker %q must be followed by a newline", marker)}
+	}
+	l.advance()
+
+	var lines []string
+	for {
+		if l.eof() {
+			return &SyntaxError{Pos: start, Msg: fmt.Sprintf("heredoc %q is never closed", marker)}
+		}
+		lineStart := l.pos.Offset
+		lineEnd := strings.IndexByte(l.src[lineStart:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(l.src) - lineStart
+		}
+		line := strings.TrimRight(l.src[lineStart:lineStart+lineEnd], "\r")
+
+		// The closing marker may be followed by more arguments
+		body := strings.TrimLeft(line, " \t")
+		if rest, ok := strings.CutPrefix(body, marker); ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+			indent := line[:len(line)-len(body)]
+			text, err := dedent(lines, indent)
+			if err != nil {
+				return &SyntaxError{Pos: start, Msg: err.Error()}
+			}
+			for range len(indent) + len(marker) {
+				l.advance()
+			}
+			l.emit(Token{Kind: TokenHeredoc, Text: text, Pos: start})
+			return nil
+		}
+
+		lines = append(lines, line)
+		for range lineEnd {
+			l.advance()
+		}
+		if !l.eof() {
+			l.advance()
+		}
+	}
+}
+
+// dedent strips indent from each heredoc line
+func dedent(lines []string, indent string) (string, error) {
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, indent) {
+			return "", fmt.Errorf("heredoc line %d is indented less than its closing marker", i+1)
+		}
+		out[i] = line[len(indent):]
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+func isMarkerByte(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '_' || ch == '-'
+}
@@ -0,0 +1,40 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLex(t *testing.T) {
+	src := "respond \"say \\\"hi\\\" \\n\" `{raw}` # trailing { comment\n" +
+		"a#b \\\n  continued\n" +
+		"respond <<EOF\n    one\n\n      two\n    EOF 200\n"
+
+	tokens, comments, err := lex("", src)
+	if err != nil {
+		t.Fatalf("lex failed: %v", err)
+	}
+
+	var texts []string
+	var newLines []bool
+	for _, tok := range tokens {
+		texts = append(texts, tok.Text)
+		newLines = append(newLines, tok.NewLine)
+	}
+
+	wantTexts := []string{"respond", `say "hi" \n`, "{raw}", "a#b", "continued", "respond", "one\n\n  two", "200"}
+	if !reflect.DeepEqual(texts, wantTexts) {
+		t.Errorf("Token texts = %q, want %q", texts, wantTexts)
+	}
+	wantNewLines := []bool{true, false, false, true, false, true, false, false}
+	if !reflect.DeepEqual(newLines, wantNewLines) {
+		t.Errorf("NewLine flags = %v, want %v", newLines, wantNewLines)
+	}
+
+	if len(comments) != 1 || comments[0].Text != "# trailing { comment" {
+		t.Errorf("Unexpected comments %+v", comments)
+	}
+	if p := tokens[4].Pos; p.Line != 3 || p.Column != 3 {
+		t.Errorf("Expected continued token at 3:3, got %s", p)
+	}
+}
@@ -0,0 +1,238 @@
+package parser
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severity says how bad a diagnostic is
+type Severity int
+
+const (
+	SeverityWarning Severity = iota // Probably a mistake, but it deploys
+	SeverityError                   // The service is skipped or deployed wrong
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Lint rule IDs
+const (
+	RuleOrphanComment      = "orphan-comment"      // Service comment not attached to a site
+	RuleUnannotatedSite    = "unannotated-site"    // Site proxying to localhost without a service comment
+	RuleMissingUpstream    = "missing-upstream"    // Service without a reverse_proxy to localhost
+	RuleInvalidLocalAddr   = "invalid-local-addr"  // Local address isn't host:port
+	RuleInvalidVPSPort     = "invalid-vps-port"    // Raw service's "-> port" isn't a port or ip:port
+	RuleConflictingService = "conflicting-service" // Same name, different local address, port or client
+	RuleDuplicatePort      = "duplicate-port"      // Different services on the same VPS port
+	RuleRemoteUpstream     = "remote-upstream"     // Service proxying past the tunnel
+	RuleInvalidOption      = "invalid-option"      // "# rcm:" option rathole doesn't support
+	RuleOrphanOptions      = "orphan-options"      // "# rcm:" comment not above a service comment
+	RuleSnippetSite        = "snippet-site"        // Site from a snippet imported at the top level, which can't be annotated
+)
+
+// Diagnostic is a problem found by Lint
+type Diagnostic struct {
+	Pos      Pos
+	Severity Severity
+	Rule     string
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s [%s]", d.Pos, d.Severity, d.Message, d.Rule)
+}
+
+// Lint looks for mistakes that make a service silently disappear from the
+// tunnels or reach the wrong place. Diagnostics are sorted by position.
+func (c *Caddyfile) Lint() []Diagnostic {
+	l := &linter{seen: make(map[string]Service), ports: make(map[portKey]string)}
+	annotations := make(map[Pos]bool)
+
+	for _, b := range c.Sites() {
+		comment, name, localAddr, annotated := annotation(b)
+		upstream, port, proxied := LocalUpstream(b)
+
+		if b.Snippet != "" {
+			// Its comments stay in the snippet, shared by every import
+			if proxied {
+				l.warn(b.Pos, RuleSnippetSite, "site %s comes from snippet %s, so it can't take a service comment or \"# rcm:\" options and no tunnel is created for %s; write it as its own site block", b.Addresses()[0], b.Snippet, upstream.Text)
+			}
+			continue
+		}
+		if !annotated {
+			if proxied {
+				l.warn(b.Pos, RuleUnannotatedSite, "site %s proxies to %s but has no \"# name: local_addr\" comment, so no tunnel is created", b.Addresses()[0], upstream.Text)
+			}
+			continue
+		}
+		annotations[comment.Pos] = true
+
+		if err := checkLocalAddr(localAddr); err != nil {
+			if !proxied {
+				// Just a comment that looks like "# name: text", on a site
+				// that has nothing to do with the tunnels
+				continue
+			}
+			l.error(comment.Pos, RuleInvalidLocalAddr, "service %s: %v", name, err)
+		}
+		l.remoteUpstreams(b, name)
+		if !proxied {
+			l.error(b.Pos, RuleMissingUpstream, "service %s has no reverse_proxy to a localhost port, so it is skipped", name)
+			continue
+		}
+
+		l.service(Service{Name: name, LocalAddr: localAddr, VPSPort: port, Client: ServiceClient(comment.Text), Pos: comment.Pos, ProxyPos: upstream.Pos})
+	}
+
+	for _, comment := range c.rawComments() {
+		annotations[comment.Pos] = true
+		raw, _ := ParseRawServiceComment(comment.Text)
+		svc, err := raw.Service()
+		if err != nil {
+			rule := RuleInvalidVPSPort
+			if checkLocalAddr(raw.LocalAddr) != nil {
+				rule = RuleInvalidLocalAddr
+			}
+			l.error(comment.Pos, rule, "service %s: %v", raw.Name, err)
+			continue
+		}
+		svc.Pos, svc.ProxyPos = comment.Pos, comment.Pos
+		l.service(svc)
+	}
+
+	for _, f := range c.Files {
+		for _, comment := range f.Comments {
+			if annotations[comment.Pos] {
+				continue
+			}
+			if raw, ok := ParseRawServiceComment(comment.Text); ok {
+				l.warn(comment.Pos, RuleOrphanComment, "raw service comment for %s is inside a block, so it is ignored", raw.Name)
+				continue
+			}
+			name, localAddr, ok := ParseServiceComment(comment.Text)
+			if ok && checkLocalAddr(localAddr) == nil {
+				l.warn(comment.Pos, RuleOrphanComment, "service comment for %s isn't attached to a site block, so it is ignored", name)
+			}
+		}
+	}
+
+	l.diags = append(l.diags, c.optionDiagnostics()...)
+
+	sort.SliceStable(l.diags, func(i, j int) bool {
+		a, b := l.diags[i].Pos, l.diags[j].Pos
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Offset < b.Offset
+	})
+	return l.diags
+}
+
+type linter struct {
+	diags []Diagnostic
+	seen  map[string]Service // First definition of each service
+	ports map[portKey]string // Service using each VPS port
+}
+
+// service checks a service against the ones seen before it
+func (l *linter) service(svc Service) {
+	if prev, ok := l.seen[svc.Name]; ok {
+		if prev.LocalAddr != svc.LocalAddr {
+			l.error(svc.Pos, RuleConflictingService, "service %s is also defined with local address %s at %s", svc.Name, prev.LocalAddr, prev.Pos)
+		}
+		if prev.VPSPort != svc.VPSPort {
+			l.error(svc.ProxyPos, RuleConflictingService, "service %s is also defined with VPS port %d at %s", svc.Name, prev.VPSPort, prev.ProxyPos)
+		}
+		if prev.Client != svc.Client {
+			l.error(svc.Pos, RuleConflictingService, "service %s is also defined on client %q at %s", svc.Name, prev.Client, prev.Pos)
+		}
+		return
+	}
+	l.seen[svc.Name] = svc
+
+	key := portKey{svc.VPSPort, svc.Protocol == ProtocolUDP}
+	if other, ok := l.ports[key]; ok {
+		l.error(svc.ProxyPos, RuleDuplicatePort, "service %s uses VPS port %d, already used by %s", svc.Name, svc.VPSPort, other)
+	} else {
+		l.ports[key] = svc.Name
+	}
+}
+
+// portKey tells TCP and UDP ports apart, since they can share a number
+type portKey struct {
+	port int
+	udp  bool
+}
+
+func (l *linter) warn(pos Pos, rule, format string, args ...any) {
+	l.diags = append(l.diags, Diagnostic{Pos: pos, Severity: SeverityWarning, Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) error(pos Pos, rule, format string, args ...any) {
+	l.diags = append(l.diags, Diagnostic{Pos: pos, Severity: SeverityError, Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+// remoteUpstreams flags reverse_proxy upstreams of a service that don't
+// point at localhost, since that traffic bypasses the tunnel
+func (l *linter) remoteUpstreams(b *ServerBlock, name string) {
+	b.Body.Walk(func(d *Directive) {
+		if d.Name.Text != "reverse_proxy" {
+			return
+		}
+		candidates := append([]Token(nil), d.Args...)
+		if d.Block != nil {
+			for _, sub := range d.Block.Directives {
+				if sub.Name.Text == "to" {
+					candidates = append(candidates, sub.Args...)
+				}
+			}
+		}
+		for _, arg := range candidates {
+			if isUpstream(arg.Text) && !isLoopback(arg.Text) {
+				l.warn(arg.Pos, RuleRemoteUpstream, "service %s proxies to %s, which doesn't go through the tunnel", name, arg.Text)
+			}
+		}
+	})
+}
+
+// checkLocalAddr checks a service's local address is host:port
+func checkLocalAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return fmt.Errorf("local address %q isn't host:port", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("local address %q has an invalid port", addr)
+	}
+	return nil
+}
+
+// isUpstream tells upstream addresses apart from the matchers and
+// placeholders reverse_proxy also takes
+func isUpstream(arg string) bool {
+	return arg != "" && !strings.ContainsAny(arg[:1], "/@*{")
+}
+
+// isLoopback reports whether an upstream points at this machine
+func isLoopback(upstream string) bool {
+	if _, rest, ok := strings.Cut(upstream, "://"); ok {
+		upstream = rest
+	}
+	host := upstream
+	if h, _, err := net.SplitHostPort(upstream); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,81 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	src := `# plex: 192.168.1.100:32400
+plex.example.com {
+	reverse_proxy localhost:8001
+}
+
+# Stray: 192.168.1.5:80
+
+# jellyfin: 192.168.1.100:8096
+jellyfin.example.com {
+	file_server
+}
+
+# ha: homeassistant
+ha.example.com {
+	reverse_proxy 127.0.0.1:8002
+}
+
+# plex: 192.168.1.101:32400
+plex2.example.com {
+	reverse_proxy localhost:8003
+}
+
+# nas: 192.168.1.20:5000
+nas.example.com {
+	reverse_proxy 127.0.0.1:8001 192.168.1.20:5000
+}
+
+static.example.com {
+	reverse_proxy localhost:9000
+}
+
+# Note: not a service
+other.example.com {
+	reverse_proxy 10.0.0.5:80
+}
+
+# wireguard: 192.168.1.5 udp
+udp.example.com {
+	# dns: 192.168.1.7:53 udp
+	respond "not a tunnel"
+}
+`
+	c, err := Load("Caddyfile", MapFS{"Caddyfile": src})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	var got []string
+	for _, d := range c.Lint() {
+		got = append(got, d.String())
+	}
+	want := []string{
+		"Caddyfile:6:1: warning: service comment for Stray isn't attached to a site block, so it is ignored [orphan-comment]",
+		"Caddyfile:9:1: error: service jellyfin has no reverse_proxy to a localhost port, so it is skipped [missing-upstream]",
+		`Caddyfile:13:1: error: service ha: local address "homeassistant" isn't host:port [invalid-local-addr]`,
+		"Caddyfile:18:1: error: service plex is also defined with local address 192.168.1.100:32400 at Caddyfile:1:1 [conflicting-service]",
+		"Caddyfile:20:16: error: service plex is also defined with VPS port 8001 at Caddyfile:3:16 [conflicting-service]",
+		"Caddyfile:25:16: error: service nas uses VPS port 8001, already used by plex [duplicate-port]",
+		"Caddyfile:25:31: warning: service nas proxies to 192.168.1.20:5000, which doesn't go through the tunnel [remote-upstream]",
+		`Caddyfile:28:1: warning: site static.example.com proxies to localhost:9000 but has no "# name: local_addr" comment, so no tunnel is created [unannotated-site]`,
+		`Caddyfile:37:1: error: service wireguard: local address "192.168.1.5" isn't host:port [invalid-local-addr]`,
+		"Caddyfile:39:2: warning: raw service comment for dns is inside a block, so it is ignored [orphan-comment]",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lint() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	clean, _ := Load("Caddyfile", MapFS{"Caddyfile": "# plex: 192.168.1.100:32400\nplex.example.com {\n\treverse_proxy http://[::1]:8001 localhost:8001\n}\n"})
+	if diags := clean.Lint(); len(diags) != 0 {
+		t.Errorf("Expected no diagnostics, got %v", diags)
+	}
+}
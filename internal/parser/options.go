@@ -0,0 +1,214 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Pattern: # rcm: key=value ..., per-service rathole options
+var optionsCommentRe = regexp.MustCompile(`^#\s*rcm:\s*(.*)$`)
+
+// Options are per-service rathole settings, from "# rcm: key=value ..."
+// comments on the lines right above a service comment
+type Options struct {
+	Token         string // Instead of default_token; may be op:// or ${ENV}
+	Nodelay       *bool  // nil leaves rathole's default
+	RetryInterval int    // Seconds between client retries, 0 for rathole's default
+	Type          string // ProtocolTCP or ProtocolUDP, "" to keep the service's
+}
+
+// optionKeys are the service settings rathole supports that rcm doesn't
+// manage itself
+var optionKeys = []string{"token", "nodelay", "retry_interval", "type"}
+
+// IsOptionsComment reports whether a comment holds rcm options
+func IsOptionsComment(text string) bool {
+	return optionsCommentRe.MatchString(strings.TrimSpace(text))
+}
+
+// ParseOptions reads the settings of an "# rcm:" comment into opts. Values
+// may be double quoted to hold spaces.
+func ParseOptions(text string, opts *Options) error {
+	matches := optionsCommentRe.FindStringSubmatch(strings.TrimSpace(text))
+	if matches == nil {
+		return errors.New("not an rcm options comment")
+	}
+	pairs, err := splitOptions(matches[1])
+	if err != nil {
+		return err
+	}
+	if len(pairs) == 0 {
+		return errors.New("no options after rcm:")
+	}
+
+	for _, kv := range pairs {
+		key, value := kv[0], kv[1]
+		switch key {
+		case "token":
+			if value == "" || strings.ContainsAny(value, "\"\\") {
+				return fmt.Errorf("token must be non-empty and can't hold quotes or backslashes")
+			}
+			opts.Token = value
+		case "nodelay":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("nodelay must be true or false, not %q", value)
+			}
+			opts.Nodelay = &b
+		case "retry_interval":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return fmt.Errorf("retry_interval must be a number of seconds, not %q", value)
+			}
+			opts.RetryInterval = n
+		case "type":
+			if value != ProtocolTCP && value != ProtocolUDP {
+				return fmt.Errorf("type must be tcp or udp, not %q", value)
+			}
+			opts.Type = value
+		case "bind_addr", "local_addr":
+			return fmt.Errorf("%s is set by rcm from the service comment", key)
+		default:
+			return fmt.Errorf("unknown option %q, rathole services support %s", key, strings.Join(optionKeys, ", "))
+		}
+	}
+	return nil
+}
+
+// splitOptions splits "a=1 b="two words"" into key and value pairs
+func splitOptions(s string) ([][2]string, error) {
+	var pairs [][2]string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return pairs, nil
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 || strings.ContainsAny(s[:eq], " \t") {
+			return nil, fmt.Errorf("expected key=value at %q", s)
+		}
+		key, rest := s[:eq], s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote in %s", key)
+			}
+			value, s = rest[1:end+1], rest[end+2:]
+		} else {
+			end := strings.IndexAny(rest, " \t")
+			if end < 0 {
+				end = len(rest)
+			}
+			value, s = rest[:end], rest[end:]
+		}
+		pairs = append(pairs, [2]string{key, value})
+	}
+}
+
+// OptionComments returns the "# rcm:" comments on the lines right above a
+// service comment
+func (c *Caddyfile) OptionComments(svc Comment) []Comment {
+	for _, f := range c.Files {
+		if f.Name != svc.Pos.File {
+			continue
+		}
+		i := commentIndex(f.Comments, svc.Pos)
+		if i < 0 {
+			return nil
+		}
+		start := i
+		for start > 0 {
+			prev := f.Comments[start-1]
+			if !IsOptionsComment(prev.Text) || prev.Pos.Line != f.Comments[start].Pos.Line-1 {
+				break
+			}
+			start--
+		}
+		return f.Comments[start:i]
+	}
+	return nil
+}
+
+// options parses the options of a service comment, skipping invalid ones,
+// which CheckOptions and Lint report
+func (c *Caddyfile) options(svc Comment) Options {
+	var opts Options
+	for _, comment := range c.OptionComments(svc) {
+		next := opts
+		if ParseOptions(comment.Text, &next) == nil {
+			opts = next
+		}
+	}
+	return opts
+}
+
+// CheckOptions returns the errors in the "# rcm:" comments of the services,
+// one per line
+func (c *Caddyfile) CheckOptions() error {
+	var errs []error
+	for _, d := range c.optionDiagnostics() {
+		if d.Severity == SeverityError {
+			errs = append(errs, &SyntaxError{Pos: d.Pos, Msg: d.Message})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// optionDiagnostics checks the "# rcm:" comments of every service, and
+// flags the ones that don't belong to any
+func (c *Caddyfile) optionDiagnostics() []Diagnostic {
+	var diags []Diagnostic
+	used := make(map[Pos]bool)
+	checked := make(map[Pos]bool)
+
+	check := func(svc Comment, name string, site bool) {
+		if checked[svc.Pos] {
+			return
+		}
+		checked[svc.Pos] = true
+		var opts Options
+		for _, comment := range c.OptionComments(svc) {
+			used[comment.Pos] = true
+			if err := ParseOptions(comment.Text, &opts); err != nil {
+				diags = append(diags, Diagnostic{Pos: comment.Pos, Severity: SeverityError, Rule: RuleInvalidOption, Message: fmt.Sprintf("service %s: %v", name, err)})
+			}
+		}
+		if site && opts.Type == ProtocolUDP {
+			diags = append(diags, Diagnostic{Pos: svc.Pos, Severity: SeverityError, Rule: RuleInvalidOption, Message: fmt.Sprintf("service %s: type=udp needs a raw service, Caddy only proxies TCP", name)})
+		}
+	}
+
+	for _, b := range c.Sites() {
+		if comment, name, _, ok := annotation(b); ok {
+			check(comment, name, true)
+		}
+	}
+	for _, comment := range c.rawComments() {
+		raw, _ := ParseRawServiceComment(comment.Text)
+		check(comment, raw.Name, false)
+	}
+
+	for _, f := range c.Files {
+		for _, comment := range f.Comments {
+			if IsOptionsComment(comment.Text) && !used[comment.Pos] {
+				diags = append(diags, Diagnostic{Pos: comment.Pos, Severity: SeverityWarning, Rule: RuleOrphanOptions, Message: "rcm options must be on the lines right above a service comment, so they are ignored"})
+			}
+		}
+	}
+	return diags
+}
+
+// commentIndex returns the index of the comment at pos, or -1
+func commentIndex(comments []Comment, pos Pos) int {
+	for i, c := range comments {
+		if c.Pos.Offset == pos.Offset {
+			return i
+		}
+	}
+	return -1
+}
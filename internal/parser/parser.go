@@ -1,133 +1,244 @@
 package parser
 
 import (
-	"bufio"
 	"fmt"
-	"os"
-	"regexp"
-	"strconv"
 	"strings"
 )
 
-var (
-	// Pattern: # service_name: local_addr
-	serviceCommentRe = regexp.MustCompile(`^#\s*(\w[\w-]*\w|\w):\s*(.+)$`)
-
-	// Pattern: domain.com, domain2.com {
-	domainBlockRe = regexp.MustCompile(`^([a-zA-Z0-9.,\s\-_]+)\s*\{`)
-
-	// Pattern: reverse_proxy [http://]localhost|127.0.0.1:PORT
-	reverseProxyRe = regexp.MustCompile(`reverse_proxy\s+(?:https?://)?(?:localhost|127\.0\.0\.1):(\d+)`)
-)
-
-// ParseFile parses a Caddyfile and extracts services
+// ParseFile parses a Caddyfile, with its snippets and imports, and extracts
+// services
 func ParseFile(path string) ([]Service, error) {
-	file, err := os.Open(path)
+	c, err := Load(path, OSFS)
 	if err != nil {
-		return nil, fmt.Errorf("open caddyfile: %w", err)
+		return nil, fmt.Errorf("parse caddyfile: %w", err)
 	}
-	defer file.Close()
-
-	return Parse(bufio.NewScanner(file))
+	return c.Services(), nil
 }
 
-// ParseContent parses Caddyfile content from string
+// ParseContent parses Caddyfile content from string. Snippets are expanded;
+// importing files fails since there are none to read.
 func ParseContent(content string) ([]Service, error) {
-	return Parse(bufio.NewScanner(strings.NewReader(content)))
+	c, err := Load("Caddyfile", MapFS{"Caddyfile": content})
+	if err != nil {
+		return nil, err
+	}
+	return c.Services(), nil
 }
 
-// Parse parses Caddyfile from a scanner
-func Parse(scanner *bufio.Scanner) ([]Service, error) {
-	var services []Service
-	serviceMap := make(map[string]*Service)
-
-	var pendingService *struct {
-		name      string
-		localAddr string
+// Parse parses Caddyfile source into its syntax tree. name is used in
+// positions and error messages.
+func Parse(name, src string) (*File, error) {
+	tokens, comments, err := lex(name, src)
+	if err != nil {
+		return nil, err
 	}
-	var currentDomains []string
-	braceCount := 0
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+	p := &parser{tokens: tokens}
+	f := &File{Name: name, Src: src, Comments: comments}
 
-		// Skip empty lines
-		if line == "" {
-			continue
+	for !p.done() {
+		b, err := p.serverBlock(len(f.Blocks) == 0)
+		if err != nil {
+			return nil, err
 		}
+		f.Blocks = append(f.Blocks, b)
+	}
 
-		// Check for service comment
-		if matches := serviceCommentRe.FindStringSubmatch(line); matches != nil {
-			pendingService = &struct {
-				name      string
-				localAddr string
-			}{
-				name:      matches[1],
-				localAddr: strings.TrimSpace(matches[2]),
+	// Comments between two blocks belong to the block after them
+	prev := 0
+	for _, b := range f.Blocks {
+		for _, c := range comments {
+			switch {
+			case c.Pos.Offset >= prev && c.Pos.Offset < b.Pos.Offset:
+				b.Comments = append(b.Comments, c)
+			case c.Pos.Offset > b.Pos.Offset && c.Pos.Offset < b.End.Offset:
+				b.Inner = append(b.Inner, c)
 			}
-			continue
-		}
-
-		// Check for domain block start
-		if matches := domainBlockRe.FindStringSubmatch(line); matches != nil {
-			domainStr := matches[1]
-			currentDomains = parseDomains(domainStr)
-			braceCount++
-			continue
-		}
-
-		// Track braces
-		braceCount += strings.Count(line, "{") - strings.Count(line, "}")
-
-		// Check for reverse_proxy inside a block
-		if braceCount > 0 && pendingService != nil {
-			if matches := reverseProxyRe.FindStringSubmatch(line); matches != nil {
-				port, _ := strconv.Atoi(matches[1])
-
-				// Check if service already exists (multiple domains)
-				if existing, ok := serviceMap[pendingService.name]; ok {
-					existing.Domains = append(existing.Domains, currentDomains...)
-				} else {
-					svc := &Service{
-						Name:      pendingService.name,
-						LocalAddr: pendingService.localAddr,
-						VPSPort:   port,
-						Domains:   currentDomains,
-					}
-					serviceMap[pendingService.name] = svc
-					services = append(services, *svc)
-				}
+		}
+		prev = b.End.Offset
+	}
+
+	return f, nil
+}
+
+// parseDirectives parses a file imported inside a block, which holds
+// directives rather than server blocks
+func parseDirectives(name, src string) ([]*Directive, []Comment, error) {
+	tokens, comments, err := lex(name, src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	var dirs []*Directive
+	for !p.done() {
+		if tok := p.peek(); tok.Kind == TokenCloseBrace || tok.Kind == TokenOpenBrace {
+			return nil, nil, &SyntaxError{Pos: tok.Pos, Msg: fmt.Sprintf("unexpected '%s'", tok.Text)}
+		}
+		d, err := p.directive()
+		if err != nil {
+			return nil, nil, err
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, comments, nil
+}
+
+// parser builds the syntax tree from tokens
+type parser struct {
+	tokens []Token
+	i      int
+}
+
+func (p *parser) done() bool {
+	return p.i >= len(p.tokens)
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() Token {
+	tok := p.tokens[p.i]
+	p.i++
+	return tok
+}
+
+// serverBlock parses the global options block, a snippet or a site. Only
+// the first block of a file may be global options or a site without braces.
+func (p *parser) serverBlock(first bool) (*ServerBlock, error) {
+	b := &ServerBlock{Pos: p.peek().Pos}
+
+	switch tok := p.peek(); tok.Kind {
+	case TokenOpenBrace:
+		if !first {
+			return nil, &SyntaxError{Pos: tok.Pos, Msg: "unexpected '{' without site addresses"}
+		}
+		return b, p.braces(&b.Body, &b.End)
+	case TokenCloseBrace:
+		return nil, &SyntaxError{Pos: tok.Pos, Msg: "unexpected '}'"}
+	case TokenWord:
+		if tok.Text == "import" {
+			d, err := p.directive()
+			if err != nil {
+				return nil, err
 			}
+			b.Import, b.End = d, d.End
+			return b, nil
 		}
+	}
 
-		// Reset when block closes
-		if braceCount == 0 {
-			pendingService = nil
-			currentDomains = nil
+	// Addresses run to the end of the line, or on to the next line after
+	// a trailing comma
+	continued := true
+	for !p.done() {
+		tok := p.peek()
+		if tok.Kind == TokenOpenBrace {
+			return b, p.braces(&b.Body, &b.End)
+		}
+		if tok.Kind == TokenCloseBrace {
+			return nil, &SyntaxError{Pos: tok.Pos, Msg: "unexpected '}'"}
+		}
+		if tok.NewLine && !continued {
+			break
 		}
+		p.next()
+		b.Keys = append(b.Keys, splitKeys(tok)...)
+		b.End = tok.End
+		continued = strings.HasSuffix(tok.Text, ",")
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("scan error: %w", err)
+	// A lone site may leave out the braces; the rest of the file is its body
+	if !first {
+		return nil, &SyntaxError{Pos: b.Pos, Msg: fmt.Sprintf("site block %s needs braces when the file has more than one", strings.Join(b.Addresses(), ", "))}
+	}
+	for !p.done() {
+		if tok := p.peek(); tok.Kind == TokenCloseBrace || tok.Kind == TokenOpenBrace {
+			return nil, &SyntaxError{Pos: tok.Pos, Msg: fmt.Sprintf("unexpected '%s'", tok.Text)}
+		}
+		d, err := p.directive()
+		if err != nil {
+			return nil, err
+		}
+		b.Body.Directives = append(b.Body.Directives, d)
+		b.End = d.End
 	}
+	return b, nil
+}
+
+// braces parses "{ directives }" into body and records where it ends
+func (p *parser) braces(body *Block, end *Pos) error {
+	open := p.next()
+	body.Open = &open
 
-	// Update services slice with any domain additions from serviceMap
-	for i := range services {
-		if updated, ok := serviceMap[services[i].Name]; ok {
-			services[i] = *updated
+	for {
+		if p.done() {
+			return &SyntaxError{Pos: open.Pos, Msg: "unclosed '{'"}
 		}
+		switch tok := p.peek(); tok.Kind {
+		case TokenCloseBrace:
+			p.next()
+			body.Close = &tok
+			*end = tok.End
+			return nil
+		case TokenOpenBrace:
+			return &SyntaxError{Pos: tok.Pos, Msg: "unexpected '{' without a directive"}
+		}
+
+		d, err := p.directive()
+		if err != nil {
+			return err
+		}
+		body.Directives = append(body.Directives, d)
 	}
+}
+
+// directive parses a name and its arguments up to the end of the logical
+// line, a '}' closing the enclosing block, or its own block
+func (p *parser) directive() (*Directive, error) {
+	name := p.next()
+	d := &Directive{Name: name, Pos: name.Pos, End: name.End}
 
-	return services, nil
+	for !p.done() {
+		tok := p.peek()
+		if tok.NewLine || tok.Kind == TokenCloseBrace {
+			break
+		}
+		if tok.Kind == TokenOpenBrace {
+			d.Block = &Block{}
+			if err := p.braces(d.Block, &d.End); err != nil {
+				return nil, err
+			}
+			break
+		}
+		p.next()
+		d.Args = append(d.Args, tok)
+		d.End = tok.End
+	}
+	return d, nil
 }
 
-func parseDomains(s string) []string {
-	var domains []string
-	for _, d := range strings.Split(s, ",") {
-		d = strings.TrimSpace(d)
-		if d != "" {
-			domains = append(domains, d)
+// splitKeys splits an address token on commas, e.g. "a.com,b.com," gives
+// a.com and b.com, each with its own position
+func splitKeys(tok Token) []Token {
+	if tok.Kind != TokenWord {
+		return []Token{tok}
+	}
+
+	var keys []Token
+	offset := 0
+	for _, part := range strings.Split(tok.Text, ",") {
+		if part != "" {
+			key := tok
+			key.Text = part
+			key.Pos.Offset += offset
+			key.Pos.Column += offset
+			key.End = key.Pos
+			key.End.Offset += len(part)
+			key.End.Column += len(part)
+			keys = append(keys, key)
 		}
+		offset += len(part) + 1
 	}
-	return domains
+	return keys
 }
@@ -1,6 +1,9 @@
 package parser
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -69,3 +72,266 @@ func TestPrimaryDomain(t *testing.T) {
 		t.Errorf("Expected empty string, got '%s'", s2.PrimaryDomain())
 	}
 }
+
+func TestParseContentTrickySyntax(t *testing.T) {
+	caddyfile := `{
+	email admin@example.com # { not a block
+}
+
+(headers) {
+	header X-Frame-Options "DENY {really}"
+}
+
+# app: 192.168.1.10:3000
+*.example.com, :443 {
+	import headers
+	respond /health "ok }" 200
+	handle /api/* { reverse_proxy localhost:8001 }
+}
+
+# web: 192.168.1.11:80
+http://web.example.com {
+	header Content-Security-Policy ` + "`default-src 'self' {x}`" + `
+	respond <<HTML
+		<p>{not a block}</p>
+		HTML 200
+	reverse_proxy \
+		127.0.0.1:8002
+}
+
+# env: 192.168.1.12:80
+{$ENV_DOMAIN:env.example.com} {
+	reverse_proxy {
+		to http://localhost:8003
+	}
+}
+`
+
+	services, err := ParseContent(caddyfile)
+	if err != nil {
+		t.Fatalf("ParseContent failed: %v", err)
+	}
+
+	want := []struct {
+		name    string
+		port    int
+		domains []string
+	}{
+		{"app", 8001, []string{"*.example.com", ":443"}},
+		{"web", 8002, []string{"http://web.example.com"}},
+		{"env", 8003, []string{"{$ENV_DOMAIN:env.example.com}"}},
+	}
+	if len(services) != len(want) {
+		t.Fatalf("Expected %d services, got %+v", len(want), services)
+	}
+	for i, w := range want {
+		svc := services[i]
+		if svc.Name != w.name || svc.VPSPort != w.port || !reflect.DeepEqual(svc.Domains, w.domains) {
+			t.Errorf("Service %d: got %s %d %v, want %s %d %v", i, svc.Name, svc.VPSPort, svc.Domains, w.name, w.port, w.domains)
+		}
+	}
+
+	// Positions point at the comment and the upstream
+	if p := services[0].Pos; p.Line != 9 || p.Column != 1 {
+		t.Errorf("Expected app comment at 9:1, got %s", p)
+	}
+	if p := services[0].ProxyPos; p.Line != 13 || p.Column != 32 {
+		t.Errorf("Expected app upstream at 13:32, got %s", p)
+	}
+	if p := services[1].ProxyPos; p.Line != 23 || p.Column != 3 {
+		t.Errorf("Expected continued upstream at 23:3, got %s", p)
+	}
+}
+
+func TestParseRawServices(t *testing.T) {
+	content := `# wireguard: 192.168.1.5:51820 udp
+# mqtt: 192.168.1.6:1883 tcp
+# ssh: 192.168.1.5:22 tcp -> 2222
+# postgres: 192.168.1.6:5432 tcp -> 10.8.0.1:5432
+
+# plex: 192.168.1.100:32400
+plex.example.com {
+	# ignored: 192.168.1.7:53 udp
+	reverse_proxy localhost:8001
+}
+
+# Unannotated site right below a raw service comment
+# dns: 192.168.1.7:53 udp
+static.example.com {
+	reverse_proxy localhost:8002
+}
+`
+	services, err := ParseContent(content)
+	if err != nil {
+		t.Fatalf("ParseContent failed: %v", err)
+	}
+
+	var got []string
+	for _, svc := range services {
+		got = append(got, fmt.Sprintf("%s %s %s %s %v", svc.Name, svc.LocalAddr, svc.VPSAddr(), svc.Protocol, svc.Raw))
+	}
+	want := []string{
+		"plex 192.168.1.100:32400 0.0.0.0:8001 tcp false",
+		"wireguard 192.168.1.5:51820 0.0.0.0:51820 udp true",
+		"mqtt 192.168.1.6:1883 0.0.0.0:1883 tcp true",
+		"ssh 192.168.1.5:22 0.0.0.0:2222 tcp true",
+		"postgres 192.168.1.6:5432 10.8.0.1:5432 tcp true",
+		"dns 192.168.1.7:53 0.0.0.0:53 udp true",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Services =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseServiceClients(t *testing.T) {
+	content := `# plex@nas: 192.168.1.20:32400
+plex.example.com {
+	reverse_proxy localhost:8001
+}
+
+# ha: 192.168.1.10:8123
+ha.example.com {
+	reverse_proxy localhost:8002
+}
+
+# pihole@pi: 192.168.1.30:53 udp
+`
+	services, err := ParseContent(content)
+	if err != nil {
+		t.Fatalf("ParseContent failed: %v", err)
+	}
+
+	var got []string
+	for _, svc := range services {
+		got = append(got, fmt.Sprintf("%s %s %q", svc.Name, svc.LocalAddr, svc.Client))
+	}
+	want := []string{
+		`plex 192.168.1.20:32400 "nas"`,
+		`ha 192.168.1.10:8123 ""`,
+		`pihole 192.168.1.30:53 "pi"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Services =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if name, addr, ok := ParseServiceComment("# plex@nas: 192.168.1.20:32400"); !ok || name != "plex" || addr != "192.168.1.20:32400" {
+		t.Errorf("ParseServiceComment() = %q, %q, %v", name, addr, ok)
+	}
+	if client := ServiceClient("# plex@: 192.168.1.20:32400"); client != "" {
+		t.Errorf("Expected no client for an empty name, got %q", client)
+	}
+}
+
+func TestParseServiceOptions(t *testing.T) {
+	content := `# rcm: nodelay=false
+# rcm: token="op://Home Lab/rathole/ssh" retry_interval=5
+# ssh: 192.168.1.5:22 tcp -> 2222
+
+# rcm: type=udp
+# dns: 192.168.1.7:53 tcp
+
+# rcm: nodelay=true
+
+# plex: 192.168.1.100:32400
+plex.example.com {
+	reverse_proxy localhost:8001
+}
+
+# rcm: retry=5
+# rcm: type=udp
+# web: 192.168.1.10:80
+web.example.com {
+	reverse_proxy localhost:8002
+}
+`
+	c, err := Load("Caddyfile", MapFS{"Caddyfile": content})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	var got []string
+	for _, svc := range c.Services() {
+		nodelay := "-"
+		if svc.Options.Nodelay != nil {
+			nodelay = fmt.Sprint(*svc.Options.Nodelay)
+		}
+		got = append(got, fmt.Sprintf("%s %s token=%q nodelay=%s retry=%d", svc.Name, svc.Protocol, svc.Options.Token, nodelay, svc.Options.RetryInterval))
+	}
+	want := []string{
+		`plex tcp token="" nodelay=- retry=0`,
+		`web udp token="" nodelay=- retry=0`,
+		`ssh tcp token="op://Home Lab/rathole/ssh" nodelay=false retry=5`,
+		`dns udp token="" nodelay=- retry=0`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Services =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	err = c.CheckOptions()
+	if err == nil {
+		t.Fatal("CheckOptions succeeded, want errors for web")
+	}
+	wantErr := `Caddyfile:15:1: service web: unknown option "retry", rathole services support token, nodelay, retry_interval, type
+Caddyfile:17:1: service web: type=udp needs a raw service, Caddy only proxies TCP`
+	if err.Error() != wantErr {
+		t.Errorf("CheckOptions() =\n%v\nwant\n%s", err, wantErr)
+	}
+
+	var orphans []string
+	for _, d := range c.Lint() {
+		if d.Rule == RuleOrphanOptions {
+			orphans = append(orphans, d.Pos.String())
+		}
+	}
+	if strings.Join(orphans, " ") != "Caddyfile:8:1" {
+		t.Errorf("orphan-options at %v, want Caddyfile:8:1", orphans)
+	}
+}
+
+func TestParseSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"a.com {\n\treverse_proxy localhost:1\n", "1:7: unclosed '{'"},
+		{"a.com {\n}\n}\n", "3:1: unexpected '}'"},
+		{"a.com {\n\trespond \"oops\n}\n", "2:10: unterminated quoted string"},
+		{"a.com {\n\trespond <<EOF\n\thi\n}\n", "2:10: heredoc \"EOF\" is never closed"},
+		{"a.com {\n}\nb.com\nrespond hi\n", "3:1: site block b.com needs braces when the file has more than one"},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse("", tt.src)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestParseAST(t *testing.T) {
+	f, err := Parse("Caddyfile", "# site\nexample.com {\n\tfile_server\n\tencode gzip zstd\n}\n")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(f.Blocks) != 1 {
+		t.Fatalf("Expected 1 block, got %d", len(f.Blocks))
+	}
+
+	b := f.Blocks[0]
+	if len(b.Comments) != 1 || b.Comments[0].Text != "# site" {
+		t.Errorf("Expected the comment above the block, got %+v", b.Comments)
+	}
+	if got := b.Addresses(); !reflect.DeepEqual(got, []string{"example.com"}) {
+		t.Errorf("Unexpected addresses %v", got)
+	}
+	if n := len(b.Body.Directives); n != 2 {
+		t.Fatalf("Expected 2 directives, got %d", n)
+	}
+	encode := b.Body.Directives[1]
+	if encode.Name.Text != "encode" || len(encode.Args) != 2 || encode.Args[1].Text != "zstd" {
+		t.Errorf("Unexpected directive %+v", encode)
+	}
+	if got := f.Src[b.Pos.Offset:b.End.Offset]; got != "example.com {\n\tfile_server\n\tencode gzip zstd\n}" {
+		t.Errorf("Block positions cover %q", got)
+	}
+}
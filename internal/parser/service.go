@@ -1,11 +1,284 @@
 package parser
 
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Pattern: # service_name[@client]: local_addr
+	serviceCommentRe = regexp.MustCompile(`^#\s*(\w[\w-]*\w|\w)(?:@(\w[\w-]*\w|\w))?:\s*(.+)$`)
+
+	// Pattern: [http://]localhost|127.0.0.1:PORT, a reverse_proxy upstream
+	localUpstreamRe = regexp.MustCompile(`^(?:(?:https?|h2c)://)?(?:localhost|127\.0\.0\.1|\[::1\]):(\d+)$`)
+
+	// Pattern: local_addr tcp|udp [-> [bind_addr:]vps_port], the rest of a
+	// raw service comment
+	rawServiceRe = regexp.MustCompile(`^(\S+)\s+(tcp|udp)(?:\s*->\s*(\S+))?$`)
+)
+
+// Tunnel protocols
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
+
 // Service represents a parsed service from Caddyfile
 type Service struct {
 	Name      string   // Service name from comment
 	LocalAddr string   // Local address (e.g., 192.168.1.100:8080)
 	VPSPort   int      // Port on VPS (from reverse_proxy)
 	Domains   []string // Domain names
+	Protocol  string   // ProtocolTCP or ProtocolUDP
+	Raw       bool     // Exposed on the VPS port directly, without a Caddy site
+	BindAddr  string   // VPS address the port is bound on, "" for all of them
+	Options   Options  // From "# rcm:" comments above the service comment
+	Client    string   // Client it runs on, from "# name@client:", "" for the first one
+	Pos       Pos      // The "# name: addr" comment
+	ProxyPos  Pos      // The reverse_proxy upstream carrying VPSPort
+}
+
+// Services extracts the services of the Caddyfile and everything it
+// imports. A service is a site block annotated with a "# name: local_addr"
+// comment, above the block or inside it, that proxies to a port on
+// localhost. Blocks annotated with the same name add their addresses to
+// one service.
+//
+// Raw services follow: a "# name: local_addr tcp|udp" comment outside any
+// block tunnels local_addr to the same port on the VPS, or the one given
+// after "->", without a site.
+func (c *Caddyfile) Services() []Service {
+	var services []Service
+	index := make(map[string]int)
+
+	for _, b := range c.Sites() {
+		comment, name, localAddr, ok := annotation(b)
+		if !ok {
+			continue
+		}
+		upstream, port, ok := LocalUpstream(b)
+		if !ok {
+			continue
+		}
+
+		if i, ok := index[name]; ok {
+			services[i].Domains = append(services[i].Domains, b.Addresses()...)
+			continue
+		}
+		index[name] = len(services)
+		services = append(services, c.withOptions(Service{
+			Name:      name,
+			LocalAddr: localAddr,
+			VPSPort:   port,
+			Domains:   b.Addresses(),
+			Protocol:  ProtocolTCP,
+			Client:    ServiceClient(comment.Text),
+			Pos:       comment.Pos,
+			ProxyPos:  upstream.Pos,
+		}, comment))
+	}
+
+	for _, comment := range c.rawComments() {
+		raw, _ := ParseRawServiceComment(comment.Text)
+		svc, err := raw.Service()
+		if _, dup := index[raw.Name]; dup || err != nil {
+			continue
+		}
+		svc.Pos, svc.ProxyPos = comment.Pos, comment.Pos
+		index[raw.Name] = len(services)
+		services = append(services, c.withOptions(svc, comment))
+	}
+
+	return services
+}
+
+// withOptions adds the "# rcm:" options above a service comment to svc
+func (c *Caddyfile) withOptions(svc Service, comment Comment) Service {
+	svc.Options = c.options(comment)
+	if svc.Options.Type != "" {
+		svc.Protocol = svc.Options.Type
+	}
+	return svc
+}
+
+// rawComments returns the raw service comments that stand outside any
+// block, in the Caddyfile and the files it imports at the top level
+func (c *Caddyfile) rawComments() []Comment {
+	var comments []Comment
+	for _, f := range c.Files {
+		if f.Nested {
+			continue
+		}
+		for _, comment := range f.Comments {
+			if _, ok := ParseRawServiceComment(comment.Text); ok && !f.inBlock(comment.Pos) {
+				comments = append(comments, comment)
+			}
+		}
+	}
+	return comments
+}
+
+// addrPort returns the port of a host:port address
+func addrPort(addr string) (int, bool) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return 0, false
+	}
+	return n, true
+}
+
+// annotation finds the service comment of a site block: the last one above
+// it, or else the first one inside it. Raw service comments don't count.
+func annotation(b *ServerBlock) (Comment, string, string, bool) {
+	for i := len(b.Comments) - 1; i >= 0; i-- {
+		if name, addr, ok := siteComment(b.Comments[i].Text); ok {
+			return b.Comments[i], name, addr, true
+		}
+	}
+	for _, c := range b.Inner {
+		if name, addr, ok := siteComment(c.Text); ok {
+			return c, name, addr, true
+		}
+	}
+	return Comment{}, "", "", false
+}
+
+// siteComment parses a service comment that can annotate a site block
+func siteComment(text string) (name, localAddr string, ok bool) {
+	if _, raw := ParseRawServiceComment(text); raw {
+		return "", "", false
+	}
+	return ParseServiceComment(text)
+}
+
+// ServiceName returns the name in the block's service comment, or ""
+func (b *ServerBlock) ServiceName() string {
+	_, name, _, _ := annotation(b)
+	return name
+}
+
+// ParseServiceComment splits a "# name: local_addr" comment. The client
+// of "# name@client: local_addr" is left out, see ServiceClient.
+func ParseServiceComment(text string) (name, localAddr string, ok bool) {
+	matches := serviceCommentRe.FindStringSubmatch(strings.TrimSpace(text))
+	if matches == nil || matches[1] == "rcm" {
+		// "# rcm:" holds options, not a service
+		return "", "", false
+	}
+	return matches[1], strings.TrimSpace(matches[3]), true
+}
+
+// ServiceClient returns the client named by a "# name@client:" service
+// comment, or ""
+func ServiceClient(text string) string {
+	if _, _, ok := ParseServiceComment(text); !ok {
+		return ""
+	}
+	return serviceCommentRe.FindStringSubmatch(strings.TrimSpace(text))[2]
+}
+
+// RawComment is a "# name: local_addr tcp|udp [-> [bind_addr:]vps_port]"
+// comment, which declares a service without a Caddy site
+type RawComment struct {
+	Name      string
+	Client    string // From "# name@client:", "" for the first client
+	LocalAddr string
+	Protocol  string
+	Target    string // What follows "->", empty for the port of LocalAddr
+}
+
+// ParseRawServiceComment splits a raw service comment
+func ParseRawServiceComment(text string) (RawComment, bool) {
+	name, rest, ok := ParseServiceComment(text)
+	if !ok {
+		return RawComment{}, false
+	}
+	matches := rawServiceRe.FindStringSubmatch(rest)
+	if matches == nil {
+		return RawComment{}, false
+	}
+	return RawComment{Name: name, Client: ServiceClient(text), LocalAddr: matches[1], Protocol: matches[2], Target: matches[3]}, true
+}
+
+// Service resolves the VPS port and bind address of a raw service
+func (r RawComment) Service() (Service, error) {
+	port, ok := addrPort(r.LocalAddr)
+	if !ok {
+		return Service{}, fmt.Errorf("local address %q isn't host:port", r.LocalAddr)
+	}
+	svc := Service{Name: r.Name, LocalAddr: r.LocalAddr, VPSPort: port, Protocol: r.Protocol, Raw: true, Client: r.Client}
+
+	if r.Target != "" {
+		bind, vpsPort, err := ParseBindTarget(r.Target)
+		if err != nil {
+			return Service{}, err
+		}
+		svc.BindAddr, svc.VPSPort = bind, vpsPort
+	}
+	return svc, nil
+}
+
+// ParseBindTarget splits where a raw service listens on the VPS: a port,
+// or an address and a port such as 10.8.0.1:2222
+func ParseBindTarget(target string) (string, int, error) {
+	if n, err := strconv.Atoi(target); err == nil && n >= 1 && n <= 65535 {
+		return "", n, nil
+	}
+	port, ok := addrPort(target)
+	host, _, _ := net.SplitHostPort(target)
+	if !ok || net.ParseIP(host) == nil {
+		return "", 0, fmt.Errorf("VPS port %q isn't a port or ip:port", target)
+	}
+	return host, port, nil
+}
+
+// LocalUpstream returns the first reverse_proxy upstream in the block that
+// points at a port on localhost, looking into nested blocks such as handle
+// and the upstream's "to" subdirective
+func LocalUpstream(b *ServerBlock) (Token, int, bool) {
+	var found *Token
+	port := 0
+	b.Body.Walk(func(d *Directive) {
+		if found != nil || d.Name.Text != "reverse_proxy" {
+			return
+		}
+		candidates := append([]Token(nil), d.Args...)
+		if d.Block != nil {
+			for _, sub := range d.Block.Directives {
+				if sub.Name.Text == "to" {
+					candidates = append(candidates, sub.Args...)
+				}
+			}
+		}
+		for _, arg := range candidates {
+			if matches := localUpstreamRe.FindStringSubmatch(arg.Text); matches != nil {
+				found = &arg
+				port, _ = strconv.Atoi(matches[1])
+				return
+			}
+		}
+	})
+
+	if found == nil {
+		return Token{}, 0, false
+	}
+	return *found, port, true
+}
+
+// VPSAddr returns the address rathole listens on for the service on the VPS
+func (s *Service) VPSAddr() string {
+	host := s.BindAddr
+	if host == "" {
+		host = "0.0.0.0"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(s.VPSPort))
 }
 
 // PrimaryDomain returns the first domain or empty string
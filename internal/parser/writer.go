@@ -0,0 +1,293 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Editor changes a parsed Caddyfile by replacing byte ranges of its source,
+// so the formatting, comments and ordering of everything it doesn't touch
+// are kept byte for byte. Positions always refer to the file as parsed;
+// edits are applied together by Source.
+type Editor struct {
+	f     *File
+	edits []edit
+	err   error
+}
+
+// edit replaces src[start:end] with text. Insertions have start == end.
+type edit struct {
+	start, end int
+	text       string
+}
+
+// NewEditor starts editing f
+func NewEditor(f *File) *Editor {
+	return &Editor{f: f}
+}
+
+// Source returns the edited Caddyfile. It fails if two edits overlap, an
+// edit refers to another file, or the result no longer parses.
+func (e *Editor) Source() (string, error) {
+	if e.err != nil {
+		return "", e.err
+	}
+
+	edits := append([]edit(nil), e.edits...)
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+
+	var b strings.Builder
+	prev := 0
+	for _, ed := range edits {
+		if ed.start < prev {
+			return "", fmt.Errorf("edit %s: overlapping edits", e.pos(ed.start))
+		}
+		b.WriteString(e.f.Src[prev:ed.start])
+		b.WriteString(ed.text)
+		prev = ed.end
+	}
+	b.WriteString(e.f.Src[prev:])
+
+	out := b.String()
+	if _, err := Parse(e.f.Name, out); err != nil {
+		return "", fmt.Errorf("edited Caddyfile doesn't parse: %w", err)
+	}
+	return out, nil
+}
+
+// AppendSite adds a site block, given as Caddyfile text, at the end of the
+// file after a blank line
+func (e *Editor) AppendSite(site string) {
+	src := e.f.Src
+	prefix := ""
+	switch {
+	case src == "":
+	case strings.HasSuffix(src, "\n\n"):
+	case strings.HasSuffix(src, "\n"):
+		prefix = "\n"
+	default:
+		prefix = "\n\n"
+	}
+	e.insert(len(src), prefix+withNewline(site))
+}
+
+// InsertSiteAfter adds a site block, given as Caddyfile text, on the lines
+// after b, separated from it by a blank line
+func (e *Editor) InsertSiteAfter(b *ServerBlock, site string) {
+	if !e.owns(b.Pos) {
+		return
+	}
+	end, ok := e.restOfLine(b.End.Offset)
+	if !ok {
+		e.fail(b.End, "another block follows on the same line")
+		return
+	}
+	if end == len(e.f.Src) && !strings.HasSuffix(e.f.Src, "\n") {
+		e.insert(end, "\n\n"+strings.TrimSuffix(site, "\n"))
+		return
+	}
+	e.insert(end, "\n"+withNewline(site))
+}
+
+// ReplaceSite swaps the text of b, from its first address to its closing
+// brace, for site. Comments above the block are kept.
+func (e *Editor) ReplaceSite(b *ServerBlock, site string) {
+	if e.owns(b.Pos) {
+		e.replace(b.Pos.Offset, b.End.Offset, strings.TrimSuffix(site, "\n"))
+	}
+}
+
+// RemoveSite deletes b along with the comments directly above it, such as
+// its service comment. One of the blank lines around it goes too, so the
+// blocks either side stay one blank line apart.
+func (e *Editor) RemoveSite(b *ServerBlock) {
+	if !e.owns(b.Pos) {
+		return
+	}
+	src := e.f.Src
+
+	start := lineStart(src, b.Pos.Offset)
+	if strings.TrimSpace(src[start:b.Pos.Offset]) != "" {
+		e.fail(b.Pos, "another block ends on the same line")
+		return
+	}
+	for i := len(b.Comments) - 1; i >= 0; i-- {
+		c := b.Comments[i]
+		if c.Pos.Line != lineOf(src, start)-1 || strings.TrimSpace(src[lineStart(src, c.Pos.Offset):c.Pos.Offset]) != "" {
+			break
+		}
+		start = lineStart(src, c.Pos.Offset)
+	}
+
+	end, ok := e.restOfLine(b.End.Offset)
+	if !ok {
+		e.fail(b.End, "another block follows on the same line")
+		return
+	}
+
+	switch {
+	case end < len(src) && isBlankLine(src, end):
+		end, _ = e.restOfLine(end)
+	case start > 0 && isBlankLine(src, lineStart(src, start-1)):
+		start = lineStart(src, start-1)
+	}
+	e.replace(start, end, "")
+}
+
+// SetAddresses replaces the addresses of b
+func (e *Editor) SetAddresses(b *ServerBlock, addrs ...string) {
+	if len(b.Keys) == 0 {
+		e.fail(b.Pos, "block has no addresses")
+		return
+	}
+	if e.owns(b.Pos) {
+		e.replace(b.Keys[0].Pos.Offset, b.Keys[len(b.Keys)-1].End.Offset, strings.Join(addrs, ", "))
+	}
+}
+
+// SetServiceComment writes the "# name: local_addr" comment of b, replacing
+// the one it has or adding one on the line above it
+func (e *Editor) SetServiceComment(b *ServerBlock, name, localAddr string) {
+	if !e.owns(b.Pos) {
+		return
+	}
+	text := fmt.Sprintf("# %s: %s", name, localAddr)
+	if c, _, _, ok := annotation(b); ok {
+		e.replace(c.Pos.Offset, c.End.Offset, text)
+		return
+	}
+
+	start := lineStart(e.f.Src, b.Pos.Offset)
+	indent := e.f.Src[start:b.Pos.Offset]
+	if strings.TrimSpace(indent) != "" {
+		e.fail(b.Pos, "another block ends on the same line")
+		return
+	}
+	e.insert(start, indent+text+"\n")
+}
+
+// RemoveServiceComment deletes the service comment of b, if it has one
+func (e *Editor) RemoveServiceComment(b *ServerBlock) {
+	if c, _, _, ok := annotation(b); ok {
+		e.RemoveComment(c)
+	}
+}
+
+// RemoveComment deletes a comment, with its line if nothing else is on it
+func (e *Editor) RemoveComment(c Comment) {
+	if !e.owns(c.Pos) {
+		return
+	}
+	src := e.f.Src
+	start, end := c.Pos.Offset, c.End.Offset
+	if strings.TrimSpace(src[lineStart(src, start):start]) == "" {
+		// The comment is alone on its line, so the line goes
+		start = lineStart(src, start)
+		end, _ = e.restOfLine(end)
+	}
+	e.replace(start, end, "")
+}
+
+// ReplaceToken swaps a token for text, quoting it if needed
+func (e *Editor) ReplaceToken(tok Token, text string) {
+	if e.owns(tok.Pos) {
+		e.replace(tok.Pos.Offset, tok.End.Offset, Quote(text))
+	}
+}
+
+// Quote returns s as a single Caddyfile token, in double quotes if it is
+// empty or holds whitespace, quotes or a leading #
+func Quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\r\n\"`") && !strings.HasPrefix(s, "#") && !strings.HasPrefix(s, "<<") {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// Indent returns the indentation of the file's first indented directive,
+// or a tab, which is what caddy fmt uses
+func (f *File) Indent() string {
+	for _, b := range f.Sites() {
+		for _, d := range b.Body.Directives {
+			start := lineStart(f.Src, d.Pos.Offset)
+			if indent := f.Src[start:d.Pos.Offset]; indent != "" && strings.TrimLeft(indent, " \t") == "" {
+				return indent
+			}
+		}
+	}
+	return "\t"
+}
+
+func (e *Editor) insert(at int, text string) {
+	e.replace(at, at, text)
+}
+
+func (e *Editor) replace(start, end int, text string) {
+	e.edits = append(e.edits, edit{start: start, end: end, text: text})
+}
+
+// owns reports whether pos is in the file being edited, recording an error
+// if not
+func (e *Editor) owns(pos Pos) bool {
+	if pos.File != e.f.Name {
+		e.fail(pos, fmt.Sprintf("not in %s", e.f.Name))
+		return false
+	}
+	return true
+}
+
+func (e *Editor) fail(pos Pos, msg string) {
+	if e.err == nil {
+		e.err = fmt.Errorf("edit %s: %s", pos, msg)
+	}
+}
+
+func (e *Editor) pos(offset int) Pos {
+	src := e.f.Src
+	return Pos{File: e.f.Name, Offset: offset, Line: lineOf(src, offset), Column: offset - lineStart(src, offset) + 1}
+}
+
+// restOfLine returns the offset just past the newline ending the line at
+// offset, if nothing but whitespace or a comment is left on it
+func (e *Editor) restOfLine(offset int) (int, bool) {
+	src := e.f.Src
+	end := strings.IndexByte(src[offset:], '\n')
+	if end < 0 {
+		end = len(src)
+	} else {
+		end += offset + 1
+	}
+	rest := strings.TrimSpace(src[offset:end])
+	return end, rest == "" || strings.HasPrefix(rest, "#")
+}
+
+// lineStart returns the offset of the start of the line holding offset
+func lineStart(src string, offset int) int {
+	return strings.LastIndexByte(src[:offset], '\n') + 1
+}
+
+// lineOf returns the 1-based line number of offset
+func lineOf(src string, offset int) int {
+	return strings.Count(src[:offset], "\n") + 1
+}
+
+// isBlankLine reports whether the line starting at offset holds only
+// whitespace. The end of the file counts as blank.
+func isBlankLine(src string, offset int) bool {
+	end := strings.IndexByte(src[offset:], '\n')
+	if end < 0 {
+		end = len(src) - offset
+	}
+	return strings.TrimSpace(src[offset:offset+end]) == ""
+}
+
+// withNewline makes sure s ends with a newline
+func withNewline(s string) string {
+	if strings.HasSuffix(s, "\n") {
+		return s
+	}
+	return s + "\n"
+}
@@ -0,0 +1,195 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+const editorSrc = `{
+    email admin@example.com
+}
+
+# --- media ---
+
+# plex: 192.168.1.100:32400
+plex.example.com {
+    reverse_proxy localhost:8001   # keep this
+}
+
+# Home Assistant, see the wiki
+# ha: 192.168.1.100:8123
+ha.example.com, home.example.com {
+    reverse_proxy 127.0.0.1:8002
+}
+
+static.example.com {
+    file_server
+}
+`
+
+func parseEditorSrc(t *testing.T) *File {
+	t.Helper()
+	f, err := Parse("Caddyfile", editorSrc)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	return f
+}
+
+func editedSource(t *testing.T, e *Editor) string {
+	t.Helper()
+	out, err := e.Source()
+	if err != nil {
+		t.Fatalf("Source failed: %v", err)
+	}
+	return out
+}
+
+func TestEditorWithoutEditsKeepsSource(t *testing.T) {
+	f := parseEditorSrc(t)
+	if out := editedSource(t, NewEditor(f)); out != editorSrc {
+		t.Errorf("Expected the source back unchanged, got:\n%s", out)
+	}
+}
+
+func TestEditorRemoveSite(t *testing.T) {
+	f := parseEditorSrc(t)
+	e := NewEditor(f)
+	e.RemoveSite(f.Sites()[1])
+
+	want := strings.Replace(editorSrc, `# Home Assistant, see the wiki
+# ha: 192.168.1.100:8123
+ha.example.com, home.example.com {
+    reverse_proxy 127.0.0.1:8002
+}
+
+`, "", 1)
+	if out := editedSource(t, e); out != want {
+		t.Errorf("Unexpected result:\n%s", out)
+	}
+
+	// The last site takes the blank line before it, and the section comment
+	// separated by a blank line stays
+	f = parseEditorSrc(t)
+	e = NewEditor(f)
+	e.RemoveSite(f.Sites()[2])
+	e.RemoveSite(f.Sites()[0])
+	want = `{
+    email admin@example.com
+}
+
+# --- media ---
+
+# Home Assistant, see the wiki
+# ha: 192.168.1.100:8123
+ha.example.com, home.example.com {
+    reverse_proxy 127.0.0.1:8002
+}
+`
+	if out := editedSource(t, e); out != want {
+		t.Errorf("Unexpected result:\n%s", out)
+	}
+}
+
+func TestEditorChangesSitesAndComments(t *testing.T) {
+	f := parseEditorSrc(t)
+	sites := f.Sites()
+	e := NewEditor(f)
+
+	e.SetServiceComment(sites[0], "plex", "192.168.1.101:32400")
+	upstream, _, _ := LocalUpstream(sites[0])
+	e.ReplaceToken(upstream, "localhost:8005")
+	e.SetAddresses(sites[1], "ha.example.com")
+	e.RemoveServiceComment(sites[1])
+	e.SetServiceComment(sites[2], "static", "192.168.1.5:80")
+	e.InsertSiteAfter(sites[0], "# jf: 192.168.1.100:8096\njf.example.com {\n    reverse_proxy localhost:8003\n}")
+	e.AppendSite("# last: 192.168.1.9:9000\nlast.example.com {\n    reverse_proxy localhost:8009\n}")
+
+	want := `{
+    email admin@example.com
+}
+
+# --- media ---
+
+# plex: 192.168.1.101:32400
+plex.example.com {
+    reverse_proxy localhost:8005   # keep this
+}
+
+# jf: 192.168.1.100:8096
+jf.example.com {
+    reverse_proxy localhost:8003
+}
+
+# Home Assistant, see the wiki
+ha.example.com {
+    reverse_proxy 127.0.0.1:8002
+}
+
+# static: 192.168.1.5:80
+static.example.com {
+    file_server
+}
+
+# last: 192.168.1.9:9000
+last.example.com {
+    reverse_proxy localhost:8009
+}
+`
+	out := editedSource(t, e)
+	if out != want {
+		t.Errorf("Unexpected result:\n%s", out)
+	}
+
+	// static has no local upstream, so it isn't a service
+	services, err := ParseContent(out)
+	if err != nil || len(services) != 3 {
+		t.Errorf("Expected 3 services in the edited file, got %+v (%v)", services, err)
+	}
+}
+
+func TestEditorErrors(t *testing.T) {
+	f := parseEditorSrc(t)
+	other, _ := Parse("other.caddy", "x.example.com {\n}\n")
+
+	e := NewEditor(f)
+	e.RemoveSite(other.Sites()[0])
+	if _, err := e.Source(); err == nil {
+		t.Error("Expected an error for a block from another file")
+	}
+
+	e = NewEditor(f)
+	e.RemoveSite(f.Sites()[0])
+	e.SetServiceComment(f.Sites()[0], "plex", "192.168.1.1:1")
+	if _, err := e.Source(); err == nil {
+		t.Error("Expected an error for overlapping edits")
+	}
+
+	e = NewEditor(f)
+	e.ReplaceSite(f.Sites()[2], "broken.example.com {")
+	if _, err := e.Source(); err == nil {
+		t.Error("Expected an error when the result doesn't parse")
+	}
+}
+
+func TestQuoteAndIndent(t *testing.T) {
+	for in, want := range map[string]string{
+		"localhost:8001": "localhost:8001",
+		"two words":      `"two words"`,
+		`say "hi"`:       `"say \"hi\""`,
+		"":               `""`,
+		"#tag":           `"#tag"`,
+	} {
+		if got := Quote(in); got != want {
+			t.Errorf("Quote(%q) = %s, want %s", in, got, want)
+		}
+	}
+
+	if got := parseEditorSrc(t).Indent(); got != "    " {
+		t.Errorf("Indent() = %q, want four spaces", got)
+	}
+	f, _ := Parse("Caddyfile", "a.example.com\nrespond ok\n")
+	if got := f.Indent(); got != "\t" {
+		t.Errorf("Indent() = %q, want a tab by default", got)
+	}
+}
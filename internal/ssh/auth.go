@@ -0,0 +1,283 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Authentication methods that can be listed in Endpoint.AuthMethods
+const (
+	AuthAgent = "agent" // Keys held by the agent at SSH_AUTH_SOCK
+	AuthKey   = "key"   // The private key file at Endpoint.KeyPath
+)
+
+// DefaultAuthMethods is the order used when an endpoint doesn't set one
+var DefaultAuthMethods = []string{AuthAgent, AuthKey}
+
+// publicKeyAuth collects signers from the endpoint's auth methods, in order.
+// The returned closer releases the agent connection once the handshake is
+// done; it is nil when no agent was used.
+func publicKeyAuth(ep Endpoint) (ssh.AuthMethod, io.Closer, error) {
+	methods := ep.AuthMethods
+	if len(methods) == 0 {
+		methods = DefaultAuthMethods
+	}
+	for _, method := range methods {
+		if method != AuthAgent && method != AuthKey {
+			return nil, nil, fmt.Errorf("unknown auth method %q (use %q or %q)", method, AuthAgent, AuthKey)
+		}
+	}
+
+	// Key files are loaded up front so IdentitiesOnly can filter agent keys
+	fileSigners, err := identitySigners(ep)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var signers []ssh.Signer
+	var agentConn io.Closer
+	seen := make(map[string]bool)
+	add := func(list []ssh.Signer) {
+		for _, signer := range list {
+			// The same key in the agent and on disk is offered only once
+			key := string(signer.PublicKey().Marshal())
+			if !seen[key] {
+				seen[key] = true
+				signers = append(signers, signer)
+			}
+		}
+	}
+
+	for _, method := range methods {
+		switch method {
+		case AuthAgent:
+			if agentConn != nil {
+				continue
+			}
+			agentSigners, conn, err := agentSigners()
+			if err != nil {
+				// No agent running is not an error, other methods may work
+				continue
+			}
+			agentConn = conn
+			if ep.IdentitiesOnly {
+				agentSigners = matchingSigners(agentSigners, fileSigners)
+			}
+			add(agentSigners)
+
+		case AuthKey:
+			add(fileSigners)
+		}
+	}
+
+	if len(signers) == 0 && slices.Contains(methods, AuthKey) {
+		// Nothing else to offer, so ask for the passphrase of identities
+		// that can't be matched to a server's offer without decrypting them
+		add(legacySigners(ep))
+	}
+
+	if len(signers) == 0 {
+		if agentConn != nil {
+			agentConn.Close()
+		}
+		return nil, nil, errors.New("no SSH keys available: set ssh_key or add a key to ssh-agent")
+	}
+
+	return ssh.PublicKeys(signers...), agentConn, nil
+}
+
+// identitySigners loads the endpoint's key file and the identity files from
+// the ssh config. Only a missing or broken ssh_key is an error: like ssh,
+// identities from the ssh config that don't exist or can't be used are
+// skipped, so the agent's keys still get a chance.
+func identitySigners(ep Endpoint) ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+
+	if ep.KeyPath != "" {
+		signer, err := keySigner(expandPath(ep.KeyPath), ep.Passphrase, true)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+
+	for _, path := range ep.IdentityFiles {
+		signer, err := keySigner(expandPath(path), "", false)
+		if err != nil {
+			continue
+		}
+		signers = append(signers, signer)
+	}
+
+	return signers, nil
+}
+
+// legacySigners loads the ssh config identities again, this time asking
+// for the passphrase of keys encrypted in an older format without a .pub
+// file. It's only used when no other key is available, since it can't
+// wait for the server to accept the key first.
+func legacySigners(ep Endpoint) []ssh.Signer {
+	var signers []ssh.Signer
+	for _, path := range ep.IdentityFiles {
+		if signer, err := keySigner(expandPath(path), "", true); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	return signers
+}
+
+// matchingSigners keeps the agent keys that are also one of the key files
+func matchingSigners(agentSigners, fileSigners []ssh.Signer) []ssh.Signer {
+	var matched []ssh.Signer
+	for _, a := range agentSigners {
+		for _, f := range fileSigners {
+			if bytes.Equal(a.PublicKey().Marshal(), f.PublicKey().Marshal()) {
+				matched = append(matched, a)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// agentSigners returns the keys held by the running ssh-agent
+func agentSigners() ([]ssh.Signer, io.Closer, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, errors.New("SSH_AUTH_SOCK not set")
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect to ssh-agent: %w", err)
+	}
+
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("list ssh-agent keys: %w", err)
+	}
+	return signers, conn, nil
+}
+
+// keySigner loads a private key file. Encrypted keys are decrypted with
+// passphrase, or with a passphrase asked for only once the server has
+// accepted the key. Older key formats don't expose the public key, so
+// it's read from the .pub file next to them; without one the key is
+// decrypted right away when decryptNow is set, and rejected otherwise.
+func keySigner(keyPath, passphrase string, decryptNow bool) (ssh.Signer, error) {
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("read key %s: %w", keyPath, err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(key)
+	if err == nil {
+		return signer, nil
+	}
+
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return nil, fmt.Errorf("parse key: %w", err)
+	}
+
+	if passphrase != "" {
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("decrypt key %s: %w", keyPath, err)
+		}
+		return signer, nil
+	}
+
+	encrypted := &encryptedSigner{path: keyPath, pem: key, pub: missing.PublicKey}
+	if encrypted.pub == nil {
+		encrypted.pub = publicKeyFile(keyPath + ".pub")
+	}
+	if encrypted.pub == nil {
+		if !decryptNow {
+			return nil, fmt.Errorf("key %s is encrypted in an older format and has no .pub file", keyPath)
+		}
+		if _, err := encrypted.signer(); err != nil {
+			return nil, err
+		}
+		return encrypted.decrypted, nil
+	}
+	return encrypted, nil
+}
+
+// publicKeyFile reads an OpenSSH public key file, or returns nil when
+// there's no usable one
+func publicKeyFile(path string) ssh.PublicKey {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil
+	}
+	return pub
+}
+
+// encryptedSigner is a passphrase protected key that is decrypted on first
+// use, so the user is only asked when the server accepts the key
+type encryptedSigner struct {
+	path string
+	pem  []byte
+	pub  ssh.PublicKey
+
+	mu        sync.Mutex
+	decrypted ssh.Signer
+}
+
+func (s *encryptedSigner) signer() (ssh.Signer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.decrypted != nil {
+		return s.decrypted, nil
+	}
+
+	passphrase, err := secret(fmt.Sprintf("Enter passphrase for key %s:", s.path))
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKeyWithPassphrase(s.pem, []byte(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt key %s: %w", s.path, err)
+	}
+	s.decrypted = signer
+	return signer, nil
+}
+
+func (s *encryptedSigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+func (s *encryptedSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	signer, err := s.signer()
+	if err != nil {
+		return nil, err
+	}
+	return signer.Sign(rand, data)
+}
+
+func (s *encryptedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	signer, err := s.signer()
+	if err != nil {
+		return nil, err
+	}
+	if as, ok := signer.(ssh.AlgorithmSigner); ok {
+		return as.SignWithAlgorithm(rand, data, algorithm)
+	}
+	return signer.Sign(rand, data)
+}
@@ -0,0 +1,69 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestIdentitySignersSkipsUnusableIdentities(t *testing.T) {
+	dir := t.TempDir()
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKey(edKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := filepath.Join(dir, "id_ed25519")
+	os.WriteFile(good, pem.EncodeToMemory(block), 0600)
+
+	garbage := filepath.Join(dir, "id_garbage")
+	os.WriteFile(garbage, []byte("not a key"), 0600)
+
+	// A key encrypted in the old PEM format hides its public key
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	legacyBlock, _ := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey), []byte("secret"), x509.PEMCipherAES128)
+	legacy := filepath.Join(dir, "id_legacy")
+	os.WriteFile(legacy, pem.EncodeToMemory(legacyBlock), 0600)
+
+	// Unusable identities from the ssh config are skipped, and the legacy
+	// key isn't decrypted, which would ask for its passphrase
+	ep := Endpoint{IdentityFiles: []string{garbage, filepath.Join(dir, "missing"), legacy, good}}
+	signers, err := identitySigners(ep)
+	if err != nil {
+		t.Fatalf("identitySigners failed: %v", err)
+	}
+	if len(signers) != 1 {
+		t.Fatalf("Expected only the usable key, got %d signers", len(signers))
+	}
+
+	// With a .pub file the legacy key is offered and decrypted only once
+	// the server accepts it
+	pub, _ := ssh.NewPublicKey(&rsaKey.PublicKey)
+	os.WriteFile(legacy+".pub", ssh.MarshalAuthorizedKey(pub), 0644)
+	signers, err = identitySigners(ep)
+	if err != nil {
+		t.Fatalf("identitySigners failed: %v", err)
+	}
+	if len(signers) != 2 {
+		t.Fatalf("Expected the legacy key with its .pub file, got %d signers", len(signers))
+	}
+	if _, ok := signers[0].(*encryptedSigner); !ok {
+		t.Errorf("Expected the legacy key to be decrypted lazily, got %T", signers[0])
+	}
+
+	// A broken ssh_key is still an error
+	if _, err := identitySigners(Endpoint{KeyPath: garbage, IdentityFiles: []string{good}}); err == nil {
+		t.Error("Expected an error for a broken ssh_key")
+	}
+	if _, err := identitySigners(Endpoint{KeyPath: filepath.Join(dir, "missing")}); err == nil {
+		t.Error("Expected an error for a missing ssh_key")
+	}
+}
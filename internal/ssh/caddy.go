@@ -0,0 +1,125 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// containerStagingDir is where the Caddyfile and its imports are unpacked
+// inside the Caddy container for validation
+const containerStagingDir = "/tmp/rcm-staging"
+
+// caddyInvalidExit is the exit status the validation scripts use when caddy
+// rejects the Caddyfile, to tell it apart from docker or ssh failures
+const caddyInvalidExit = 65
+
+// ErrNoCaddy is returned by ValidateCaddyfile when there is no caddy binary
+var ErrNoCaddy = errors.New("caddy not found")
+
+// CaddyfileError is returned when caddy rejects a Caddyfile
+type CaddyfileError struct {
+	Messages []string // caddy's errors, each mentioning Caddyfile:<line>
+}
+
+func (e *CaddyfileError) Error() string {
+	return "invalid Caddyfile:\n    " + strings.Join(e.Messages, "\n    ")
+}
+
+// ValidateCaddyfile runs caddy validate on a Caddyfile on the remote. The
+// Caddyfile's directory holds the files it imports, laid out as they are
+// next to the live one. When composeDir is set the check runs inside that
+// compose service, otherwise with the caddy binary on the host. Rejected
+// files return a *CaddyfileError.
+func (c *Client) ValidateCaddyfile(caddyfile, composeDir, service string) error {
+	caddyfile = c.ExpandPath(caddyfile)
+	dir, name := path.Dir(caddyfile), path.Base(caddyfile)
+
+	var cmd string
+	if composeDir != "" {
+		// The container can't see the host directory, so it's streamed in
+		docker := "docker"
+		if c.user != "root" {
+			docker = "sudo docker"
+		}
+		cmd = fmt.Sprintf("tar -C %s -cf - . | (cd %s && %s compose exec -T %s sh -c %s)",
+			shellQuote(dir), shellQuote(c.ExpandPath(composeDir)), docker, shellQuote(service),
+			shellQuote(containerValidateScript(name)))
+	} else {
+		cmd = "sh -c " + shellQuote(fmt.Sprintf(
+			"cd %s && caddy validate --config %s --adapter caddyfile 2>&1; s=$?; [ $s -eq 1 ] && s=%d; exit $s",
+			shellQuote(dir), shellQuote(name), caddyInvalidExit))
+	}
+
+	output, err := c.runCombined(cmd)
+	if err == nil {
+		return nil
+	}
+
+	var exit *ssh.ExitError
+	if errors.As(err, &exit) {
+		switch exit.ExitStatus() {
+		case caddyInvalidExit:
+			return &CaddyfileError{Messages: caddyMessages(output, dir)}
+		case 127:
+			return fmt.Errorf("validate Caddyfile on %s: %w", c.host, ErrNoCaddy)
+		}
+	}
+	return fmt.Errorf("validate Caddyfile on %s: %w (%s)", c.host, err, strings.TrimSpace(output))
+}
+
+// containerValidateScript unpacks the tar on stdin into a scratch directory
+// and validates the Caddyfile name there
+func containerValidateScript(name string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "d=%s\n", containerStagingDir)
+	fmt.Fprintf(&b, "rm -rf \"$d\" && mkdir -p \"$d\" && tar -xf - -C \"$d\" || { echo 'unpacking the Caddyfile failed' >&2; exit 2; }\n")
+	fmt.Fprintf(&b, "cd \"$d\" && caddy validate --config %s --adapter caddyfile 2>&1\n", shellQuote(name))
+	fmt.Fprintf(&b, "s=$?\n")
+	fmt.Fprintf(&b, "rm -rf \"$d\"\n")
+	fmt.Fprintf(&b, "[ $s -eq 1 ] && s=%d\n", caddyInvalidExit)
+	fmt.Fprintf(&b, "exit $s\n")
+	return b.String()
+}
+
+// caddyMessages picks the error lines out of caddy validate's output, which
+// mixes JSON logs with a final "Error: ..." line. Paths are shown relative
+// to the staging directory dir so they read like the local files.
+func caddyMessages(output, dir string) []string {
+	var messages []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		msg, ok := strings.CutPrefix(line, "Error: ")
+		if !ok {
+			continue
+		}
+		msg = strings.TrimPrefix(msg, "adapting config using caddyfile: ")
+		msg = strings.ReplaceAll(msg, containerStagingDir+"/", "")
+		msg = strings.ReplaceAll(msg, dir+"/", "")
+		messages = append(messages, msg)
+	}
+
+	if len(messages) == 0 {
+		if output = strings.TrimSpace(output); output != "" {
+			messages = append(messages, output)
+		} else {
+			messages = append(messages, "caddy validate failed")
+		}
+	}
+	return messages
+}
+
+// runCombined runs cmd and returns its stdout and stderr, even if it fails
+func (c *Client) runCombined(cmd string) (string, error) {
+	session, err := c.newSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	output, err := session.CombinedOutput(cmd)
+	return string(output), err
+}
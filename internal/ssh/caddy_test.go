@@ -0,0 +1,36 @@
+package ssh
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCaddyMessages(t *testing.T) {
+	output := `{"level":"info","ts":1700000000.1,"msg":"using provided configuration","config_file":"Caddyfile","config_adapter":"caddyfile"}
+Error: adapting config using caddyfile: Caddyfile:12: unrecognized directive: revers_proxy
+`
+	got := caddyMessages(output, "/tmp/rcm-abc123")
+	want := []string{"Caddyfile:12: unrecognized directive: revers_proxy"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("caddyMessages() = %q, want %q", got, want)
+	}
+
+	// Imported files are named relative to the Caddyfile, wherever they
+	// were staged
+	got = caddyMessages("Error: /tmp/rcm-staging/sites/a.caddy:3 - Error during parsing: unexpected token '}'", "/tmp/rcm-abc123")
+	want = []string{"sites/a.caddy:3 - Error during parsing: unexpected token '}'"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("caddyMessages() = %q, want %q", got, want)
+	}
+	got = caddyMessages("Error: File to import not found: /tmp/rcm-abc123/sites/*.caddy", "/tmp/rcm-abc123")
+	want = []string{"File to import not found: sites/*.caddy"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("caddyMessages() = %q, want %q", got, want)
+	}
+
+	// Output without an Error: line is passed through
+	got = caddyMessages("  something odd\n", "/x")
+	if !reflect.DeepEqual(got, []string{"something odd"}) {
+		t.Errorf("caddyMessages() = %q", got)
+	}
+}
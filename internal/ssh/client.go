@@ -15,37 +15,71 @@ type Client struct {
 	host   string
 	user   string
 	client *ssh.Client
+	via    *Client // Jump host the connection is tunnelled through
 }
 
-// NewClient creates a new SSH client
-func NewClient(host, user, keyPath string) (*Client, error) {
-	keyPath = expandPath(keyPath)
+// Endpoint describes how to reach and log in to a host. Anything left empty
+// is looked up in ~/.ssh/config, so Host may also be an ssh config alias.
+type Endpoint struct {
+	Host           string     // Hostname, host:port or ssh config alias
+	User           string     // Login user
+	DefaultUser    string     // Login user when neither User nor ssh config set one
+	KeyPath        string     // Private key file, optional when using ssh-agent
+	Passphrase     string     // Passphrase for KeyPath; asked for when empty and needed
+	AuthMethods    []string   // Order of auth methods, DefaultAuthMethods when empty
+	IdentityFiles  []string   // Extra key files, skipped when missing
+	IdentitiesOnly bool       // Only offer agent keys that match a key file
+	Jumps          []Endpoint // Jump hosts to go through, first hop first
+}
+
+// NewClient creates a new SSH client that dials the host directly
+func NewClient(ep Endpoint) (*Client, error) {
+	return newClient(ep, nil)
+}
 
-	key, err := os.ReadFile(keyPath)
+// newClient connects to ep, tunnelling through via when it is set
+func newClient(ep Endpoint, via *Client) (*Client, error) {
+	resolved, err := resolveEndpoint(ep)
 	if err != nil {
-		return nil, fmt.Errorf("read key %s: %w", keyPath, err)
+		return nil, err
 	}
+	return connect(resolved, ep.Host, via)
+}
 
-	signer, err := ssh.ParsePrivateKey(key)
+// connect dials an endpoint resolveEndpoint has filled in, tunnelling
+// through via when it is set. host is what the endpoint was called before
+// it was resolved, used in messages.
+func connect(ep Endpoint, host string, via *Client) (*Client, error) {
+	addr, user := ep.Host, ep.User
+
+	auth, agentConn, err := publicKeyAuth(ep)
 	if err != nil {
-		return nil, fmt.Errorf("parse key: %w", err)
+		return nil, err
+	}
+	if agentConn != nil {
+		// The agent is only needed during the handshake
+		defer agentConn.Close()
 	}
 
-	config := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // TODO: Use known_hosts
-		Timeout:         10 * time.Second,
+	verifyHostKey, hostKeyAlgorithms, err := hostKeyCallback(addr)
+	if err != nil {
+		return nil, err
 	}
 
-	addr := host
-	if !strings.Contains(host, ":") {
-		addr = host + ":22"
+	config := &ssh.ClientConfig{
+		User:              user,
+		Auth:              []ssh.AuthMethod{auth},
+		HostKeyCallback:   verifyHostKey,
+		HostKeyAlgorithms: hostKeyAlgorithms,
+		Timeout:           10 * time.Second,
 	}
 
-	client, err := ssh.Dial("tcp", addr, config)
+	var client *ssh.Client
+	if via == nil {
+		client, err = ssh.Dial("tcp", addr, config)
+	} else {
+		client, err = dialThrough(via, addr, config)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("connect to %s: %w", host, err)
 	}
@@ -54,14 +88,65 @@ func NewClient(host, user, keyPath string) (*Client, error) {
 		host:   host,
 		user:   user,
 		client: client,
+		via:    via,
 	}, nil
 }
 
+// dialThrough opens an SSH connection to addr over a direct-tcpip channel
+// of an existing connection, like ssh -J does
+func dialThrough(via *Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := via.client.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("via %s: %w", via.host, err)
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// ParseProxyJump splits a ProxyJump spec ("[user@]host[:port],...") into
+// endpoints, first hop first. "none" disables jumping.
+func ParseProxyJump(spec string) []Endpoint {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || strings.EqualFold(spec, "none") {
+		return nil
+	}
+
+	var hops []Endpoint
+	for _, hop := range strings.Split(spec, ",") {
+		hop = strings.TrimPrefix(strings.TrimSpace(hop), "ssh://")
+		if hop == "" {
+			continue
+		}
+		ep := Endpoint{Host: hop}
+		if i := strings.LastIndex(hop, "@"); i >= 0 {
+			ep.User, ep.Host = hop[:i], hop[i+1:]
+		}
+		hops = append(hops, ep)
+	}
+	return hops
+}
+
+// newSession opens a session. When that fails the connection is broken,
+// so it's dropped from the pool to be made again next time.
+func (c *Client) newSession() (*ssh.Session, error) {
+	session, err := c.client.NewSession()
+	if err != nil {
+		RemoveClient(c)
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+	return session, nil
+}
+
 // Run executes a command and returns stdout
 func (c *Client) Run(cmd string) (string, error) {
-	session, err := c.client.NewSession()
+	session, err := c.newSession()
 	if err != nil {
-		return "", fmt.Errorf("create session: %w", err)
+		return "", err
 	}
 	defer session.Close()
 
@@ -0,0 +1,29 @@
+package ssh
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProxyJump(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []Endpoint
+	}{
+		{"", nil},
+		{"none", nil},
+		{"vps", []Endpoint{{Host: "vps"}}},
+		{"admin@bastion:2222, root@vps", []Endpoint{
+			{Host: "bastion:2222", User: "admin"},
+			{Host: "vps", User: "root"},
+		}},
+		{"ssh://pi@home.local", []Endpoint{{Host: "home.local", User: "pi"}}},
+	}
+
+	for _, tt := range tests {
+		got := ParseProxyJump(tt.spec)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseProxyJump(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// AcceptNewHostKeys trusts and records host keys of unknown hosts without
+// asking. Keys that don't match a recorded one are still rejected.
+var AcceptNewHostKeys bool
+
+var knownHostsMu sync.Mutex
+
+// HostKeyError is returned when a host presents a key that doesn't match
+// the one recorded in a known_hosts file
+type HostKeyError struct {
+	Host  string
+	Key   ssh.PublicKey
+	Known []knownhosts.KnownKey
+}
+
+func (e *HostKeyError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "host key mismatch for %s\n", e.Host)
+	fmt.Fprintf(&b, "  presented: %s %s\n", e.Key.Type(), ssh.FingerprintSHA256(e.Key))
+	for _, k := range e.Known {
+		fmt.Fprintf(&b, "  expected:  %s %s (%s:%d)\n", k.Key.Type(), ssh.FingerprintSHA256(k.Key), k.Filename, k.Line)
+	}
+	b.WriteString("The host may have been reinstalled, or someone may be intercepting the connection.\n")
+	b.WriteString("If the change is expected, remove the old entry from the file listed above.")
+	return b.String()
+}
+
+// UnknownHostError is returned when a host key is not known and the user
+// did not choose to trust it
+type UnknownHostError struct {
+	Host string
+	Key  ssh.PublicKey
+}
+
+func (e *UnknownHostError) Error() string {
+	return fmt.Sprintf("host key for %s is not trusted (%s %s); verify it and rerun with --accept-new-host-key",
+		e.Host, e.Key.Type(), ssh.FingerprintSHA256(e.Key))
+}
+
+// KnownHostsPath returns the rcm-managed known_hosts file, where host keys
+// accepted on first use are recorded
+func KnownHostsPath() string {
+	return expandPath("~/.config/rcm/known_hosts")
+}
+
+// knownHostsFiles returns the known_hosts files that exist, in lookup order
+func knownHostsFiles() []string {
+	var files []string
+	for _, path := range []string{expandPath("~/.ssh/known_hosts"), KnownHostsPath()} {
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// hostKeyCallback returns a callback verifying host keys against the
+// known_hosts files, and the key algorithms already recorded for addr so
+// the server is asked for a key we can actually check.
+func hostKeyCallback(addr string) (ssh.HostKeyCallback, []string, error) {
+	knownHostsMu.Lock()
+	known, err := knownhosts.New(knownHostsFiles()...)
+	knownHostsMu.Unlock()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read known_hosts: %w", err)
+	}
+
+	callback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := known(hostname, remote, key)
+
+		var keyErr *knownhosts.KeyError
+		if err == nil || !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			return &HostKeyError{Host: hostname, Key: key, Known: keyErr.Want}
+		}
+
+		// Unknown host: trust on first use
+		if !AcceptNewHostKeys {
+			question := fmt.Sprintf("The authenticity of host '%s' can't be established.\n%s key fingerprint is %s.\nTrust this host and remember its key?",
+				hostname, key.Type(), ssh.FingerprintSHA256(key))
+			ok, err := confirm(question)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return &UnknownHostError{Host: hostname, Key: key}
+			}
+		}
+
+		return rememberHostKey(hostname, key)
+	}
+
+	return callback, knownAlgorithms(known, addr), nil
+}
+
+// rememberHostKey appends a host key to the rcm-managed known_hosts file
+func rememberHostKey(hostname string, key ssh.PublicKey) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	path := KnownHostsPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create %s: %w", filepath.Dir(path), err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// knownAlgorithms returns the host key algorithms recorded for addr.
+// It probes the callback with a throwaway key; the resulting KeyError lists
+// every key known for the host.
+func knownAlgorithms(known ssh.HostKeyCallback, addr string) []string {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil
+	}
+	probe, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	err = known(addr, &net.TCPAddr{IP: net.IPv4zero}, probe)
+	if !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	seen := make(map[string]bool)
+	add := func(algo string) {
+		if !seen[algo] {
+			seen[algo] = true
+			algorithms = append(algorithms, algo)
+		}
+	}
+	for _, k := range keyErr.Want {
+		if k.Key.Type() == ssh.KeyAlgoRSA {
+			// RSA keys are negotiated with SHA-2 signature algorithms
+			add(ssh.KeyAlgoRSASHA512)
+			add(ssh.KeyAlgoRSASHA256)
+		}
+		add(k.Key.Type())
+	}
+	return algorithms
+}
@@ -0,0 +1,79 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("convert key: %v", err)
+	}
+	return key
+}
+
+type answerPrompter bool
+
+func (a answerPrompter) Confirm(string) (bool, error) {
+	return bool(a), nil
+}
+
+func (a answerPrompter) Secret(string) (string, error) {
+	return "", nil
+}
+
+func TestHostKeyTrustOnFirstUse(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	defer SetPrompter(nil)
+
+	addr := "vps.example.com:22"
+	remote := &net.TCPAddr{IP: net.ParseIP("203.0.113.10"), Port: 22}
+	key := newTestKey(t)
+
+	// Rejected by the user
+	SetPrompter(answerPrompter(false))
+	callback, _, err := hostKeyCallback(addr)
+	if err != nil {
+		t.Fatalf("hostKeyCallback failed: %v", err)
+	}
+	var unknown *UnknownHostError
+	if err := callback(addr, remote, key); !errors.As(err, &unknown) {
+		t.Fatalf("Expected UnknownHostError, got %v", err)
+	}
+
+	// Accepted and recorded
+	SetPrompter(answerPrompter(true))
+	callback, _, _ = hostKeyCallback(addr)
+	if err := callback(addr, remote, key); err != nil {
+		t.Fatalf("Expected key to be accepted, got %v", err)
+	}
+
+	// Known now - no prompt needed
+	SetPrompter(nil)
+	callback, algorithms, _ := hostKeyCallback(addr)
+	if err := callback(addr, remote, key); err != nil {
+		t.Errorf("Expected recorded key to verify, got %v", err)
+	}
+	if len(algorithms) != 1 || algorithms[0] != ssh.KeyAlgoED25519 {
+		t.Errorf("Expected [%s], got %v", ssh.KeyAlgoED25519, algorithms)
+	}
+
+	// A different key for the same host is a mismatch
+	var mismatch *HostKeyError
+	if err := callback(addr, remote, newTestKey(t)); !errors.As(err, &mismatch) {
+		t.Fatalf("Expected HostKeyError, got %v", err)
+	}
+	if len(mismatch.Known) != 1 {
+		t.Errorf("Expected 1 known key, got %d", len(mismatch.Known))
+	}
+}
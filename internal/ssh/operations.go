@@ -1,33 +1,52 @@
 package ssh
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"path/filepath"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
-// UploadContent uploads string content to a remote file using shell commands (no SFTP)
-func (c *Client) UploadContent(content, remotePath string) error {
-	remotePath = c.expandRemotePath(remotePath)
+// UploadOptions controls how UploadContent replaces a remote file
+type UploadOptions struct {
+	Backups int         // Timestamped copies of the previous file to keep, 0 disables backups
+	Mode    os.FileMode // Mode of a new file, 0644 when zero
+	AsUser  bool        // Write as the login user instead of through sudo
+	InPlace bool        // Overwrite the live file instead of renaming over it, so a bind mount of just that file sees the change
+}
 
-	// Ensure parent directory exists
-	dir := filepath.Dir(remotePath)
-	mkdirCmd := fmt.Sprintf("mkdir -p %q", dir)
-	if c.user != "root" {
-		mkdirCmd = "sudo " + mkdirCmd
-	}
-	_, _ = c.Run(mkdirCmd) // Ignore error, directory might exist
+// backupDir holds the temp files and backups of the files in a directory.
+// It's hidden so that an import glob such as sites/* doesn't pick up
+// stale copies of a site.
+const backupDir = ".rcm-backups"
 
-	// Use base64 encoding to safely transfer content with special characters
-	encoded := base64.StdEncoding.EncodeToString([]byte(content))
-	writeCmd := fmt.Sprintf("echo %q | base64 -d > %q", encoded, remotePath)
-	if c.user != "root" {
-		writeCmd = fmt.Sprintf("echo %q | base64 -d | sudo tee %q > /dev/null", encoded, remotePath)
+// backupSuffix separates a file name from its backup timestamp
+const backupSuffix = ".rcm-backup-"
+
+// UploadContent uploads string content to a remote file using shell commands (no SFTP).
+// The content is written to a temp file in the backupDir next to
+// remotePath, checked against its SHA-256, given the old file's owner and
+// mode, and moved into place, so a dropped connection never leaves a
+// truncated file behind.
+func (c *Client) UploadContent(content, remotePath string, opts UploadOptions) error {
+	remotePath = c.ExpandPath(remotePath)
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	cmd := "sh -c " + shellQuote(uploadScript(content, remotePath, opts, stamp))
+	if c.user != "root" && !opts.AsUser {
+		cmd = "sudo " + cmd
 	}
 
-	_, err := c.Run(writeCmd)
+	_, err := c.Run(cmd)
 	if err != nil {
 		return fmt.Errorf("write to %s: %w", remotePath, err)
 	}
@@ -35,26 +54,252 @@ func (c *Client) UploadContent(content, remotePath string) error {
 	return nil
 }
 
-// DownloadContent downloads a remote file using cat (no SFTP)
+// uploadScript returns the shell script that atomically replaces path
+func uploadScript(content, path string, opts UploadOptions, stamp string) string {
+	// Use base64 encoding to safely transfer content with special characters
+	encoded := base64.StdEncoding.EncodeToString([]byte(content))
+	sum := sha256.Sum256([]byte(content))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "set -e\n")
+	fmt.Fprintf(&b, "dst=%s\n", shellQuote(path))
+	fmt.Fprintf(&b, "bak=\"$(dirname \"$dst\")/%s\"\n", backupDir)
+	fmt.Fprintf(&b, "name=$(basename \"$dst\")\n")
+	fmt.Fprintf(&b, "mkdir -p \"$bak\"\n")
+	// Older versions kept backups next to the file
+	fmt.Fprintf(&b, "for old in \"$dst\"%s*; do [ -e \"$old\" ] && mv -f \"$old\" \"$bak/\"; done\n", backupSuffix)
+	fmt.Fprintf(&b, "tmp=$(mktemp \"$bak/$name.rcm-tmp-XXXXXX\")\n")
+	fmt.Fprintf(&b, "trap 'rm -f \"$tmp\"' EXIT\n")
+	fmt.Fprintf(&b, "echo %s | base64 -d > \"$tmp\"\n", encoded)
+	fmt.Fprintf(&b, "[ \"$(sha256sum \"$tmp\" | cut -d' ' -f1)\" = %s ] || { echo 'checksum mismatch after transfer' >&2; exit 1; }\n", hex.EncodeToString(sum[:]))
+	fmt.Fprintf(&b, "if [ -e \"$dst\" ]; then\n")
+	fmt.Fprintf(&b, "  chown \"$(stat -c %%u:%%g \"$dst\")\" \"$tmp\"\n")
+	fmt.Fprintf(&b, "  chmod \"$(stat -c %%a \"$dst\")\" \"$tmp\"\n")
+	if opts.Backups > 0 {
+		fmt.Fprintf(&b, "  cp -p \"$dst\" \"$bak/$name%s%s\"\n", backupSuffix, stamp)
+		// Timestamps sort by name, so everything after the newest N goes
+		fmt.Fprintf(&b, "  ls -1r \"$bak/$name\"%s* | tail -n +%d | while read -r old; do rm -f \"$old\"; done\n", backupSuffix, opts.Backups+1)
+	}
+	fmt.Fprintf(&b, "else\n")
+	mode := opts.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+	fmt.Fprintf(&b, "  chmod %o \"$tmp\"\n", mode.Perm())
+	fmt.Fprintf(&b, "fi\n")
+	if opts.InPlace {
+		// The content was checked above, so only a full disk can cut this short
+		fmt.Fprintf(&b, "if [ -e \"$dst\" ]; then cat \"$tmp\" > \"$dst\"; else mv -f \"$tmp\" \"$dst\"; fi\n")
+	} else {
+		fmt.Fprintf(&b, "mv -f \"$tmp\" \"$dst\"\n")
+	}
+	return b.String()
+}
+
+// RemoveOldFiles deletes all but the newest keep files in dir ending in
+// suffix, newest by name. It runs as the login user.
+func (c *Client) RemoveOldFiles(dir, suffix string, keep int) error {
+	dir = c.ExpandPath(dir)
+
+	cmd := fmt.Sprintf("ls -1r %s/*%s 2>/dev/null | tail -n +%d | while read -r old; do rm -f \"$old\"; done",
+		shellQuote(dir), suffix, keep+1)
+	if _, err := c.Run(cmd); err != nil {
+		return fmt.Errorf("prune %s: %w", dir, err)
+	}
+	return nil
+}
+
+// shellQuote quotes s for use as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// DownloadContent downloads a remote file using cat (no SFTP). Files the
+// login user can't read, such as a root-only rathole config, are read
+// through passwordless sudo.
 func (c *Client) DownloadContent(remotePath string) (string, error) {
-	remotePath = c.expandRemotePath(remotePath)
+	remotePath = c.ExpandPath(remotePath)
 
 	output, err := c.Run(fmt.Sprintf("cat %q", remotePath))
+	if err != nil && c.user != "root" {
+		if sudoOutput, sudoErr := c.Run(fmt.Sprintf("sudo -n cat %q", remotePath)); sudoErr == nil {
+			return sudoOutput, nil
+		}
+	}
 	if err != nil {
 		return "", fmt.Errorf("read %s: %w", remotePath, err)
 	}
 	return output, nil
 }
 
-// FileExists checks if a remote file exists using test command (no SFTP)
+// Exit statuses of fileExistsScript besides 0, which means the file exists
+const (
+	fileMissingExit = 3 // nothing is at the path
+	fileUnknownExit = 4 // something else is there, or the directory can't be searched
+)
+
+// FileExists checks if a remote file exists using test command (no SFTP).
+// Only a path with nothing at it is reported as missing: a directory the
+// login user can't search is checked through passwordless sudo, and any
+// other failure is returned as an error.
 func (c *Client) FileExists(remotePath string) (bool, error) {
-	remotePath = c.expandRemotePath(remotePath)
+	remotePath = c.ExpandPath(remotePath)
 
-	_, err := c.Run(fmt.Sprintf("test -f %q && echo exists", remotePath))
-	if err != nil {
+	script := fileExistsScript(remotePath)
+	_, err := c.Run(script)
+	if isExit(err, fileUnknownExit) && c.user != "root" {
+		_, err = c.Run("sudo -n sh -c " + shellQuote(script))
+	}
+	switch {
+	case err == nil:
+		return true, nil
+	case isExit(err, fileMissingExit):
 		return false, nil
+	case isExit(err, fileUnknownExit):
+		return false, fmt.Errorf("check %s: not a regular file, or its directory can't be searched", remotePath)
+	}
+	return false, fmt.Errorf("check %s: %w", remotePath, err)
+}
+
+// fileExistsScript exits 0 when path is a regular file and
+// fileMissingExit when nothing is there. When it can't tell, because the
+// path is something else or the closest existing directory above it
+// can't be searched, it exits fileUnknownExit.
+func fileExistsScript(path string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "p=%s\n", shellQuote(path))
+	fmt.Fprintf(&b, "[ -f \"$p\" ] && exit 0\n")
+	fmt.Fprintf(&b, "[ -e \"$p\" ] && exit %d\n", fileUnknownExit)
+	fmt.Fprintf(&b, "d=$p; while d=$(dirname \"$d\"); [ ! -e \"$d\" ]; do :; done\n")
+	fmt.Fprintf(&b, "[ -x \"$d\" ] || exit %d\n", fileUnknownExit)
+	fmt.Fprintf(&b, "exit %d\n", fileMissingExit)
+	return b.String()
+}
+
+// isExit reports whether err is a remote command exiting with status
+func isExit(err error, status int) bool {
+	var exit *ssh.ExitError
+	return errors.As(err, &exit) && exit.ExitStatus() == status
+}
+
+// FileHash returns the hex SHA-256 of a remote file, or "" if it doesn't exist
+func (c *Client) FileHash(remotePath string) (string, error) {
+	exists, err := c.FileExists(remotePath)
+	if err != nil || !exists {
+		return "", err
+	}
+	remotePath = c.ExpandPath(remotePath)
+
+	output, err := c.Run(fmt.Sprintf("sha256sum %q", remotePath))
+	if err != nil && c.user != "root" {
+		if sudoOutput, sudoErr := c.Run(fmt.Sprintf("sudo -n sha256sum %q", remotePath)); sudoErr == nil {
+			output, err = sudoOutput, nil
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("hash %s: %w", remotePath, err)
+	}
+
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("hash %s: unexpected output %q", remotePath, output)
+	}
+	return fields[0], nil
+}
+
+// Glob returns the remote files matching a shell glob, sorted by name
+func (c *Client) Glob(pattern string) ([]string, error) {
+	pattern = c.ExpandPath(pattern)
+
+	output, err := c.Run(globScript(pattern))
+	if err != nil {
+		return nil, fmt.Errorf("glob %s: %w", pattern, err)
 	}
-	return true, nil
+
+	var matches []string
+	for _, line := range strings.Split(output, "\n") {
+		if line != "" {
+			matches = append(matches, line)
+		}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// globScript lists the files matching pattern. Everything but the glob
+// characters is quoted, and a pattern that matches nothing prints nothing.
+func globScript(pattern string) string {
+	var quoted strings.Builder
+	for len(pattern) > 0 {
+		i := strings.IndexAny(pattern, "*?[]")
+		if i < 0 {
+			i = len(pattern)
+		}
+		if i > 0 {
+			quoted.WriteString(shellQuote(pattern[:i]))
+		}
+		if i < len(pattern) {
+			quoted.WriteByte(pattern[i])
+			i++
+		}
+		pattern = pattern[i:]
+	}
+	return fmt.Sprintf("for f in %s; do if [ -f \"$f\" ]; then printf '%%s\\n' \"$f\"; fi; done", quoted.String())
+}
+
+// MakeTempDir creates a private directory under /tmp as the login user
+func (c *Client) MakeTempDir() (string, error) {
+	output, err := c.Run("mktemp -d /tmp/rcm-XXXXXX")
+	if err != nil {
+		return "", fmt.Errorf("create temp directory: %w", err)
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// RemoveDir deletes a remote directory the login user owns, with its contents
+func (c *Client) RemoveDir(dir string) error {
+	dir = c.ExpandPath(dir)
+
+	if _, err := c.Run("rm -rf " + shellQuote(dir)); err != nil {
+		return fmt.Errorf("remove %s: %w", dir, err)
+	}
+	return nil
+}
+
+// ListeningPorts returns the TCP ports something listens on, from ss -ltn
+func (c *Client) ListeningPorts() (map[int]bool, error) {
+	output, err := c.Run("ss -ltn")
+	if err != nil {
+		return nil, fmt.Errorf("list listening ports: %w", err)
+	}
+	return parseListeningPorts(output), nil
+}
+
+// ListeningUDPPorts returns the UDP ports something listens on, from ss -lun
+func (c *Client) ListeningUDPPorts() (map[int]bool, error) {
+	output, err := c.Run("ss -lun")
+	if err != nil {
+		return nil, fmt.Errorf("list listening ports: %w", err)
+	}
+	return parseListeningPorts(output), nil
+}
+
+// parseListeningPorts reads the port out of the local address column of
+// ss output, such as 0.0.0.0:22, [::]:443 or *:80
+func parseListeningPorts(output string) map[int]bool {
+	ports := make(map[int]bool)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		addr := fields[3]
+		i := strings.LastIndexByte(addr, ':')
+		if port, err := strconv.Atoi(addr[i+1:]); err == nil {
+			ports[port] = true
+		}
+	}
+	return ports
 }
 
 // RestartService restarts a systemd service (uses sudo if not root)
@@ -95,6 +340,23 @@ func (c *Client) RestartDockerCompose(dir string) error {
 	return nil
 }
 
+// ReloadCaddy makes Caddy in a docker compose service load its Caddyfile,
+// at caddyfile inside the container, again without restarting the
+// container, so open connections survive
+func (c *Client) ReloadCaddy(dir, service, caddyfile string) error {
+	docker := "docker"
+	if c.user != "root" {
+		docker = "sudo docker"
+	}
+	cmd := fmt.Sprintf("cd %s && %s compose exec -T %s caddy reload --config %s --adapter caddyfile",
+		shellQuote(c.ExpandPath(dir)), docker, shellQuote(service), shellQuote(caddyfile))
+	_, err := c.Run(cmd)
+	if err != nil {
+		return fmt.Errorf("caddy reload in %s on %s: %w", dir, c.host, err)
+	}
+	return nil
+}
+
 // DockerComposeContainer represents a container from docker compose ps --format json
 type DockerComposeContainer struct {
 	Name  string `json:"Name"`
@@ -140,8 +402,8 @@ func (c *Client) GetDockerComposeStatus(dir string) (bool, string, error) {
 	return running, status, nil
 }
 
-// expandRemotePath expands ~ based on user
-func (c *Client) expandRemotePath(path string) string {
+// ExpandPath expands a leading ~ in a remote path to the login user's home
+func (c *Client) ExpandPath(path string) string {
 	if strings.HasPrefix(path, "~") {
 		if c.user == "root" {
 			return "/root" + path[1:]
@@ -0,0 +1,175 @@
+package ssh
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runUploadScript(t *testing.T, content, path string, backups int, stamp string) {
+	t.Helper()
+	opts := UploadOptions{Backups: backups}
+	out, err := exec.Command("sh", "-c", uploadScript(content, path, opts, stamp)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("upload script failed: %v\n%s", err, out)
+	}
+}
+
+func TestUploadScriptReplacesAtomically(t *testing.T) {
+	for _, tool := range []string{"sh", "sha256sum", "base64", "mktemp", "stat"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rathole", "server.toml")
+
+	// New file: parent directory is created and the file is world readable
+	runUploadScript(t, "first 'quoted' $HOME\n", path, 2, "20250101T000000Z")
+	got, _ := os.ReadFile(path)
+	if string(got) != "first 'quoted' $HOME\n" {
+		t.Fatalf("Unexpected content: %q", got)
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("Expected mode 0644 for new file, got %o", info.Mode().Perm())
+	}
+
+	// Existing file: mode is kept and the previous version is backed up,
+	// along with backups older versions left next to the file
+	os.Chmod(path, 0600)
+	os.WriteFile(path+backupSuffix+"20241231T000000Z", []byte("legacy\n"), 0600)
+	runUploadScript(t, "second\n", path, 2, "20250102T000000Z")
+	runUploadScript(t, "third\n", path, 2, "20250103T000000Z")
+	runUploadScript(t, "fourth\n", path, 2, "20250104T000000Z")
+
+	got, _ = os.ReadFile(path)
+	if string(got) != "fourth\n" {
+		t.Fatalf("Unexpected content: %q", got)
+	}
+	info, _ = os.Stat(path)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600 to be kept, got %o", info.Mode().Perm())
+	}
+
+	bak := filepath.Join(filepath.Dir(path), backupDir, filepath.Base(path))
+	backups, _ := filepath.Glob(bak + backupSuffix + "*")
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups to be kept, got %v", backups)
+	}
+	newest, _ := os.ReadFile(bak + backupSuffix + "20250104T000000Z")
+	if string(newest) != "third\n" {
+		t.Errorf("Expected newest backup to hold the previous version, got %q", newest)
+	}
+
+	// No temp files are left behind
+	leftovers, _ := filepath.Glob(bak + ".rcm-tmp-*")
+	if len(leftovers) != 0 {
+		t.Errorf("Expected no temp files, got %v", leftovers)
+	}
+
+	// Nothing but the file itself is left where an import glob could see
+	// it; caddy skips hidden files when a glob such as sites/* starts with *
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	for _, entry := range entries {
+		if entry.Name() != filepath.Base(path) && !strings.HasPrefix(entry.Name(), ".") {
+			t.Errorf("Unexpected file %s next to the upload", entry.Name())
+		}
+	}
+}
+
+func TestUploadScriptInPlace(t *testing.T) {
+	for _, tool := range []string{"sh", "sha256sum", "base64", "mktemp", "stat"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "Caddyfile")
+	os.WriteFile(path, []byte("old\n"), 0644)
+	before, _ := os.Stat(path)
+
+	// A bind mount of just the Caddyfile keeps following the same inode
+	script := uploadScript("new\n", path, UploadOptions{InPlace: true}, "20250101T000000Z")
+	if out, err := exec.Command("sh", "-c", script).CombinedOutput(); err != nil {
+		t.Fatalf("upload script failed: %v\n%s", err, out)
+	}
+	got, _ := os.ReadFile(path)
+	if string(got) != "new\n" {
+		t.Fatalf("Unexpected content: %q", got)
+	}
+	after, _ := os.Stat(path)
+	if !os.SameFile(before, after) {
+		t.Error("Expected the file to be overwritten in place")
+	}
+}
+
+func TestGlobScript(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.caddy", "a.caddy", "notes.txt", "it's.caddy"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+
+	out, err := exec.Command("sh", "-c", globScript(dir+"/*.caddy")).Output()
+	if err != nil {
+		t.Fatalf("glob script failed: %v", err)
+	}
+	want := dir + "/a.caddy\n" + dir + "/b.caddy\n" + dir + "/it's.caddy\n"
+	if string(out) != want {
+		t.Errorf("Unexpected matches:\n%s", out)
+	}
+
+	// No match prints nothing instead of the pattern itself
+	out, _ = exec.Command("sh", "-c", globScript(dir+"/*.conf")).Output()
+	if len(out) != 0 {
+		t.Errorf("Expected no matches, got %q", out)
+	}
+}
+
+func TestFileExistsScript(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "it's.toml"), nil, 0644)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{filepath.Join(dir, "it's.toml"), 0},
+		{filepath.Join(dir, "missing.toml"), fileMissingExit},
+		{filepath.Join(dir, "no", "such", "dir", "client.toml"), fileMissingExit},
+		{filepath.Join(dir, "sub"), fileUnknownExit},
+	}
+	for _, tt := range tests {
+		err := exec.Command("sh", "-c", fileExistsScript(tt.path)).Run()
+		got := 0
+		if exit, ok := err.(*exec.ExitError); ok {
+			got = exit.ExitCode()
+		} else if err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: exit %d, want %d", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseListeningPorts(t *testing.T) {
+	output := `LISTEN 0      4096         0.0.0.0:2333       0.0.0.0:*
+LISTEN 0      128        127.0.0.1:8001       0.0.0.0:*
+LISTEN 0      4096            [::]:443           [::]:*
+LISTEN 0      511                *:80               *:*
+`
+	got := parseListeningPorts(output)
+	for _, port := range []int{2333, 8001, 443, 80} {
+		if !got[port] {
+			t.Errorf("Expected port %d in %v", port, got)
+		}
+	}
+	if len(got) != 4 {
+		t.Errorf("Expected 4 ports, got %v", got)
+	}
+}
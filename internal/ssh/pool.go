@@ -1,57 +1,161 @@
 package ssh
 
 import (
+	"fmt"
+	"slices"
 	"sync"
 )
 
+// maxJumpDepth limits how deep jump host chains may nest
+const maxJumpDepth = 8
+
+// poolEntry is the pooled connection to one user@host:port. Its mutex is
+// held while dialing, so other hosts can connect at the same time while
+// callers wanting this one wait for it instead of dialing twice. No other
+// lock is taken while it's held, so connections never wait on each other
+// in a cycle.
+type poolEntry struct {
+	connecting sync.Mutex
+	client     *Client // Guarded by poolMu, nil until connected
+}
+
 // pool manages SSH connections - one per host, reused for all operations
 var (
-	pool   = make(map[string]*Client)
-	poolMu sync.Mutex
+	pool      = make(map[string]*poolEntry)
+	poolOrder []string // Connection order; jump hosts come before their users
+	poolMu    sync.Mutex
 )
 
 // GetClient returns a cached connection or creates a new one.
 // This mimics the Python Fabric pattern - one connection per host, reused.
-func GetClient(host, user, keyPath string) (*Client, error) {
-	poolMu.Lock()
-	defer poolMu.Unlock()
+// Jump hosts are pooled too, so a hop shared by several hosts (or a jump
+// through the VPS itself) is only connected once.
+func GetClient(ep Endpoint) (*Client, error) {
+	return getClient(ep, nil, nil)
+}
+
+// getClient returns the pooled connection for ep, connecting through via or
+// ep's jump hosts when needed. chain holds the keys of the connections
+// waiting on this one, to catch ProxyJump loops in ~/.ssh/config.
+func getClient(ep Endpoint, via *Client, chain []string) (*Client, error) {
+	resolved, err := resolveEndpoint(ep)
+	if err != nil {
+		return nil, err
+	}
+	key := resolved.User + "@" + resolved.Host
 
-	key := user + "@" + host
+	if slices.Contains(chain, key) {
+		return nil, fmt.Errorf("connect to %s: jump hosts loop back to it", ep.Host)
+	}
+	if len(chain) > maxJumpDepth {
+		return nil, fmt.Errorf("connect to %s: jump hosts nested more than %d deep", ep.Host, maxJumpDepth)
+	}
+	chain = append(chain, key)
 
 	// Return cached connection if it exists
-	if client, ok := pool[key]; ok {
+	if client := pooled(key); client != nil {
+		return client, nil
+	}
+
+	// Reach the last hop first; each hop is tunnelled through the one before
+	if via == nil {
+		jumps := ep.Jumps
+		if len(jumps) == 0 {
+			if jumps, err = configJumps(ep); err != nil {
+				return nil, err
+			}
+		}
+		for _, hop := range jumps {
+			client, err := getClient(hop, via, chain)
+			if err != nil {
+				return nil, fmt.Errorf("jump host %s: %w", hop.Host, err)
+			}
+			via = client
+		}
+	}
+
+	poolMu.Lock()
+	entry, ok := pool[key]
+	if !ok {
+		entry = &poolEntry{}
+		pool[key] = entry
+	}
+	poolMu.Unlock()
+
+	entry.connecting.Lock()
+	defer entry.connecting.Unlock()
+
+	// Another caller may have connected while this one waited
+	if client := pooled(key); client != nil {
 		return client, nil
 	}
 
 	// Create new connection
-	client, err := NewClient(host, user, keyPath)
+	client, err := connect(resolved, ep.Host, via)
 	if err != nil {
 		return nil, err
 	}
 
-	pool[key] = client
+	poolMu.Lock()
+	entry.client = client
+	pool[key] = entry
+	poolOrder = append(poolOrder, key)
+	poolMu.Unlock()
 	return client, nil
 }
 
-// RemoveClient removes a client from the pool (call when connection fails)
-func RemoveClient(host, user string) {
+// pooled returns the connected client for key, or nil
+func pooled(key string) *Client {
 	poolMu.Lock()
 	defer poolMu.Unlock()
 
-	key := user + "@" + host
-	if client, ok := pool[key]; ok {
-		client.Close()
-		delete(pool, key)
+	if entry, ok := pool[key]; ok {
+		return entry.client
+	}
+	return nil
+}
+
+// RemoveClient drops a broken connection from the pool, along with every
+// connection tunnelled through it, so the next GetClient connects again
+func RemoveClient(c *Client) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	// Dependents were connected later, so close newest first
+	for i := len(poolOrder) - 1; i >= 0; i-- {
+		key := poolOrder[i]
+		entry := pool[key]
+		if entry.client.tunnelledThrough(c) {
+			entry.client.Close()
+			entry.client = nil
+			delete(pool, key)
+			poolOrder = append(poolOrder[:i], poolOrder[i+1:]...)
+		}
 	}
 }
 
 // CloseAll closes all cached connections. Call this when the app exits.
+// Connections are closed newest first, so every tunnel is shut down before
+// the jump host carrying it.
 func CloseAll() {
 	poolMu.Lock()
 	defer poolMu.Unlock()
 
-	for key, client := range pool {
-		client.Close()
-		delete(pool, key)
+	for i := len(poolOrder) - 1; i >= 0; i-- {
+		entry := pool[poolOrder[i]]
+		entry.client.Close()
+		entry.client = nil
+	}
+	pool = make(map[string]*poolEntry)
+	poolOrder = nil
+}
+
+// tunnelledThrough reports whether c is hop itself or reached through it
+func (c *Client) tunnelledThrough(hop *Client) bool {
+	for ; c != nil; c = c.via {
+		if c == hop {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,36 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetClientRejectsJumpLoops(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(`Host a
+    ProxyJump b
+Host b
+    ProxyJump a
+Host *
+    User deploy
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configs, err := loadSSHConfigs([]string{path})
+	if err != nil {
+		t.Fatalf("loadSSHConfigs failed: %v", err)
+	}
+	sshConfigOnce.Do(func() {})
+	sshConfigs, sshConfigErr = configs, nil
+
+	// The loop is caught before anything is dialed, and doesn't deadlock
+	_, err = GetClient(Endpoint{Host: "a"})
+	if err == nil || !strings.Contains(err.Error(), "loop") {
+		t.Fatalf("Expected a jump loop error, got %v", err)
+	}
+	if len(poolOrder) != 0 {
+		t.Errorf("Expected nothing to be pooled, got %v", poolOrder)
+	}
+}
@@ -0,0 +1,62 @@
+package ssh
+
+import (
+	"errors"
+	"sync"
+)
+
+// Prompter asks the user to make decisions while a connection is being
+// set up, such as trusting a host key seen for the first time. The TUI and
+// plain mode each provide their own implementation.
+type Prompter interface {
+	// Confirm asks a yes/no question and reports the answer
+	Confirm(question string) (bool, error)
+
+	// Secret asks for a value that must not be echoed, such as a passphrase
+	Secret(prompt string) (string, error)
+}
+
+var (
+	prompter   Prompter
+	prompterMu sync.Mutex
+
+	// askMu asks one question at a time, since several hosts may be
+	// connecting at once
+	askMu sync.Mutex
+)
+
+// SetPrompter sets the prompter used for interactive questions.
+// A nil prompter makes every question fail as if the user declined.
+func SetPrompter(p Prompter) {
+	prompterMu.Lock()
+	defer prompterMu.Unlock()
+	prompter = p
+}
+
+// confirm asks the current prompter a question
+func confirm(question string) (bool, error) {
+	prompterMu.Lock()
+	p := prompter
+	prompterMu.Unlock()
+
+	if p == nil {
+		return false, nil
+	}
+	askMu.Lock()
+	defer askMu.Unlock()
+	return p.Confirm(question)
+}
+
+// secret asks the current prompter for a hidden value
+func secret(prompt string) (string, error) {
+	prompterMu.Lock()
+	p := prompter
+	prompterMu.Unlock()
+
+	if p == nil {
+		return "", errors.New("passphrase required but no prompt available")
+	}
+	askMu.Lock()
+	defer askMu.Unlock()
+	return p.Secret(prompt)
+}
@@ -0,0 +1,225 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+
+	sshconfig "github.com/kevinburke/ssh_config"
+)
+
+// sshConfigFiles are the OpenSSH client configs used to resolve host aliases.
+// Like ssh, the first file that sets a value wins.
+var sshConfigFiles = []string{
+	"~/.ssh/config",
+	"/etc/ssh/ssh_config",
+}
+
+// defaultIdentityFiles are tried when neither rcm nor ~/.ssh/config name a key
+var defaultIdentityFiles = []string{
+	"~/.ssh/id_ed25519",
+	"~/.ssh/id_ecdsa",
+	"~/.ssh/id_rsa",
+}
+
+var (
+	sshConfigOnce sync.Once
+	sshConfigs    []*sshconfig.Config
+	sshConfigErr  error
+)
+
+// hostConfig is what the ssh config says about one host alias
+type hostConfig struct {
+	HostName       string
+	Port           string
+	User           string
+	IdentityFiles  []string
+	IdentitiesOnly bool
+	ProxyJump      string
+}
+
+// userSSHConfigs parses the ssh config files once per run
+func userSSHConfigs() ([]*sshconfig.Config, error) {
+	sshConfigOnce.Do(func() {
+		sshConfigs, sshConfigErr = loadSSHConfigs(sshConfigFiles)
+	})
+	return sshConfigs, sshConfigErr
+}
+
+// loadSSHConfigs parses the given files, following Include directives.
+// Missing files are skipped.
+func loadSSHConfigs(paths []string) ([]*sshconfig.Config, error) {
+	var configs []*sshconfig.Config
+	for _, path := range paths {
+		path = expandPath(path)
+
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+
+		cfg, err := sshconfig.Decode(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		configs = append(configs, cfg)
+	}
+	return configs, nil
+}
+
+// lookupHost collects the settings for alias from the parsed configs
+func lookupHost(configs []*sshconfig.Config, alias string) (hostConfig, error) {
+	var hc hostConfig
+
+	get := func(key string) (string, error) {
+		for _, cfg := range configs {
+			val, err := cfg.Get(alias, key)
+			if err != nil {
+				return "", err
+			}
+			if val != "" {
+				return val, nil
+			}
+		}
+		return "", nil
+	}
+
+	var err error
+	if hc.HostName, err = get("HostName"); err != nil {
+		return hc, err
+	}
+	if hc.Port, err = get("Port"); err != nil {
+		return hc, err
+	}
+	if hc.User, err = get("User"); err != nil {
+		return hc, err
+	}
+	if hc.ProxyJump, err = get("ProxyJump"); err != nil {
+		return hc, err
+	}
+	identitiesOnly, err := get("IdentitiesOnly")
+	if err != nil {
+		return hc, err
+	}
+	hc.IdentitiesOnly = strings.EqualFold(identitiesOnly, "yes")
+
+	// Unlike other keys, every matching IdentityFile is used
+	for _, cfg := range configs {
+		files, err := cfg.GetAll(alias, "IdentityFile")
+		if err != nil {
+			return hc, err
+		}
+		hc.IdentityFiles = append(hc.IdentityFiles, files...)
+	}
+
+	return hc, nil
+}
+
+// HostName returns the address host stands for, without a port: the
+// HostName ~/.ssh/config sets for it, or host itself
+func HostName(host string) string {
+	alias := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		alias = h
+	}
+	configs, err := userSSHConfigs()
+	if err != nil {
+		return alias
+	}
+	hc, err := lookupHost(configs, alias)
+	if err != nil || hc.HostName == "" {
+		return alias
+	}
+	return strings.ReplaceAll(hc.HostName, "%h", alias)
+}
+
+// resolveEndpoint fills in what ep leaves unset from ~/.ssh/config, so an
+// alias like "vps" connects the same way `ssh vps` does. Values set in the
+// rcm config take precedence. The returned Host is always host:port.
+func resolveEndpoint(ep Endpoint) (Endpoint, error) {
+	alias, port := ep.Host, ""
+	if h, p, err := net.SplitHostPort(ep.Host); err == nil {
+		alias, port = h, p
+	}
+
+	configs, err := userSSHConfigs()
+	if err != nil {
+		return ep, fmt.Errorf("ssh config: %w", err)
+	}
+	hc, err := lookupHost(configs, alias)
+	if err != nil {
+		return ep, fmt.Errorf("ssh config for %s: %w", alias, err)
+	}
+
+	hostname := alias
+	if hc.HostName != "" {
+		hostname = strings.ReplaceAll(hc.HostName, "%h", alias)
+	}
+	if port == "" {
+		port = hc.Port
+	}
+	if port == "" {
+		port = "22"
+	}
+	ep.Host = net.JoinHostPort(hostname, port)
+
+	if ep.User == "" {
+		ep.User = hc.User
+	}
+	if ep.User == "" {
+		ep.User = ep.DefaultUser
+	}
+	if ep.User == "" {
+		if u, err := user.Current(); err == nil {
+			ep.User = u.Username
+		}
+	}
+
+	for _, file := range hc.IdentityFiles {
+		ep.IdentityFiles = append(ep.IdentityFiles, expandTokens(file, hostname, ep.User))
+	}
+	if ep.KeyPath == "" && len(ep.IdentityFiles) == 0 {
+		ep.IdentityFiles = defaultIdentityFiles
+	}
+	ep.IdentitiesOnly = ep.IdentitiesOnly || hc.IdentitiesOnly
+
+	return ep, nil
+}
+
+// configJumps returns the jump hosts ~/.ssh/config sets for ep's host
+func configJumps(ep Endpoint) ([]Endpoint, error) {
+	alias := ep.Host
+	if h, _, err := net.SplitHostPort(ep.Host); err == nil {
+		alias = h
+	}
+
+	configs, err := userSSHConfigs()
+	if err != nil {
+		return nil, fmt.Errorf("ssh config: %w", err)
+	}
+	hc, err := lookupHost(configs, alias)
+	if err != nil {
+		return nil, fmt.Errorf("ssh config for %s: %w", alias, err)
+	}
+	return ParseProxyJump(hc.ProxyJump), nil
+}
+
+// expandTokens expands the ~ and %-tokens ssh allows in IdentityFile
+func expandTokens(path, hostname, user string) string {
+	home, _ := os.UserHomeDir()
+	path = strings.NewReplacer(
+		"%%", "%",
+		"%d", home,
+		"%h", hostname,
+		"%r", user,
+		"%u", user,
+	).Replace(path)
+	return expandPath(path)
+}
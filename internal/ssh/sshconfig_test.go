@@ -0,0 +1,76 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveEndpointFromSSHConfig(t *testing.T) {
+	dir := t.TempDir()
+	included := filepath.Join(dir, "hosts.conf")
+	main := filepath.Join(dir, "config")
+
+	if err := os.WriteFile(included, []byte(`Host vps
+    HostName 203.0.113.50
+    Port 2222
+    User deploy
+    IdentityFile `+dir+`/vps_key
+    IdentitiesOnly yes
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(main, []byte(`Include `+included+`
+
+Host *
+    User fallback
+    IdentityFile `+dir+`/default_key
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	configs, err := loadSSHConfigs([]string{main, filepath.Join(dir, "missing")})
+	if err != nil {
+		t.Fatalf("loadSSHConfigs failed: %v", err)
+	}
+	sshConfigOnce.Do(func() {})
+	sshConfigs, sshConfigErr = configs, nil
+
+	ep, err := resolveEndpoint(Endpoint{Host: "vps", DefaultUser: "root"})
+	if err != nil {
+		t.Fatalf("resolveEndpoint failed: %v", err)
+	}
+	if ep.Host != "203.0.113.50:2222" {
+		t.Errorf("Expected host 203.0.113.50:2222, got %s", ep.Host)
+	}
+	if ep.User != "deploy" {
+		t.Errorf("Expected user deploy, got %s", ep.User)
+	}
+	wantFiles := []string{dir + "/vps_key", dir + "/default_key"}
+	if !reflect.DeepEqual(ep.IdentityFiles, wantFiles) {
+		t.Errorf("Expected identity files %v, got %v", wantFiles, ep.IdentityFiles)
+	}
+	if !ep.IdentitiesOnly {
+		t.Error("Expected IdentitiesOnly to be set")
+	}
+
+	if host := HostName("vps:2222"); host != "203.0.113.50" {
+		t.Errorf("HostName(vps:2222) = %s, want 203.0.113.50", host)
+	}
+	if host := HostName("other.example.com"); host != "other.example.com" {
+		t.Errorf("HostName(other.example.com) = %s", host)
+	}
+
+	// rcm config values win over the ssh config
+	ep, _ = resolveEndpoint(Endpoint{Host: "vps:22", User: "admin"})
+	if ep.Host != "203.0.113.50:22" || ep.User != "admin" {
+		t.Errorf("Expected admin@203.0.113.50:22, got %s@%s", ep.User, ep.Host)
+	}
+
+	// Unknown hosts only pick up the wildcard section
+	ep, _ = resolveEndpoint(Endpoint{Host: "10.0.0.5", DefaultUser: "root"})
+	if ep.Host != "10.0.0.5:22" || ep.User != "fallback" {
+		t.Errorf("Expected fallback@10.0.0.5:22, got %s@%s", ep.User, ep.Host)
+	}
+}
@@ -0,0 +1,37 @@
+package components
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/AhmedAburady/rcm-go/internal/tui/styles"
+)
+
+var (
+	diffHeader = lipgloss.NewStyle().Bold(true)
+	diffHunk   = lipgloss.NewStyle().Foreground(lipgloss.Color("#00d7ff"))
+	diffAdd    = lipgloss.NewStyle().Foreground(styles.Secondary)
+	diffDelete = lipgloss.NewStyle().Foreground(styles.Danger)
+)
+
+// DiffLines splits a unified diff into lines coloured by kind: file
+// headers, hunk headers, additions and deletions
+func DiffLines(unified string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(unified, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+			lines = append(lines, diffHeader.Render(line))
+		case strings.HasPrefix(line, "@@"):
+			lines = append(lines, diffHunk.Render(line))
+		case strings.HasPrefix(line, "+"):
+			lines = append(lines, diffAdd.Render(line))
+		case strings.HasPrefix(line, "-"):
+			lines = append(lines, diffDelete.Render(line))
+		default:
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -3,6 +3,7 @@ package views
 import (
 	"strings"
 
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 
@@ -21,6 +22,7 @@ const (
 	ViewStatus
 	ViewPull
 	ViewRestart
+	ViewHistory
 )
 
 // MenuItem represents a menu option
@@ -40,12 +42,17 @@ type AppModel struct {
 	width       int
 	height      int
 
+	// Questions from background tasks waiting for an answer
+	prompts     []promptMsg
+	promptInput textinput.Model
+
 	// Sub-views
 	listModel    ListModel
 	syncModel    SyncModel
 	statusModel  StatusModel
 	pullModel    PullModel
 	restartModel RestartModel
+	historyModel HistoryModel
 }
 
 // NewAppModel creates the main app with menu
@@ -61,6 +68,7 @@ func NewAppModelWithView(cfg *config.Config, initialView AppView) AppModel {
 		{title: "Sync (Dry Run)", description: "Preview sync without deploying", view: ViewSyncDryRun},
 		{title: "Status", description: "Check service health", view: ViewStatus},
 		{title: "Restart", description: "Restart rathole and caddy services", view: ViewRestart},
+		{title: "History", description: "Roll back to a previous deployment", view: ViewHistory},
 		{title: "Pull", description: "Download Caddyfile from server", view: ViewPull},
 		{title: "Exit", description: "Quit RCM", view: ViewMenu}, // Special: exit
 	}
@@ -89,11 +97,25 @@ func NewAppModelWithView(cfg *config.Config, initialView AppView) AppModel {
 		m.pullModel = NewPullModel(cfg)
 	case ViewRestart:
 		m.restartModel = NewRestartModel(cfg, true, true)
+	case ViewHistory:
+		m.historyModel = NewHistoryModel(cfg, "")
 	}
 
 	return m
 }
 
+// WithForce makes the sync view upload and restart even if nothing changed
+func (m AppModel) WithForce() AppModel {
+	m.syncModel.force = true
+	return m
+}
+
+// WithSnapshot preselects a snapshot in the history view
+func (m AppModel) WithSnapshot(id string) AppModel {
+	m.historyModel.selectID = id
+	return m
+}
+
 func (m AppModel) Init() tea.Cmd {
 	// If starting with a specific view, return its Init command
 	switch m.initialView {
@@ -107,6 +129,8 @@ func (m AppModel) Init() tea.Cmd {
 		return m.pullModel.Init()
 	case ViewRestart:
 		return m.restartModel.Init()
+	case ViewHistory:
+		return m.historyModel.Init()
 	}
 	return nil
 }
@@ -121,6 +145,18 @@ func (m AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.height = wsMsg.Height
 	}
 
+	// Questions from background tasks take over the keyboard until answered
+	if prompt, ok := msg.(promptMsg); ok {
+		m.prompts = append(m.prompts, prompt)
+		if len(m.prompts) == 1 {
+			m.promptInput = newSecretInput()
+		}
+		return m, nil
+	}
+	if keyMsg, ok := msg.(tea.KeyMsg); ok && len(m.prompts) > 0 {
+		return m.updatePrompt(keyMsg)
+	}
+
 	// Check for go back message from subviews
 	if _, ok := msg.(GoBackMsg); ok {
 		m.currentView = ViewMenu
@@ -156,6 +192,10 @@ func (m AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			model, c := m.restartModel.Update(msg)
 			m.restartModel = model.(RestartModel)
 			cmd = c
+		case ViewHistory:
+			model, c := m.historyModel.Update(msg)
+			m.historyModel = model.(HistoryModel)
+			cmd = c
 		}
 
 		return m, cmd
@@ -199,7 +239,51 @@ func (m AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// updatePrompt handles keys while a question is shown
+func (m AppModel) updatePrompt(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	prompt := m.prompts[0]
+
+	switch msg.String() {
+	case "ctrl+c":
+		for _, p := range m.prompts {
+			p.reply <- promptReply{}
+		}
+		m.prompts = nil
+		return m, tea.Quit
+	case "esc":
+		prompt.reply <- promptReply{}
+	case "enter":
+		if !prompt.secret {
+			return m, nil
+		}
+		prompt.reply <- promptReply{ok: true, value: m.promptInput.Value()}
+	case "y", "Y", "n", "N":
+		if prompt.secret {
+			var cmd tea.Cmd
+			m.promptInput, cmd = m.promptInput.Update(msg)
+			return m, cmd
+		}
+		prompt.reply <- promptReply{ok: msg.String() == "y" || msg.String() == "Y"}
+	default:
+		if prompt.secret {
+			var cmd tea.Cmd
+			m.promptInput, cmd = m.promptInput.Update(msg)
+			return m, cmd
+		}
+		return m, nil
+	}
+
+	// Answered - move on to the next question
+	m.prompts = m.prompts[1:]
+	m.promptInput = newSecretInput()
+	return m, nil
+}
+
 func (m AppModel) View() string {
+	if len(m.prompts) > 0 {
+		return renderPrompt(m.prompts[0], m.promptInput, m.width, m.height)
+	}
+
 	switch m.currentView {
 	case ViewMenu:
 		return m.renderMenu()
@@ -213,6 +297,8 @@ func (m AppModel) View() string {
 		return m.pullModel.View()
 	case ViewRestart:
 		return m.restartModel.View()
+	case ViewHistory:
+		return m.historyModel.View()
 	}
 	return ""
 }
@@ -307,6 +393,11 @@ func (m *AppModel) initSubView(view AppView) tea.Cmd {
 		m.restartModel.width = m.width
 		m.restartModel.height = m.height
 		return m.restartModel.Init()
+	case ViewHistory:
+		m.historyModel = NewHistoryModel(m.config, "")
+		m.historyModel.width = m.width
+		m.historyModel.height = m.height
+		return m.historyModel.Init()
 	}
 	return nil
 }
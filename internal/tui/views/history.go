@@ -0,0 +1,401 @@
+package views
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/engine"
+	"github.com/AhmedAburady/rcm-go/internal/tui/styles"
+)
+
+type historyPhase int
+
+const (
+	historyPhaseLoading historyPhase = iota
+	historyPhaseList
+	historyPhaseConfirm
+	historyPhaseRunning
+	historyPhaseComplete
+	historyPhaseFailed
+)
+
+// historyVisible is how many snapshots fit in the list at once
+const historyVisible = 8
+
+// HistoryModel is the Bubbletea model for the deployment history view
+type HistoryModel struct {
+	config      *config.Config
+	phase       historyPhase
+	spinner     spinner.Model
+	err         error
+	errFriendly string
+	width       int
+	height      int
+
+	snapshots []engine.Snapshot
+	cursor    int
+	selectID  string // Snapshot to select once the history is loaded
+	result    *engine.SyncResult
+
+	// Task status
+	validateStatus      taskStatus
+	uploadServerStatus  taskStatus
+	uploadClientStatus  taskStatus
+	recordStatus        taskStatus
+	restartServerStatus taskStatus
+	restartClientStatus taskStatus
+	restartCaddyStatus  taskStatus
+}
+
+type historyLoadedMsg struct {
+	snapshots []engine.Snapshot
+	err       error
+}
+
+type rollbackDoneMsg struct {
+	result *engine.SyncResult
+	err    error
+}
+
+// NewHistoryModel creates a new history view model. If selectID is set,
+// that snapshot is selected once the history is loaded.
+func NewHistoryModel(cfg *config.Config, selectID string) HistoryModel {
+	s := spinner.New()
+	s.Spinner = spinner.Dot
+	s.Style = lipgloss.NewStyle().Foreground(styles.Primary)
+
+	return HistoryModel{
+		config:   cfg,
+		phase:    historyPhaseLoading,
+		spinner:  s,
+		selectID: selectID,
+		width:    80,
+		height:   24,
+	}
+}
+
+// Init initializes the model
+func (m HistoryModel) Init() tea.Cmd {
+	return tea.Batch(m.spinner.Tick, loadHistoryCmd)
+}
+
+func loadHistoryCmd() tea.Msg {
+	snapshots, err := engine.History()
+	return historyLoadedMsg{snapshots: snapshots, err: err}
+}
+
+// Update handles messages
+func (m HistoryModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch m.phase {
+		case historyPhaseConfirm:
+			switch msg.String() {
+			case "y", "Y":
+				m.phase = historyPhaseRunning
+				return m, m.startRollback()
+			case "n", "N", "q", "esc":
+				m.phase = historyPhaseList
+			}
+			return m, nil
+
+		case historyPhaseRunning:
+			// Don't leave while machines are half deployed
+			return m, nil
+
+		case historyPhaseComplete, historyPhaseFailed:
+			if msg.String() == "q" || msg.String() == "esc" {
+				// Reload so the rollback shows up as the current deployment
+				m.phase = historyPhaseLoading
+				m.selectID = ""
+				return m, loadHistoryCmd
+			}
+			return m, nil
+		}
+
+		switch msg.String() {
+		case "q", "esc":
+			return m, func() tea.Msg { return GoBackMsg{} }
+		case "up", "k":
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case "down", "j":
+			if m.cursor < len(m.snapshots)-1 {
+				m.cursor++
+			}
+		case "enter":
+			if m.phase == historyPhaseList && len(m.snapshots) > 0 {
+				m.phase = historyPhaseConfirm
+			}
+		}
+
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+
+	case historyLoadedMsg:
+		m.phase = historyPhaseList
+		m.err = msg.err
+		m.snapshots = msg.snapshots
+		m.cursor = 0
+		if i := engine.PreviousDeployment(m.snapshots); i >= 0 {
+			m.cursor = i
+		}
+		for i, snap := range m.snapshots {
+			if snap.ID == m.selectID {
+				m.cursor = i
+			}
+		}
+		return m, nil
+
+	case engineEventMsg:
+		m.applyEvent(msg.event)
+		return m, msg.stream.next()
+
+	case rollbackDoneMsg:
+		if msg.err != nil {
+			m.phase = historyPhaseFailed
+			m.err = msg.err
+			m.errFriendly = friendlyError(msg.err, "Rollback failed")
+		} else {
+			m.phase = historyPhaseComplete
+			m.result = msg.result
+		}
+		return m, nil
+
+	case spinner.TickMsg:
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		cmds = append(cmds, cmd)
+	}
+
+	return m, tea.Batch(cmds...)
+}
+
+// applyEvent updates the task list from an engine progress event
+func (m *HistoryModel) applyEvent(ev engine.Event) {
+	status := toTaskStatus(ev.Status)
+
+	switch ev.Step {
+	case engine.StepValidate:
+		m.validateStatus = status
+	case engine.StepUpload:
+		switch ev.Target {
+		case engine.TargetServer:
+			m.uploadServerStatus = status
+		case engine.TargetClient:
+			m.uploadClientStatus = status
+		}
+	case engine.StepRecord:
+		m.recordStatus = status
+	case engine.StepRestart:
+		switch ev.Target {
+		case engine.TargetServer:
+			m.restartServerStatus = status
+		case engine.TargetClient:
+			m.restartClientStatus = status
+		case engine.TargetCaddy:
+			m.restartCaddyStatus = status
+		}
+	}
+}
+
+// startRollback deploys the selected snapshot in the background
+func (m *HistoryModel) startRollback() tea.Cmd {
+	m.validateStatus = taskPending
+	m.uploadServerStatus = taskPending
+	m.uploadClientStatus = taskPending
+	m.recordStatus = taskPending
+	m.restartServerStatus = taskPending
+	m.restartClientStatus = taskPending
+	m.restartCaddyStatus = taskPending
+	m.err = nil
+
+	cfg, snap := m.config, m.snapshots[m.cursor]
+	return startEngine(func(events chan<- engine.Event) tea.Msg {
+		result, err := engine.Rollback(cfg, &snap, engine.SyncOptions{}, events)
+		return rollbackDoneMsg{result: result, err: err}
+	})
+}
+
+// View renders the UI
+func (m HistoryModel) View() string {
+	var content string
+	switch m.phase {
+	case historyPhaseLoading:
+		content = fmt.Sprintf("%s Loading history...", m.spinner.View())
+	case historyPhaseList:
+		content = m.renderList()
+	case historyPhaseConfirm:
+		content = m.renderConfirm()
+	default:
+		content = m.renderProgress()
+	}
+
+	// Wrap in fixed-size box
+	box := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(styles.Border).
+		Padding(1, 3).
+		Width(100).
+		Height(20)
+
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, box.Render(content))
+}
+
+func (m HistoryModel) renderList() string {
+	var lines []string
+
+	lines = append(lines, styles.WindowTitle.Render("Deployment History"))
+	lines = append(lines, "")
+
+	if m.err != nil {
+		lines = append(lines, styles.Error.Render("  Couldn't load history: "+m.err.Error()))
+	} else if len(m.snapshots) == 0 {
+		lines = append(lines, styles.Dimmed.Render("  No deployments recorded yet. Every sync records one."))
+	} else {
+		// Scroll so the cursor stays visible
+		start := 0
+		if m.cursor >= historyVisible {
+			start = m.cursor - historyVisible + 1
+		}
+		end := min(start+historyVisible, len(m.snapshots))
+
+		for i := start; i < end; i++ {
+			snap := m.snapshots[i]
+			line := fmt.Sprintf("%s  %s  %d services",
+				snap.ID, snap.Time.Local().Format("2006-01-02 15:04"), len(snap.Services))
+			if snap.RollbackOf != "" {
+				line += "  (rollback to " + snap.RollbackOf + ")"
+			}
+			if i == 0 {
+				line += "  (current)"
+			}
+
+			if i == m.cursor {
+				lines = append(lines, lipgloss.NewStyle().
+					Foreground(styles.White).
+					Background(styles.Primary).
+					Bold(true).
+					Width(90).
+					Render("  "+line))
+			} else {
+				lines = append(lines, "  "+line)
+			}
+		}
+	}
+
+	lines = append(lines, "")
+	lines = append(lines, styles.Dimmed.Render("↑/↓: navigate  Enter: roll back  ESC: back"))
+
+	return strings.Join(lines, "\n")
+}
+
+func (m HistoryModel) renderConfirm() string {
+	snap := m.snapshots[m.cursor]
+	var lines []string
+
+	lines = append(lines, styles.WindowTitle.Render("Roll Back"))
+	lines = append(lines, "")
+	lines = append(lines, fmt.Sprintf("  Snapshot:  %s", snap.ID))
+	lines = append(lines, fmt.Sprintf("  Deployed:  %s", snap.Time.Local().Format("2006-01-02 15:04:05")))
+	lines = append(lines, fmt.Sprintf("  Server:    %s", snap.ServerHost))
+	lines = append(lines, fmt.Sprintf("  Client:    %s", snap.ClientHost))
+	lines = append(lines, fmt.Sprintf("  Services:  %s", strings.Join(snap.Services, ", ")))
+	lines = append(lines, "")
+
+	if snap.ServerHost != m.config.Server.Host || snap.ClientHost != m.config.ClientHosts() {
+		lines = append(lines, styles.WarningText.Render("  ⚠ Recorded for different hosts than the current config"))
+		lines = append(lines, "")
+	}
+
+	lines = append(lines, fmt.Sprintf("  Re-deploy this snapshot and restart services? Press %s to confirm, %s to cancel",
+		styles.KeyStyle.Render("y"),
+		styles.KeyStyle.Render("n")))
+
+	return strings.Join(lines, "\n")
+}
+
+func (m HistoryModel) renderProgress() string {
+	var lines []string
+
+	var title string
+	switch m.phase {
+	case historyPhaseComplete:
+		title = "Rollback Complete"
+	case historyPhaseFailed:
+		title = "Rollback Failed"
+	default:
+		title = "Rolling Back to " + m.snapshots[m.cursor].ID
+	}
+	lines = append(lines, styles.WindowTitle.Render(title))
+	lines = append(lines, "")
+
+	lines = append(lines, styles.Dimmed.Render("  Deploy"))
+	if validatesCaddyfile(m.config) {
+		lines = append(lines, m.renderTask("  Validate Caddyfile", m.validateStatus))
+	}
+	lines = append(lines, m.renderTask("  Upload server", m.uploadServerStatus))
+	lines = append(lines, m.renderTask("  Upload client", m.uploadClientStatus))
+	lines = append(lines, m.renderTask("  Record snapshot", m.recordStatus))
+	lines = append(lines, "")
+	lines = append(lines, styles.Dimmed.Render("  Restart"))
+	lines = append(lines, m.renderTask("  Rathole server", m.restartServerStatus))
+	lines = append(lines, m.renderTask("  Rathole client", m.restartClientStatus))
+	if m.config.Server.CaddyComposeDir != "" {
+		lines = append(lines, m.renderTask("  Caddy", m.restartCaddyStatus))
+	}
+
+	if m.phase == historyPhaseFailed && m.err != nil {
+		lines = append(lines, "")
+		lines = append(lines, styles.Error.Render("  "+m.errFriendly)+hostKeyHint(m.err)+detailsHint(m.err))
+	}
+	if m.phase == historyPhaseComplete {
+		lines = append(lines, "")
+		if m.result != nil && !m.result.Changes.Any() {
+			lines = append(lines, styles.Success.Render("  ✓ "+m.snapshots[m.cursor].ID+" is already deployed"))
+		} else {
+			lines = append(lines, styles.Success.Render("  ✓ Rolled back to "+m.snapshots[m.cursor].ID))
+		}
+	}
+
+	if m.phase != historyPhaseRunning {
+		lines = append(lines, "")
+		lines = append(lines, styles.Dimmed.Render("ESC go back"))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (m HistoryModel) renderTask(name string, status taskStatus) string {
+	var icon string
+	var text string
+
+	switch status {
+	case taskDone:
+		icon = styles.CheckMark()
+		text = name
+	case taskRunning:
+		icon = m.spinner.View()
+		text = name
+	case taskFailed:
+		icon = styles.CrossMark()
+		text = styles.Error.Render(name)
+	case taskUnchanged:
+		icon = styles.Dimmed.Render("–")
+		text = styles.Dimmed.Render(name + " (unchanged)")
+	default: // taskPending
+		icon = styles.Dimmed.Render("○")
+		text = styles.Dimmed.Render(name)
+	}
+
+	return fmt.Sprintf("  %s %s", icon, text)
+}
@@ -2,7 +2,6 @@ package views
 
 import (
 	"fmt"
-	"sort"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/spinner"
@@ -11,8 +10,7 @@ import (
 	"github.com/charmbracelet/lipgloss/table"
 
 	"github.com/AhmedAburady/rcm-go/internal/config"
-	"github.com/AhmedAburady/rcm-go/internal/parser"
-	"github.com/AhmedAburady/rcm-go/internal/ssh"
+	"github.com/AhmedAburady/rcm-go/internal/engine"
 	"github.com/AhmedAburady/rcm-go/internal/tui/styles"
 )
 
@@ -25,21 +23,11 @@ const (
 	ListStateError
 )
 
-// ServiceRow represents a merged service from local and remote
-type ServiceRow struct {
-	Name      string
-	LocalAddr string
-	VPSPort   int
-	Domains   []string
-	IsLocal   bool
-	IsRemote  bool
-}
-
 // ListModel is the Bubbletea model for the list view
 type ListModel struct {
 	state       ListState
 	config      *config.Config
-	services    []ServiceRow
+	services    []engine.ServiceRow
 	spinner     spinner.Model
 	err         error
 	width       int
@@ -49,7 +37,7 @@ type ListModel struct {
 }
 
 type servicesLoadedMsg struct {
-	services []ServiceRow
+	services []engine.ServiceRow
 }
 
 type listErrMsg struct {
@@ -171,6 +159,9 @@ func (m ListModel) renderTable() string {
 	for i, svc := range m.services {
 		// Get domains - show all joined by comma
 		domains := strings.Join(svc.Domains, ", ")
+		if domains == "" {
+			domains = "-" // Raw service, without a Caddy site
+		}
 
 		// Status checkmarks
 		localStatus := styles.CrossMark()
@@ -185,7 +176,9 @@ func (m ListModel) renderTable() string {
 		rows[i] = []string{
 			svc.Name,
 			svc.LocalAddr,
+			svc.Client,
 			fmt.Sprintf("%d", svc.VPSPort),
+			svc.Protocol,
 			domains,
 			localStatus,
 			remoteStatus,
@@ -198,7 +191,7 @@ func (m ListModel) renderTable() string {
 	t := table.New().
 		Border(lipgloss.RoundedBorder()).
 		BorderStyle(lipgloss.NewStyle().Foreground(styles.Border)).
-		Headers("Service", "Local Address", "VPS Port", "Domains", "Local", "Remote").
+		Headers("Service", "Local Address", "Client", "VPS Port", "Proto", "Domains", "Local", "Remote").
 		Rows(rows...).
 		StyleFunc(func(row, col int) lipgloss.Style {
 			// Base style with padding
@@ -223,11 +216,15 @@ func (m ListModel) renderTable() string {
 				return base.Foreground(lipgloss.Color("#00d7ff")) // cyan
 			case 1: // Local Address
 				return base.Foreground(lipgloss.Color("#00ff87")) // green
-			case 2: // VPS Port
+			case 2: // Client
+				return base.Foreground(lipgloss.Color("#cccccc"))
+			case 3: // VPS Port
 				return base.Foreground(lipgloss.Color("#ffff00")) // yellow
-			case 3: // Domains
+			case 4: // Protocol
+				return base.Foreground(lipgloss.Color("#cccccc"))
+			case 5: // Domains
 				return base.Foreground(lipgloss.Color("#87afff")) // blue
-			case 4, 5: // Local/Remote
+			case 6, 7: // Local/Remote
 				return base.Foreground(lipgloss.Color("#ffffff")).Align(lipgloss.Center)
 			}
 			return base.Foreground(lipgloss.Color("#cccccc"))
@@ -237,78 +234,12 @@ func (m ListModel) renderTable() string {
 }
 
 func (m ListModel) loadServicesCmd() tea.Cmd {
+	cfg := m.config
 	return func() tea.Msg {
-		// Fetch local services
-		localServices := make(map[string]parser.Service)
-		if m.config.Paths.Caddyfile != "" {
-			services, err := parser.ParseFile(m.config.Paths.Caddyfile)
-			if err == nil {
-				for _, svc := range services {
-					localServices[svc.Name] = svc
-				}
-			}
-		}
-
-		// Fetch remote services
-		remoteServices := make(map[string]parser.Service)
-		if m.config.Server.Host != "" && m.config.Server.Caddyfile != "" {
-			client, err := ssh.GetClient(m.config.Server.Host, m.config.Server.User, m.config.Server.SSHKey)
-			if err == nil {
-				// Don't close - connection is pooled and reused
-				content, err := client.DownloadFile(m.config.Server.Caddyfile)
-				if err == nil {
-					services, err := parser.ParseContent(content)
-					if err == nil {
-						for _, svc := range services {
-							remoteServices[svc.Name] = svc
-						}
-					}
-				}
-			}
-		}
-
-		// Merge all service names
-		allNames := make(map[string]bool)
-		for name := range localServices {
-			allNames[name] = true
-		}
-		for name := range remoteServices {
-			allNames[name] = true
+		rows, err := engine.Services(cfg)
+		if err != nil {
+			return listErrMsg{err: err}
 		}
-
-		if len(allNames) == 0 {
-			return listErrMsg{err: fmt.Errorf("no services found")}
-		}
-
-		// Build merged service list
-		var result []ServiceRow
-		for name := range allNames {
-			localSvc, isLocal := localServices[name]
-			remoteSvc, isRemote := remoteServices[name]
-
-			// Use whichever exists for data
-			var svc parser.Service
-			if isLocal {
-				svc = localSvc
-			} else {
-				svc = remoteSvc
-			}
-
-			result = append(result, ServiceRow{
-				Name:      svc.Name,
-				LocalAddr: svc.LocalAddr,
-				VPSPort:   svc.VPSPort,
-				Domains:   svc.Domains,
-				IsLocal:   isLocal,
-				IsRemote:  isRemote,
-			})
-		}
-
-		// Sort by name
-		sort.Slice(result, func(i, j int) bool {
-			return result[i].Name < result[j].Name
-		})
-
-		return servicesLoadedMsg{services: result}
+		return servicesLoadedMsg{services: rows}
 	}
 }
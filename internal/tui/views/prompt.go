@@ -0,0 +1,140 @@
+package views
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/AhmedAburady/rcm-go/internal/ssh"
+	"github.com/AhmedAburady/rcm-go/internal/tui/styles"
+)
+
+// promptMsg asks the user a question on behalf of a background task
+type promptMsg struct {
+	question string
+	secret   bool // Ask for hidden text instead of yes/no
+	reply    chan promptReply
+}
+
+type promptReply struct {
+	ok    bool
+	value string
+}
+
+// Prompter answers SSH questions (such as trusting an unknown host key)
+// inside the running TUI. It implements ssh.Prompter.
+type Prompter struct {
+	program *tea.Program
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewPrompter creates a prompter that asks questions in the given program
+func NewPrompter(p *tea.Program) *Prompter {
+	return &Prompter{
+		program: p,
+		done:    make(chan struct{}),
+	}
+}
+
+// Confirm shows the question in the TUI and waits for the answer
+func (p *Prompter) Confirm(question string) (bool, error) {
+	r, err := p.ask(promptMsg{question: question})
+	return r.ok, err
+}
+
+// Secret asks for hidden text, such as a key passphrase, in the TUI
+func (p *Prompter) Secret(prompt string) (string, error) {
+	r, err := p.ask(promptMsg{question: prompt, secret: true})
+	if err != nil {
+		return "", err
+	}
+	if !r.ok {
+		return "", errors.New("passphrase prompt cancelled")
+	}
+	return r.value, nil
+}
+
+func (p *Prompter) ask(msg promptMsg) (promptReply, error) {
+	msg.reply = make(chan promptReply, 1)
+	go p.program.Send(msg)
+
+	select {
+	case r := <-msg.reply:
+		return r, nil
+	case <-p.done:
+		return promptReply{}, errors.New("prompt cancelled: TUI exited")
+	}
+}
+
+// Close releases any question still waiting for an answer. Call it once
+// the program has exited.
+func (p *Prompter) Close() {
+	p.once.Do(func() { close(p.done) })
+}
+
+// newSecretInput creates the masked input used for secret prompts
+func newSecretInput() textinput.Model {
+	input := textinput.New()
+	input.EchoMode = textinput.EchoPassword
+	input.EchoCharacter = '•'
+	input.Prompt = "  > "
+	input.Focus()
+	return input
+}
+
+// renderPrompt renders a pending question as a centered dialog
+func renderPrompt(prompt promptMsg, input textinput.Model, width, height int) string {
+	var lines []string
+
+	title := "Confirm"
+	if prompt.secret {
+		title = "Passphrase Required"
+	}
+	lines = append(lines, styles.WindowTitle.Render(title))
+	lines = append(lines, "")
+	for _, line := range strings.Split(prompt.question, "\n") {
+		lines = append(lines, "  "+line)
+	}
+	lines = append(lines, "")
+	if prompt.secret {
+		lines = append(lines, input.View())
+		lines = append(lines, "")
+		lines = append(lines, styles.Dimmed.Render("  Enter submit  ESC cancel"))
+	} else {
+		lines = append(lines, fmt.Sprintf("  Press %s to accept, %s to reject",
+			styles.KeyStyle.Render("y"),
+			styles.KeyStyle.Render("n")))
+	}
+
+	box := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(styles.Warning).
+		Padding(1, 3).
+		Width(100)
+
+	return lipgloss.Place(width, height, lipgloss.Center, lipgloss.Center, box.Render(strings.Join(lines, "\n")))
+}
+
+// hostKeyHint returns extra detail for host key errors, which need the
+// fingerprints to be actionable
+func hostKeyHint(err error) string {
+	var mismatch *ssh.HostKeyError
+	var unknown *ssh.UnknownHostError
+	switch {
+	case errors.As(err, &mismatch):
+		var lines []string
+		for _, line := range strings.Split(mismatch.Error(), "\n") {
+			lines = append(lines, styles.Dimmed.Render("  "+line))
+		}
+		return "\n" + strings.Join(lines, "\n")
+	case errors.As(err, &unknown):
+		return "\n" + styles.Dimmed.Render("  Hint: Host key not trusted - rerun with --accept-new-host-key")
+	}
+	return ""
+}
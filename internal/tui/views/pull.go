@@ -3,7 +3,6 @@ package views
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/spinner"
@@ -12,8 +11,8 @@ import (
 	"github.com/charmbracelet/lipgloss/table"
 
 	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/engine"
 	"github.com/AhmedAburady/rcm-go/internal/parser"
-	"github.com/AhmedAburady/rcm-go/internal/ssh"
 	"github.com/AhmedAburady/rcm-go/internal/tui/styles"
 )
 
@@ -39,21 +38,18 @@ type PullModel struct {
 	width   int
 	height  int
 
-	// Downloaded content
-	remoteCaddyfile string
-	services        []parser.Service
-	localExists     bool
-}
+	// Step that was running when the pull failed
+	failedStep pullStep
 
-type pullStepCompleteMsg struct {
-	step            pullStep
-	log             string
-	remoteCaddyfile string           // Content from download step
-	services        []parser.Service // Parsed services
+	// Downloaded content
+	services    []parser.Service
+	imports     []string
+	localExists bool
 }
 
-type pullErrMsg struct {
-	err error
+type pullDoneMsg struct {
+	result *engine.PullResult
+	err    error
 }
 
 // NewPullModel creates a new pull view model
@@ -94,7 +90,7 @@ func (m PullModel) Init() tea.Cmd {
 	// Otherwise start the pull operation
 	return tea.Batch(
 		m.spinner.Tick,
-		m.runStep(pullStepConnecting),
+		m.startPull(),
 	)
 }
 
@@ -113,7 +109,7 @@ func (m PullModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			// Confirm overwrite and start pull
 			if m.step == pullStepConfirm {
 				m.step = pullStepConnecting
-				return m, m.runStep(pullStepConnecting)
+				return m, m.startPull()
 			}
 		case "n", "N":
 			// Cancel on confirm step
@@ -126,26 +122,20 @@ func (m PullModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.width = msg.Width
 		m.height = msg.Height
 
-	case pullStepCompleteMsg:
-		m.logs = append(m.logs, msg.log)
-		m.step = msg.step + 1
+	case engineEventMsg:
+		m.applyEvent(msg.event)
+		return m, msg.stream.next()
 
-		// Store data from message onto model
-		if msg.remoteCaddyfile != "" {
-			m.remoteCaddyfile = msg.remoteCaddyfile
+	case pullDoneMsg:
+		if msg.err != nil {
+			m.failedStep = m.step
+			m.step = pullStepFailed
+			m.err = msg.err
+			return m, nil
 		}
-		if msg.services != nil {
-			m.services = msg.services
-		}
-
-		if m.step < pullStepComplete {
-			return m, m.runStep(m.step)
-		}
-		return m, nil
-
-	case pullErrMsg:
-		m.step = pullStepFailed
-		m.err = msg.err
+		m.step = pullStepComplete
+		m.services = msg.result.Services
+		m.imports = msg.result.Imports
 		return m, nil
 
 	case spinner.TickMsg:
@@ -197,6 +187,9 @@ func (m PullModel) View() string {
 		lines = append(lines, m.renderServicesTable())
 		lines = append(lines, "")
 		lines = append(lines, styles.Success.Render(fmt.Sprintf("  Saved to %s", m.config.Paths.Caddyfile)))
+		if len(m.imports) > 0 {
+			lines = append(lines, styles.Dimmed.Render(fmt.Sprintf("  with the %d files it imports", len(m.imports))))
+		}
 	}
 
 	// Error message if failed
@@ -238,15 +231,20 @@ func (m PullModel) renderProgressTable() string {
 		var icon string
 		var status string
 
-		if m.step > s.step {
+		current := m.step
+		if current == pullStepFailed {
+			current = m.failedStep
+		}
+
+		if m.step == pullStepFailed && s.step == m.failedStep {
+			icon = styles.CrossMark()
+			status = "Failed"
+		} else if current > s.step {
 			icon = styles.CheckMark()
 			status = "Done"
 		} else if m.step == s.step && m.step < pullStepComplete {
 			icon = m.spinner.View()
 			status = "Running"
-		} else if m.step == pullStepFailed && s.step == m.step-1 {
-			icon = styles.CrossMark()
-			status = "Failed"
 		} else {
 			icon = styles.Dimmed.Render("○")
 			status = "Pending"
@@ -330,83 +328,33 @@ func (m PullModel) renderServicesTable() string {
 }
 
 func (m PullModel) renderErrorBox() string {
-	errMsg := m.err.Error()
-	friendly := "Pull failed"
+	return styles.Error.Render("  "+friendlyError(m.err, "Pull failed")) + hostKeyHint(m.err)
+}
 
-	if strings.Contains(errMsg, "No such file") {
-		friendly = "Caddyfile not found on server"
-	} else if strings.Contains(errMsg, "connect") {
-		friendly = "Couldn't connect to server"
+// applyEvent advances the progress table from an engine progress event
+func (m *PullModel) applyEvent(ev engine.Event) {
+	if ev.Status != engine.StatusDone {
+		return
+	}
+	if ev.Message != "" {
+		m.logs = append(m.logs, ev.Message)
 	}
 
-	return styles.Error.Render("  " + friendly)
-}
-
-func expandTildePull(path string) string {
-	if len(path) > 0 && path[0] == '~' {
-		home, _ := os.UserHomeDir()
-		return home + path[1:]
+	switch ev.Step {
+	case engine.StepConnect:
+		m.step = pullStepDownloading
+	case engine.StepDownload:
+		m.step = pullStepParsing
+	case engine.StepParse:
+		m.step = pullStepSaving
 	}
-	return path
 }
 
-// runStep executes the current pull step
-func (m *PullModel) runStep(step pullStep) tea.Cmd {
-	return func() tea.Msg {
-		switch step {
-		case pullStepConnecting:
-			// Connect and download in one step to reduce SSH connections
-			client, err := ssh.GetClient(m.config.Server.Host, m.config.Server.User, m.config.Server.SSHKey)
-			if err != nil {
-				return pullErrMsg{err: fmt.Errorf("connect to server: %w", err)}
-			}
-			// Don't close - connection is pooled and reused
-
-			content, err := client.DownloadFile(m.config.Server.Caddyfile)
-			if err != nil {
-				return pullErrMsg{err: fmt.Errorf("download Caddyfile: %w", err)}
-			}
-			// Skip directly to downloading complete (we did both steps)
-			return pullStepCompleteMsg{
-				step:            pullStepDownloading, // Report as downloading complete
-				log:             fmt.Sprintf("Downloaded from %s (%d bytes)", m.config.Server.Host, len(content)),
-				remoteCaddyfile: content,
-			}
-
-		case pullStepDownloading:
-			// This step is now handled by pullStepConnecting
-			return pullStepCompleteMsg{step: step, log: "Already downloaded"}
-
-		case pullStepParsing:
-			services, err := parser.ParseContent(m.remoteCaddyfile)
-			if err != nil {
-				return pullErrMsg{err: fmt.Errorf("parse Caddyfile: %w", err)}
-			}
-			// Pass services through message
-			return pullStepCompleteMsg{
-				step:     step,
-				log:      fmt.Sprintf("Found %d services", len(services)),
-				services: services,
-			}
-
-		case pullStepSaving:
-			// Expand tilde in path
-			savePath := expandTildePull(m.config.Paths.Caddyfile)
-
-			// Ensure directory exists
-			dir := filepath.Dir(savePath)
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return pullErrMsg{err: fmt.Errorf("create config dir: %w", err)}
-			}
-
-			// Write the file
-			if err := os.WriteFile(savePath, []byte(m.remoteCaddyfile), 0644); err != nil {
-				return pullErrMsg{err: fmt.Errorf("save Caddyfile: %w", err)}
-			}
-
-			return pullStepCompleteMsg{step: step, log: "Saved"}
-		}
-
-		return pullStepCompleteMsg{step: step, log: "Step completed"}
-	}
+// startPull downloads, parses and saves the Caddyfile in the background
+func (m PullModel) startPull() tea.Cmd {
+	cfg := m.config
+	return startEngine(func(events chan<- engine.Event) tea.Msg {
+		result, err := engine.Pull(cfg, events)
+		return pullDoneMsg{result: result, err: err}
+	})
 }
@@ -9,7 +9,7 @@ import (
 	"github.com/charmbracelet/lipgloss"
 
 	"github.com/AhmedAburady/rcm-go/internal/config"
-	"github.com/AhmedAburady/rcm-go/internal/ssh"
+	"github.com/AhmedAburady/rcm-go/internal/engine"
 	"github.com/AhmedAburady/rcm-go/internal/tui/styles"
 )
 
@@ -57,23 +57,7 @@ type RestartModel struct {
 }
 
 type restartDoneMsg struct {
-	err               error
-	friendly          string
-	serverRatholeDone bool
-	serverCaddyDone   bool
-	clientRatholeDone bool
-	failedTask        string // which task failed
-}
-
-type restartTaskUpdateMsg struct {
-	task   string // "serverRathole", "serverCaddy", "clientRathole"
-	status taskStatus
-}
-
-func sendTaskUpdate(task string, status taskStatus) tea.Cmd {
-	return func() tea.Msg {
-		return restartTaskUpdateMsg{task: task, status: status}
-	}
+	err error
 }
 
 // NewRestartModel creates a new restart view model
@@ -121,7 +105,7 @@ func (m RestartModel) optionDescription(opt restartOption) string {
 	case restartOptRatholeServer:
 		return fmt.Sprintf("Restart rathole-server on %s", m.config.Server.Host)
 	case restartOptRatholeClient:
-		return fmt.Sprintf("Restart rathole-client on %s", m.config.Client.Host)
+		return fmt.Sprintf("Restart rathole-client on %s", m.config.ClientHosts())
 	case restartOptCaddy:
 		return fmt.Sprintf("Restart Caddy (docker) on %s", m.config.Server.Host)
 	case restartOptAll:
@@ -199,45 +183,15 @@ func (m RestartModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.width = msg.Width
 		m.height = msg.Height
 
-	case restartTaskUpdateMsg:
-		switch msg.task {
-		case "serverRathole":
-			m.serverRatholeStatus = msg.status
-		case "serverCaddy":
-			m.serverCaddyStatus = msg.status
-		case "clientRathole":
-			m.clientRatholeStatus = msg.status
-		}
-		return m, nil
+	case engineEventMsg:
+		m.applyEvent(msg.event)
+		return m, msg.stream.next()
 
 	case restartDoneMsg:
-		// Update individual task statuses based on what completed
-		if msg.serverRatholeDone {
-			m.serverRatholeStatus = taskDone
-		}
-		if msg.serverCaddyDone {
-			m.serverCaddyStatus = taskDone
-		}
-		if msg.clientRatholeDone {
-			m.clientRatholeStatus = taskDone
-		}
-
-		// Mark failed task
-		if msg.failedTask != "" {
-			switch msg.failedTask {
-			case "serverRathole":
-				m.serverRatholeStatus = taskFailed
-			case "serverCaddy":
-				m.serverCaddyStatus = taskFailed
-			case "clientRathole":
-				m.clientRatholeStatus = taskFailed
-			}
-		}
-
 		if msg.err != nil {
 			m.phase = restartPhaseFailed
 			m.err = msg.err
-			m.errFriendly = msg.friendly
+			m.errFriendly = friendlyError(msg.err, "Restart failed")
 		} else {
 			m.phase = restartPhaseComplete
 		}
@@ -358,7 +312,7 @@ func (m RestartModel) renderProgress() string {
 
 	// Error message
 	if m.phase == restartPhaseFailed && m.err != nil {
-		lines = append(lines, styles.Error.Render("  "+m.errFriendly))
+		lines = append(lines, styles.Error.Render("  "+m.errFriendly)+hostKeyHint(m.err))
 	}
 
 	// Success message
@@ -405,112 +359,32 @@ func (m RestartModel) renderTask(name string, status taskStatus) string {
 	return fmt.Sprintf("  %s %s", icon, text)
 }
 
-func (m RestartModel) startRestart() tea.Cmd {
-	// Set initial running states and start the chain
-	var cmds []tea.Cmd
-
-	if m.restartRatholeServer {
-		cmds = append(cmds, sendTaskUpdate("serverRathole", taskRunning))
+// applyEvent updates the task list from an engine progress event
+func (m *RestartModel) applyEvent(ev engine.Event) {
+	if ev.Step != engine.StepRestart {
+		return
 	}
-	if m.restartCaddy {
-		cmds = append(cmds, sendTaskUpdate("serverCaddy", taskRunning))
+	status := toTaskStatus(ev.Status)
+
+	switch ev.Target {
+	case engine.TargetServer:
+		m.serverRatholeStatus = status
+	case engine.TargetCaddy:
+		m.serverCaddyStatus = status
+	case engine.TargetClient:
+		m.clientRatholeStatus = status
 	}
-	if m.restartRatholeClient {
-		cmds = append(cmds, sendTaskUpdate("clientRathole", taskRunning))
-	}
-
-	// Start the actual restart process
-	cmds = append(cmds, m.doRestart())
-
-	return tea.Batch(cmds...)
 }
 
-func (m RestartModel) doRestart() tea.Cmd {
-	return func() tea.Msg {
-		var done restartDoneMsg
-
-		// Restart server services
-		if m.restartRatholeServer || m.restartCaddy {
-			client, err := ssh.GetClient(m.config.Server.Host, m.config.Server.User, m.config.Server.SSHKey)
-			if err != nil {
-				done.err = err
-				done.friendly = fmt.Sprintf("Couldn't connect to server (%s)", m.config.Server.Host)
-				if m.restartRatholeServer {
-					done.failedTask = "serverRathole"
-				} else {
-					done.failedTask = "serverCaddy"
-				}
-				return done
-			}
-			// Don't close - connection is pooled and reused
-
-			// Restart rathole-server
-			if m.restartRatholeServer {
-				if err := client.RestartService("rathole-server"); err != nil {
-					done.err = err
-					done.friendly = "Couldn't restart rathole-server"
-					done.failedTask = "serverRathole"
-					return done
-				}
-				// Verify service is running
-				running, status, _ := client.GetServiceStatus("rathole-server")
-				if !running {
-					done.err = fmt.Errorf("service not running: %s", status)
-					done.friendly = fmt.Sprintf("rathole-server failed to start (%s)", status)
-					done.failedTask = "serverRathole"
-					return done
-				}
-				done.serverRatholeDone = true
-			}
-
-			// Restart caddy if selected
-			if m.restartCaddy {
-				if err := client.RestartDockerCompose(m.config.Server.CaddyComposeDir); err != nil {
-					done.err = err
-					done.friendly = "Couldn't restart Caddy"
-					done.failedTask = "serverCaddy"
-					return done
-				}
-				// Verify container is running
-				running, status, _ := client.GetDockerComposeStatus(m.config.Server.CaddyComposeDir)
-				if !running {
-					done.err = fmt.Errorf("container not running: %s", status)
-					done.friendly = fmt.Sprintf("Caddy failed to start (%s)", status)
-					done.failedTask = "serverCaddy"
-					return done
-				}
-				done.serverCaddyDone = true
-			}
-		}
-
-		// Restart client services
-		if m.restartRatholeClient {
-			client, err := ssh.GetClient(m.config.Client.Host, m.config.Client.User, m.config.Client.SSHKey)
-			if err != nil {
-				done.err = err
-				done.friendly = fmt.Sprintf("Couldn't connect to client (%s)", m.config.Client.Host)
-				done.failedTask = "clientRathole"
-				return done
-			}
-			// Don't close - connection is pooled and reused
-
-			if err := client.RestartService("rathole-client"); err != nil {
-				done.err = err
-				done.friendly = "Couldn't restart rathole-client"
-				done.failedTask = "clientRathole"
-				return done
-			}
-			// Verify service is running
-			running, status, _ := client.GetServiceStatus("rathole-client")
-			if !running {
-				done.err = fmt.Errorf("service not running: %s", status)
-				done.friendly = fmt.Sprintf("rathole-client failed to start (%s)", status)
-				done.failedTask = "clientRathole"
-				return done
-			}
-			done.clientRatholeDone = true
-		}
-
-		return done
+// startRestart restarts the selected services in the background
+func (m RestartModel) startRestart() tea.Cmd {
+	cfg := m.config
+	opts := engine.RestartOptions{
+		Server: m.restartRatholeServer,
+		Client: m.restartRatholeClient,
+		Caddy:  m.restartCaddy,
 	}
+	return startEngine(func(events chan<- engine.Event) tea.Msg {
+		return restartDoneMsg{err: engine.Restart(cfg, opts, events)}
+	})
 }
@@ -10,7 +10,7 @@ import (
 	"github.com/charmbracelet/lipgloss/table"
 
 	"github.com/AhmedAburady/rcm-go/internal/config"
-	"github.com/AhmedAburady/rcm-go/internal/ssh"
+	"github.com/AhmedAburady/rcm-go/internal/engine"
 	"github.com/AhmedAburady/rcm-go/internal/tui/styles"
 )
 
@@ -23,26 +23,12 @@ const (
 	StatusStateError
 )
 
-// ServiceHealth holds health information for a service
-type ServiceHealth struct {
-	Name    string
-	Running bool
-	Status  string
-}
-
-// MachineStatus holds status for a machine
-type MachineStatus struct {
-	Host     string
-	Online   bool
-	Services []ServiceHealth
-}
-
 // StatusModel is the Bubbletea model for the status view
 type StatusModel struct {
 	state    StatusState
 	config   *config.Config
-	server   MachineStatus
-	client   MachineStatus
+	server   engine.MachineStatus
+	clients  []engine.MachineStatus
 	spinner  spinner.Model
 	err      error
 	width    int
@@ -51,8 +37,7 @@ type StatusModel struct {
 }
 
 type statusLoadedMsg struct {
-	server MachineStatus
-	client MachineStatus
+	report *engine.StatusReport
 }
 
 type statusErrMsg struct {
@@ -107,8 +92,8 @@ func (m StatusModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case statusLoadedMsg:
 		m.state = StatusStateReady
-		m.server = msg.server
-		m.client = msg.client
+		m.server = msg.report.Server
+		m.clients = msg.report.Clients
 		return m, nil
 
 	case statusErrMsg:
@@ -143,8 +128,22 @@ func (m StatusModel) View() string {
 		// Server table
 		lines = append(lines, m.renderMachineTable("Server", m.server))
 		lines = append(lines, "")
-		// Client table
-		lines = append(lines, m.renderMachineTable("Client", m.client))
+		// Client tables
+		for i, client := range m.clients {
+			if i > 0 {
+				lines = append(lines, "")
+			}
+			name := "Client"
+			if client.Name != "" {
+				name += " " + client.Name
+			}
+			lines = append(lines, m.renderMachineTable(name, client))
+		}
+		for _, machine := range append([]engine.MachineStatus{m.server}, m.clients...) {
+			if hint := hostKeyHint(machine.Err); hint != "" {
+				lines = append(lines, hint)
+			}
+		}
 	}
 
 	// Help text
@@ -164,7 +163,7 @@ func (m StatusModel) View() string {
 	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, box.Render(content))
 }
 
-func (m StatusModel) renderMachineTable(name string, status MachineStatus) string {
+func (m StatusModel) renderMachineTable(name string, status engine.MachineStatus) string {
 	// Online/offline indicator for header
 	onlineIcon := styles.CheckMark()
 	onlineText := "Online"
@@ -205,7 +204,7 @@ func (m StatusModel) renderMachineTable(name string, status MachineStatus) strin
 			case 0: // Icon
 				return base.Width(3)
 			case 1: // Service name
-				return base.Foreground(lipgloss.Color("#00d7ff")).Width(20)
+				return base.Foreground(lipgloss.Color("#00d7ff")).Width(30)
 			case 2: // Status
 				if len(rows) > row && !status.Online {
 					return base.Foreground(styles.Danger)
@@ -229,71 +228,8 @@ func (m StatusModel) renderMachineTable(name string, status MachineStatus) strin
 
 // loadStatusCmd creates a command to load status
 func (m StatusModel) loadStatusCmd() tea.Cmd {
+	cfg := m.config
 	return func() tea.Msg {
-		serverStatus := m.checkMachine(
-			m.config.Server.Host,
-			m.config.Server.User,
-			m.config.Server.SSHKey,
-			[]string{"rathole-server"},
-			m.config.Server.CaddyComposeDir,
-		)
-
-		clientStatus := m.checkMachine(
-			m.config.Client.Host,
-			m.config.Client.User,
-			m.config.Client.SSHKey,
-			[]string{"rathole-client"},
-			"",
-		)
-
-		return statusLoadedMsg{
-			server: serverStatus,
-			client: clientStatus,
-		}
-	}
-}
-
-func (m StatusModel) checkMachine(host, user, keyPath string, services []string, composeDir string) MachineStatus {
-	status := MachineStatus{
-		Host:     host,
-		Online:   false,
-		Services: []ServiceHealth{},
+		return statusLoadedMsg{report: engine.Status(cfg, nil)}
 	}
-
-	client, err := ssh.GetClient(host, user, keyPath)
-	if err != nil {
-		return status
-	}
-	// Don't close - connection is pooled and reused
-
-	status.Online = true
-
-	// Check systemd services
-	for _, svc := range services {
-		running, statusText, _ := client.GetServiceStatus(svc)
-		if statusText == "" {
-			statusText = "unknown"
-		}
-		status.Services = append(status.Services, ServiceHealth{
-			Name:    svc,
-			Running: running,
-			Status:  statusText,
-		})
-	}
-
-	// Check docker compose if configured
-	if composeDir != "" {
-		running, _, _ := client.GetDockerComposeStatus(composeDir)
-		statusText := "stopped"
-		if running {
-			statusText = "running"
-		}
-		status.Services = append(status.Services, ServiceHealth{
-			Name:    "caddy (docker)",
-			Running: running,
-			Status:  statusText,
-		})
-	}
-
-	return status
 }
@@ -0,0 +1,128 @@
+package views
+
+import (
+	"errors"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/AhmedAburady/rcm-go/internal/config"
+	"github.com/AhmedAburady/rcm-go/internal/engine"
+	"github.com/AhmedAburady/rcm-go/internal/parser"
+	"github.com/AhmedAburady/rcm-go/internal/ssh"
+	"github.com/AhmedAburady/rcm-go/internal/tui/styles"
+)
+
+type taskStatus int
+
+const (
+	taskPending taskStatus = iota
+	taskRunning
+	taskDone
+	taskFailed
+	taskUnchanged // Skipped because nothing changed
+)
+
+// engineEventMsg wraps a progress event emitted by the engine. Views must
+// return stream.next() after handling it to keep receiving events.
+type engineEventMsg struct {
+	event  engine.Event
+	stream engineStream
+}
+
+// engineStream delivers engine events to a view, followed by the final
+// result message of the operation
+type engineStream chan tea.Msg
+
+// startEngine runs an engine operation in the background. Every event it
+// emits is delivered as an engineEventMsg, followed by the message returned
+// by run.
+func startEngine(run func(events chan<- engine.Event) tea.Msg) tea.Cmd {
+	stream := make(engineStream, 16)
+
+	go func() {
+		events := make(chan engine.Event)
+		done := make(chan tea.Msg, 1)
+
+		go func() {
+			done <- run(events)
+			close(events)
+		}()
+
+		for ev := range events {
+			stream <- engineEventMsg{event: ev, stream: stream}
+		}
+		stream <- <-done
+		close(stream)
+	}()
+
+	return stream.next()
+}
+
+// next returns a command that waits for the next message from the stream
+func (s engineStream) next() tea.Cmd {
+	return func() tea.Msg {
+		msg, ok := <-s
+		if !ok {
+			return nil
+		}
+		return msg
+	}
+}
+
+// toTaskStatus converts an engine status to the view's task status
+func toTaskStatus(s engine.TaskStatus) taskStatus {
+	switch s {
+	case engine.StatusRunning:
+		return taskRunning
+	case engine.StatusDone:
+		return taskDone
+	case engine.StatusFailed:
+		return taskFailed
+	case engine.StatusUnchanged:
+		return taskUnchanged
+	}
+	return taskPending
+}
+
+// friendlyError returns the display message of an engine error
+func friendlyError(err error, fallback string) string {
+	var e *engine.Error
+	if errors.As(err, &e) {
+		return e.Friendly
+	}
+	return fallback
+}
+
+// validatesCaddyfile reports whether sync checks the Caddyfile with caddy
+// before deploying it
+func validatesCaddyfile(cfg *config.Config) bool {
+	return cfg.Server.Caddyfile != "" && cfg.Server.CaddyValidate != config.CaddyValidateOff
+}
+
+// detailsHint lists the problems behind a Caddyfile rejected by caddy or
+// conflicting VPS ports
+func detailsHint(err error) string {
+	var messages []string
+	var invalid *ssh.CaddyfileError
+	var ports *engine.PortError
+	var syntax *parser.SyntaxError
+	var failed *engine.Error
+	switch {
+	case errors.As(err, &invalid):
+		messages = invalid.Messages
+	case errors.As(err, &ports):
+		messages = ports.Conflicts
+	case errors.As(err, &syntax) && errors.As(err, &failed):
+		// One line per problem, with its position
+		messages = strings.Split(failed.Err.Error(), "\n")
+	default:
+		return ""
+	}
+
+	var lines []string
+	for _, msg := range messages {
+		lines = append(lines, styles.Dimmed.Render("  "+msg))
+	}
+	return "\n" + strings.Join(lines, "\n")
+}
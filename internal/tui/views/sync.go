@@ -2,8 +2,7 @@ package views
 
 import (
 	"fmt"
-	"os"
-	"sort"
+	"path/filepath"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/spinner"
@@ -12,42 +11,22 @@ import (
 	"github.com/charmbracelet/lipgloss/table"
 
 	"github.com/AhmedAburady/rcm-go/internal/config"
-	"github.com/AhmedAburady/rcm-go/internal/generator"
+	"github.com/AhmedAburady/rcm-go/internal/engine"
 	"github.com/AhmedAburady/rcm-go/internal/parser"
-	"github.com/AhmedAburady/rcm-go/internal/ssh"
+	"github.com/AhmedAburady/rcm-go/internal/tui/components"
 	"github.com/AhmedAburady/rcm-go/internal/tui/styles"
 )
 
 type syncStep int
 
 const (
-	stepParsing syncStep = iota
-	stepGenerating
-	stepUploading  // Concurrent: server + client
-	stepRestarting // Concurrent: server + client
+	stepPlanning  syncStep = iota // Parse + generate
+	stepReview                    // Lint found problems, waiting for the user
+	stepDeploying                 // Upload + restart
 	stepComplete
 	stepFailed
 )
 
-type taskStatus int
-
-const (
-	taskPending taskStatus = iota
-	taskRunning
-	taskDone
-	taskFailed
-)
-
-// SyncServiceRow represents a service with local/remote status
-type SyncServiceRow struct {
-	Name      string
-	LocalAddr string
-	VPSPort   int
-	Domain    string
-	IsLocal   bool
-	IsRemote  bool
-}
-
 // SyncModel is the Bubbletea model for the sync view
 type SyncModel struct {
 	config      *config.Config
@@ -56,57 +35,52 @@ type SyncModel struct {
 	err         error
 	errFriendly string
 	dryRun      bool
+	force       bool // Upload and restart even if nothing changed
 	width       int
 	height      int
 
 	// Task status tracking
 	parseStatus         taskStatus
 	generateStatus      taskStatus
+	validateStatus      taskStatus
 	uploadServerStatus  taskStatus
 	uploadClientStatus  taskStatus
 	restartServerStatus taskStatus
 	restartClientStatus taskStatus
 	restartCaddyStatus  taskStatus
 
-	// Data passed between steps
-	services    []parser.Service
-	serviceRows []SyncServiceRow
-	serverTOML  string
-	clientTOML  string
-}
+	// Result of the planning and deploy steps
+	plan   *engine.SyncPlan
+	result *engine.SyncResult
 
-type stepCompleteMsg struct {
-	step        syncStep
-	services    []parser.Service
-	serviceRows []SyncServiceRow
-	serverTOML  string
-	clientTOML  string
+	// Dry run file diffs
+	diffs       []engine.FileDiff
+	diffErr     error
+	diffLoading bool
+	showDiff    bool
+	diffOffset  int
 }
 
-type syncErrMsg struct {
-	stepName string
-	err      error
-	friendly string // User-friendly error message
+// diffPaneHeight is how many diff lines the dry run pane shows at once
+const diffPaneHeight = 20
+
+// maxLintLines is how many lint problems a view lists before pointing at
+// rcm lint for the rest
+const maxLintLines = 8
+
+type syncPlannedMsg struct {
+	plan *engine.SyncPlan
+	err  error
 }
 
-type uploadResultMsg struct {
-	serverOK     bool
-	serverFailed bool
-	clientOK     bool
-	clientFailed bool
-	err          error
-	friendly     string
+type syncDoneMsg struct {
+	result *engine.SyncResult
+	err    error
 }
 
-type restartResultMsg struct {
-	serverOK     bool
-	serverFailed bool
-	clientOK     bool
-	clientFailed bool
-	caddyOK      bool
-	caddyFailed  bool
-	err          error
-	friendly     string
+type syncDiffMsg struct {
+	diffs []engine.FileDiff
+	err   error
 }
 
 // NewSyncModel creates a new sync view model
@@ -117,7 +91,7 @@ func NewSyncModel(cfg *config.Config, dryRun bool) SyncModel {
 
 	return SyncModel{
 		config:      cfg,
-		step:        stepParsing,
+		step:        stepPlanning,
 		spinner:     s,
 		dryRun:      dryRun,
 		width:       80,
@@ -130,7 +104,7 @@ func NewSyncModel(cfg *config.Config, dryRun bool) SyncModel {
 func (m SyncModel) Init() tea.Cmd {
 	return tea.Batch(
 		m.spinner.Tick,
-		m.runStep(stepParsing),
+		m.startPlan(),
 	)
 }
 
@@ -144,15 +118,34 @@ func (m SyncModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "ctrl+c":
 			return m, tea.Quit
 		case "q", "esc":
+			if m.showDiff {
+				m.showDiff = false
+				return m, nil
+			}
 			return m, func() tea.Msg { return GoBackMsg{} }
+		case "d":
+			if m.dryRun && m.step == stepComplete {
+				m.showDiff = !m.showDiff
+				m.diffOffset = 0
+			}
+		case "up", "k":
+			if m.showDiff && m.diffOffset > 0 {
+				m.diffOffset--
+			}
+		case "down", "j":
+			if m.showDiff && m.diffOffset < len(m.diffLines())-diffPaneHeight {
+				m.diffOffset++
+			}
 		case "enter", "s":
 			// Start actual sync from dry run preview
 			if m.dryRun && m.step == stepComplete {
 				m.dryRun = false
-				m.step = stepUploading
-				m.uploadServerStatus = taskRunning
-				m.uploadClientStatus = taskRunning
-				return m, tea.Batch(m.spinner.Tick, m.runStep(stepUploading))
+				m.showDiff = false
+				return m, tea.Batch(m.spinner.Tick, m.startDeploy())
+			}
+			// Sync despite the lint problems
+			if m.step == stepReview {
+				return m, tea.Batch(m.spinner.Tick, m.startDeploy())
 			}
 		}
 
@@ -160,111 +153,50 @@ func (m SyncModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.width = msg.Width
 		m.height = msg.Height
 
-	case stepCompleteMsg:
-		// Store data from message
-		if msg.services != nil {
-			m.services = msg.services
-		}
-		if msg.serviceRows != nil {
-			m.serviceRows = msg.serviceRows
-		}
-		if msg.serverTOML != "" {
-			m.serverTOML = msg.serverTOML
-		}
-		if msg.clientTOML != "" {
-			m.clientTOML = msg.clientTOML
-		}
-
-		// Update task status based on completed step
-		switch msg.step {
-		case stepParsing:
-			m.parseStatus = taskDone
-			m.generateStatus = taskRunning
-		case stepGenerating:
-			m.generateStatus = taskDone
-		case stepUploading:
-			m.uploadServerStatus = taskDone
-			m.uploadClientStatus = taskDone
-			m.restartServerStatus = taskRunning
-			m.restartClientStatus = taskRunning
-			if m.config.Server.CaddyComposeDir != "" {
-				m.restartCaddyStatus = taskRunning
-			}
-		case stepRestarting:
-			m.restartServerStatus = taskDone
-			m.restartClientStatus = taskDone
-			if m.config.Server.CaddyComposeDir != "" {
-				m.restartCaddyStatus = taskDone
-			}
-		}
+	case engineEventMsg:
+		m.applyEvent(msg.event)
+		return m, msg.stream.next()
 
-		m.step = msg.step + 1
-
-		// For dry run, stop after generating
-		if m.dryRun && m.step > stepGenerating {
-			m.step = stepComplete
+	case syncPlannedMsg:
+		if msg.err != nil {
+			m.step = stepFailed
+			m.err = msg.err
+			m.errFriendly = friendlyError(msg.err, "Couldn't prepare sync")
 			return m, nil
 		}
 
-		if m.step < stepComplete {
-			return m, m.runStep(m.step)
-		}
-		return m, nil
-
-	case syncErrMsg:
-		m.step = stepFailed
-		m.err = msg.err
-		m.errFriendly = msg.friendly
+		m.plan = msg.plan
 
-		switch msg.stepName {
-		case "Parse":
-			m.parseStatus = taskFailed
-		case "Generate":
-			m.generateStatus = taskFailed
+		// For dry run, stop after generating and compare with what's deployed
+		if m.dryRun {
+			m.step = stepComplete
+			m.diffLoading = true
+			return m, m.startDiff()
 		}
-		return m, nil
 
-	case uploadResultMsg:
-		m.step = stepFailed
-		m.err = msg.err
-		m.errFriendly = msg.friendly
-
-		if msg.serverOK {
-			m.uploadServerStatus = taskDone
-		} else if msg.serverFailed {
-			m.uploadServerStatus = taskFailed
+		// Show lint problems first, since they usually mean a service
+		// would silently be left out
+		if len(m.plan.Lint) > 0 {
+			m.step = stepReview
+			return m, nil
 		}
+		return m, m.startDeploy()
 
-		if msg.clientOK {
-			m.uploadClientStatus = taskDone
-		} else if msg.clientFailed {
-			m.uploadClientStatus = taskFailed
-		}
+	case syncDiffMsg:
+		m.diffLoading = false
+		m.diffs = msg.diffs
+		m.diffErr = msg.err
 		return m, nil
 
-	case restartResultMsg:
-		m.step = stepFailed
-		m.err = msg.err
-		m.errFriendly = msg.friendly
-
-		// Mark based on actual results
-		if msg.serverOK {
-			m.restartServerStatus = taskDone
-		} else if msg.serverFailed {
-			m.restartServerStatus = taskFailed
-		}
-
-		if msg.clientOK {
-			m.restartClientStatus = taskDone
-		} else if msg.clientFailed {
-			m.restartClientStatus = taskFailed
-		}
-
-		if msg.caddyOK {
-			m.restartCaddyStatus = taskDone
-		} else if msg.caddyFailed {
-			m.restartCaddyStatus = taskFailed
+	case syncDoneMsg:
+		if msg.err != nil {
+			m.step = stepFailed
+			m.err = msg.err
+			m.errFriendly = friendlyError(msg.err, "Sync failed")
+			return m, nil
 		}
+		m.step = stepComplete
+		m.result = msg.result
 		return m, nil
 
 	case spinner.TickMsg:
@@ -276,13 +208,76 @@ func (m SyncModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+// applyEvent updates the task list from an engine progress event
+func (m *SyncModel) applyEvent(ev engine.Event) {
+	status := toTaskStatus(ev.Status)
+
+	switch ev.Step {
+	case engine.StepParse:
+		m.parseStatus = status
+	case engine.StepGenerate:
+		m.generateStatus = status
+	case engine.StepValidate:
+		m.validateStatus = status
+	case engine.StepUpload:
+		switch ev.Target {
+		case engine.TargetServer:
+			m.uploadServerStatus = status
+		case engine.TargetClient:
+			m.uploadClientStatus = status
+		}
+	case engine.StepRestart:
+		switch ev.Target {
+		case engine.TargetServer:
+			m.restartServerStatus = status
+		case engine.TargetClient:
+			m.restartClientStatus = status
+		case engine.TargetCaddy:
+			m.restartCaddyStatus = status
+		}
+	}
+}
+
+// startPlan parses the Caddyfile and generates configs in the background
+func (m SyncModel) startPlan() tea.Cmd {
+	cfg := m.config
+	return startEngine(func(events chan<- engine.Event) tea.Msg {
+		plan, err := engine.Plan(cfg, events)
+		return syncPlannedMsg{plan: plan, err: err}
+	})
+}
+
+// startDiff compares the generated configs with the deployed ones
+func (m SyncModel) startDiff() tea.Cmd {
+	cfg, plan := m.config, m.plan
+	return startEngine(func(events chan<- engine.Event) tea.Msg {
+		diffs, err := engine.Diff(cfg, plan, events)
+		return syncDiffMsg{diffs: diffs, err: err}
+	})
+}
+
+// startDeploy uploads the changed configs and restarts the affected
+// services in the background
+func (m *SyncModel) startDeploy() tea.Cmd {
+	m.step = stepDeploying
+	cfg, plan, opts := m.config, m.plan, engine.SyncOptions{Force: m.force}
+	return startEngine(func(events chan<- engine.Event) tea.Msg {
+		result, err := engine.Sync(cfg, plan, opts, events)
+		return syncDoneMsg{result: result, err: err}
+	})
+}
+
 // View renders the UI
 func (m SyncModel) View() string {
 	var content string
 
-	if m.dryRun && m.step >= stepComplete {
+	if m.dryRun && m.step >= stepComplete && m.showDiff {
+		content = m.renderDiffPane()
+	} else if m.dryRun && m.step >= stepComplete {
 		// Dry run complete - show preview
 		content = m.renderDryRunView()
+	} else if m.step == stepReview {
+		content = m.renderLintReview()
 	} else {
 		// Show sync progress in a box
 		content = m.renderSyncBox()
@@ -312,6 +307,9 @@ func (m SyncModel) renderSyncBox() string {
 	lines = append(lines, m.renderTask("Generate configs", m.generateStatus))
 	lines = append(lines, "")
 	lines = append(lines, styles.Dimmed.Render("  Deploy"))
+	if validatesCaddyfile(m.config) {
+		lines = append(lines, m.renderTask("  Validate Caddyfile", m.validateStatus))
+	}
 	lines = append(lines, m.renderTask("  Upload server", m.uploadServerStatus))
 	lines = append(lines, m.renderTask("  Upload client", m.uploadClientStatus))
 	lines = append(lines, "")
@@ -331,7 +329,11 @@ func (m SyncModel) renderSyncBox() string {
 	// Success message
 	if m.step == stepComplete {
 		lines = append(lines, "")
-		lines = append(lines, styles.Success.Render(fmt.Sprintf("  ✓ Deployed %d services", len(m.services))))
+		if m.result != nil && !m.result.Changes.Any() {
+			lines = append(lines, styles.Success.Render("  ✓ Already up to date, nothing to deploy"))
+		} else {
+			lines = append(lines, styles.Success.Render(fmt.Sprintf("  ✓ Deployed %d services", len(m.plan.Services))))
+		}
 	}
 
 	// Help
@@ -365,6 +367,9 @@ func (m SyncModel) renderTask(name string, status taskStatus) string {
 	case taskFailed:
 		icon = styles.CrossMark()
 		text = styles.Error.Render(name)
+	case taskUnchanged:
+		icon = styles.Dimmed.Render("–")
+		text = styles.Dimmed.Render(name + " (unchanged)")
 	default: // taskPending
 		icon = styles.Dimmed.Render("○")
 		text = styles.Dimmed.Render(name)
@@ -385,6 +390,12 @@ func (m SyncModel) renderErrorBox() string {
 	} else if strings.Contains(errStr, "could not be found") || strings.Contains(errStr, "not found") {
 		hint = "\n" + styles.Dimmed.Render("  Hint: Service may not be installed")
 	}
+	if keyHint := hostKeyHint(m.err); keyHint != "" {
+		hint = keyHint
+	}
+	if details := detailsHint(m.err); details != "" {
+		hint = details
+	}
 
 	return friendlyMsg + hint
 }
@@ -403,7 +414,7 @@ func (m SyncModel) renderDryRunView() string {
 	// Summary
 	newCount := 0
 	updateCount := 0
-	for _, svc := range m.serviceRows {
+	for _, svc := range m.plan.Rows {
 		if svc.IsLocal && !svc.IsRemote {
 			newCount++
 		} else if svc.IsLocal && svc.IsRemote {
@@ -420,10 +431,22 @@ func (m SyncModel) renderDryRunView() string {
 	if updateCount > 0 {
 		lines = append(lines, fmt.Sprintf("  %s %d update", yellow.Render("●"), updateCount))
 	}
+	if len(m.plan.Removed) > 0 {
+		lines = append(lines, fmt.Sprintf("  %s %d removed (%s)",
+			styles.StatusError.Render("●"), len(m.plan.Removed), strings.Join(m.plan.Removed, ", ")))
+	}
+
+	if len(m.plan.Lint) > 0 {
+		lines = append(lines, "")
+		lines = append(lines, m.renderLint()...)
+	}
+
+	lines = append(lines, "")
+	lines = append(lines, m.renderFileChanges()...)
 
 	lines = append(lines, "")
 	lines = append(lines, styles.Dimmed.Render(fmt.Sprintf("  Server: %s", m.config.Server.Host)))
-	lines = append(lines, styles.Dimmed.Render(fmt.Sprintf("  Client: %s", m.config.Client.Host)))
+	lines = append(lines, styles.Dimmed.Render(fmt.Sprintf("  Client: %s", m.config.ClientHosts())))
 	lines = append(lines, "")
 
 	// Sync button - one full button
@@ -440,7 +463,7 @@ func (m SyncModel) renderDryRunView() string {
 	}
 	lines = append(lines, btnLine)
 	lines = append(lines, "")
-	escText := styles.Dimmed.Render("ESC cancel")
+	escText := styles.Dimmed.Render("d show diff  ESC cancel")
 	escPadding := (60 - lipgloss.Width(escText)) / 2
 	if escPadding > 0 {
 		escText = strings.Repeat(" ", escPadding) + escText
@@ -458,9 +481,124 @@ func (m SyncModel) renderDryRunView() string {
 	return box.Render(content)
 }
 
+// renderLintReview asks whether to sync despite the lint problems
+func (m SyncModel) renderLintReview() string {
+	var lines []string
+
+	lines = append(lines, styles.WindowTitle.Render("Caddyfile Problems"))
+	lines = append(lines, "")
+	lines = append(lines, m.renderLint()...)
+	lines = append(lines, "")
+	lines = append(lines, styles.Dimmed.Render("  Services with errors are left out of the tunnels."))
+	lines = append(lines, "")
+	lines = append(lines, styles.Dimmed.Render("Enter sync anyway  ESC cancel"))
+
+	box := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(styles.Border).
+		Padding(1, 3).
+		Width(100)
+
+	return box.Render(strings.Join(lines, "\n"))
+}
+
+// renderLint lists the plan's lint problems, with their position relative
+// to the local Caddyfile's directory
+func (m SyncModel) renderLint() []string {
+	dir := filepath.Dir(m.config.Paths.Caddyfile)
+
+	var lines []string
+	for i, d := range m.plan.Lint {
+		if i == maxLintLines {
+			lines = append(lines, styles.Dimmed.Render(fmt.Sprintf("  ... and %d more, run rcm lint to see them all", len(m.plan.Lint)-i)))
+			break
+		}
+		pos := d.Pos
+		if rel, err := filepath.Rel(dir, pos.File); err == nil {
+			pos.File = rel
+		}
+		icon := styles.WarningText.Render("!")
+		if d.Severity == parser.SeverityError {
+			icon = styles.CrossMark()
+		}
+		lines = append(lines, fmt.Sprintf("  %s %s %s", icon, styles.Dimmed.Render(pos.String()), d.Message))
+	}
+	return lines
+}
+
+// renderFileChanges lists which deployed files the sync would change
+func (m SyncModel) renderFileChanges() []string {
+	if m.diffLoading {
+		return []string{fmt.Sprintf("  %s Comparing with deployed files...", m.spinner.View())}
+	}
+	if m.diffErr != nil {
+		return []string{styles.WarningText.Render("  Couldn't compare with deployed files: ") +
+			styles.Dimmed.Render(friendlyError(m.diffErr, m.diffErr.Error()))}
+	}
+
+	var lines []string
+	for _, d := range m.diffs {
+		state := styles.Dimmed.Render("unchanged")
+		switch {
+		case d.Missing:
+			state = styles.StatusOK.Render("new")
+		case d.Changed():
+			state = styles.StatusPending.Render("changed")
+		}
+		lines = append(lines, fmt.Sprintf("  %-12s %-8s %s", d.Name, d.Target, state))
+	}
+	return lines
+}
+
+// diffLines returns the coloured lines of every changed file's diff
+func (m SyncModel) diffLines() []string {
+	var lines []string
+	for _, d := range m.diffs {
+		if !d.Changed() {
+			continue
+		}
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, components.DiffLines(d.Unified)...)
+	}
+	return lines
+}
+
+func (m SyncModel) renderDiffPane() string {
+	var lines []string
+
+	lines = append(lines, styles.WindowTitle.Render("File Changes"))
+	lines = append(lines, "")
+
+	diff := m.diffLines()
+	switch {
+	case m.diffLoading:
+		lines = append(lines, fmt.Sprintf("  %s Comparing with deployed files...", m.spinner.View()))
+	case m.diffErr != nil:
+		lines = append(lines, styles.Error.Render("  "+friendlyError(m.diffErr, "Couldn't compare with deployed files")))
+	case len(diff) == 0:
+		lines = append(lines, styles.Dimmed.Render("  Deployed configs already match local."))
+	default:
+		end := min(m.diffOffset+diffPaneHeight, len(diff))
+		lines = append(lines, diff[m.diffOffset:end]...)
+	}
+
+	lines = append(lines, "")
+	lines = append(lines, styles.Dimmed.Render("↑/↓: scroll  d: close diff  Enter: sync now"))
+
+	box := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(styles.Border).
+		Padding(1, 3).
+		Width(100)
+
+	return box.Render(strings.Join(lines, "\n"))
+}
+
 func (m SyncModel) renderSyncTable() string {
-	rows := make([][]string, len(m.serviceRows))
-	for i, svc := range m.serviceRows {
+	rows := make([][]string, len(m.plan.Rows))
+	for i, svc := range m.plan.Rows {
 		localStatus := styles.CrossMark()
 		if svc.IsLocal {
 			localStatus = styles.CheckMark()
@@ -474,6 +612,7 @@ func (m SyncModel) renderSyncTable() string {
 			svc.Name,
 			svc.LocalAddr,
 			fmt.Sprintf("%d", svc.VPSPort),
+			svc.Protocol,
 			localStatus,
 			remoteStatus,
 		}
@@ -482,7 +621,7 @@ func (m SyncModel) renderSyncTable() string {
 	t := table.New().
 		Border(lipgloss.RoundedBorder()).
 		BorderStyle(lipgloss.NewStyle().Foreground(styles.Border)).
-		Headers("Service", "Local Address", "Port", "Local", "Remote").
+		Headers("Service", "Local Address", "Port", "Proto", "Local", "Remote").
 		Rows(rows...).
 		StyleFunc(func(row, col int) lipgloss.Style {
 			base := lipgloss.NewStyle().Padding(0, 1)
@@ -498,7 +637,7 @@ func (m SyncModel) renderSyncTable() string {
 				return base.Foreground(lipgloss.Color("#00ff87"))
 			case 2:
 				return base.Foreground(lipgloss.Color("#ffff00"))
-			case 3, 4:
+			case 4, 5:
 				return base.Align(lipgloss.Center)
 			}
 			return base.Foreground(lipgloss.Color("#cccccc"))
@@ -506,335 +645,3 @@ func (m SyncModel) renderSyncTable() string {
 
 	return t.String()
 }
-
-// expandTilde expands ~ to home directory
-func expandTilde(path string) string {
-	if len(path) > 0 && path[0] == '~' {
-		home, _ := os.UserHomeDir()
-		return home + path[1:]
-	}
-	return path
-}
-
-// runStep executes the current sync step
-func (m *SyncModel) runStep(step syncStep) tea.Cmd {
-	return func() tea.Msg {
-		switch step {
-		case stepParsing:
-			m.parseStatus = taskRunning
-
-			// Fetch local and remote concurrently
-			type parseResult struct {
-				services map[string]parser.Service
-				isLocal  bool
-				err      error
-			}
-
-			resultCh := make(chan parseResult, 2)
-
-			// Local
-			go func() {
-				localServices := make(map[string]parser.Service)
-				services, err := parser.ParseFile(m.config.Paths.Caddyfile)
-				if err == nil {
-					for _, svc := range services {
-						localServices[svc.Name] = svc
-					}
-				}
-				resultCh <- parseResult{services: localServices, isLocal: true, err: err}
-			}()
-
-			// Remote
-			go func() {
-				remoteServices := make(map[string]parser.Service)
-				if m.config.Server.Host != "" && m.config.Server.Caddyfile != "" {
-					client, err := ssh.GetClient(m.config.Server.Host, m.config.Server.User, m.config.Server.SSHKey)
-					if err == nil {
-						// Don't close - connection is pooled and reused
-						content, err := client.DownloadFile(m.config.Server.Caddyfile)
-						if err == nil {
-							services, _ := parser.ParseContent(content)
-							for _, svc := range services {
-								remoteServices[svc.Name] = svc
-							}
-						}
-					}
-				}
-				resultCh <- parseResult{services: remoteServices, isLocal: false}
-			}()
-
-			// Collect results
-			var localServices, remoteServices map[string]parser.Service
-			var localErr error
-			for i := 0; i < 2; i++ {
-				result := <-resultCh
-				if result.isLocal {
-					localServices = result.services
-					localErr = result.err
-				} else {
-					remoteServices = result.services
-				}
-			}
-
-			if localErr != nil {
-				return syncErrMsg{stepName: "Parse", err: localErr, friendly: "Couldn't parse local Caddyfile"}
-			}
-
-			// Build service rows
-			var serviceRows []SyncServiceRow
-			var services []parser.Service
-			for name, svc := range localServices {
-				_, isRemote := remoteServices[name]
-				domain := ""
-				if len(svc.Domains) > 0 {
-					domain = svc.Domains[0]
-				}
-				serviceRows = append(serviceRows, SyncServiceRow{
-					Name:      svc.Name,
-					LocalAddr: svc.LocalAddr,
-					VPSPort:   svc.VPSPort,
-					Domain:    domain,
-					IsLocal:   true,
-					IsRemote:  isRemote,
-				})
-				services = append(services, svc)
-			}
-
-			sort.Slice(serviceRows, func(i, j int) bool {
-				return serviceRows[i].Name < serviceRows[j].Name
-			})
-
-			return stepCompleteMsg{step: step, services: services, serviceRows: serviceRows}
-
-		case stepGenerating:
-			serverTOML, err := generator.GenerateServerTOML(m.config, m.services)
-			if err != nil {
-				return syncErrMsg{stepName: "Generate", err: err, friendly: "Couldn't generate server config"}
-			}
-
-			clientTOML, err := generator.GenerateClientTOML(m.config, m.services)
-			if err != nil {
-				return syncErrMsg{stepName: "Generate", err: err, friendly: "Couldn't generate client config"}
-			}
-			return stepCompleteMsg{step: step, serverTOML: serverTOML, clientTOML: clientTOML}
-
-		case stepUploading:
-			// Upload to server AND client concurrently
-			type taskResult struct {
-				name     string
-				err      error
-				friendly string
-			}
-			resultCh := make(chan taskResult, 2)
-
-			// Upload to server (rathole config + Caddyfile)
-			go func() {
-				client, err := ssh.GetClient(m.config.Server.Host, m.config.Server.User, m.config.Server.SSHKey)
-				if err != nil {
-					resultCh <- taskResult{name: "server", err: err, friendly: fmt.Sprintf("Couldn't connect to server (%s)", m.config.Server.Host)}
-					return
-				}
-				// Don't close - connection is pooled and reused
-
-				// Upload rathole server config
-				if err := client.UploadContent(m.serverTOML, m.config.Server.RatholeConfig); err != nil {
-					resultCh <- taskResult{name: "server", err: err, friendly: "Couldn't upload rathole config to server"}
-					return
-				}
-
-				// Upload Caddyfile to server
-				if m.config.Server.Caddyfile != "" {
-					localCaddyPath := expandTilde(m.config.Paths.Caddyfile)
-					caddyContent, err := os.ReadFile(localCaddyPath)
-					if err != nil {
-						resultCh <- taskResult{name: "server", err: err, friendly: "Couldn't read local Caddyfile"}
-						return
-					}
-					if err := client.UploadContent(string(caddyContent), m.config.Server.Caddyfile); err != nil {
-						resultCh <- taskResult{name: "server", err: err, friendly: "Couldn't upload Caddyfile to server"}
-						return
-					}
-				}
-
-				resultCh <- taskResult{name: "server", err: nil}
-			}()
-
-			// Upload to client
-			go func() {
-				client, err := ssh.GetClient(m.config.Client.Host, m.config.Client.User, m.config.Client.SSHKey)
-				if err != nil {
-					resultCh <- taskResult{name: "client", err: err, friendly: fmt.Sprintf("Couldn't connect to client (%s)", m.config.Client.Host)}
-					return
-				}
-				// Don't close - connection is pooled and reused
-
-				if err := client.UploadContent(m.clientTOML, m.config.Client.RatholeConfig); err != nil {
-					resultCh <- taskResult{name: "client", err: err, friendly: "Couldn't upload config to client"}
-					return
-				}
-				resultCh <- taskResult{name: "client", err: nil}
-			}()
-
-			// Collect both results
-			var firstErr *taskResult
-			serverOK, serverFailed := false, false
-			clientOK, clientFailed := false, false
-
-			for i := 0; i < 2; i++ {
-				r := <-resultCh
-				if r.name == "server" {
-					if r.err == nil {
-						serverOK = true
-					} else {
-						serverFailed = true
-						if firstErr == nil {
-							firstErr = &r
-						}
-					}
-				} else {
-					if r.err == nil {
-						clientOK = true
-					} else {
-						clientFailed = true
-						if firstErr == nil {
-							firstErr = &r
-						}
-					}
-				}
-			}
-
-			if firstErr != nil {
-				return uploadResultMsg{
-					serverOK:     serverOK,
-					serverFailed: serverFailed,
-					clientOK:     clientOK,
-					clientFailed: clientFailed,
-					err:          firstErr.err,
-					friendly:     firstErr.friendly,
-				}
-			}
-
-			return stepCompleteMsg{step: step}
-
-		case stepRestarting:
-			// Restart server, client, and caddy concurrently
-			type taskResult struct {
-				name     string
-				err      error
-				friendly string
-			}
-
-			taskCount := 2
-			if m.config.Server.CaddyComposeDir != "" {
-				taskCount = 3
-			}
-			resultCh := make(chan taskResult, taskCount)
-
-			// Restart rathole-server
-			go func() {
-				client, err := ssh.GetClient(m.config.Server.Host, m.config.Server.User, m.config.Server.SSHKey)
-				if err != nil {
-					resultCh <- taskResult{name: "server", err: err, friendly: fmt.Sprintf("Couldn't connect to server (%s)", m.config.Server.Host)}
-					return
-				}
-				// Don't close - connection is pooled and reused
-
-				if err := client.RestartService("rathole-server"); err != nil {
-					resultCh <- taskResult{name: "server", err: err, friendly: "Couldn't restart rathole on server"}
-					return
-				}
-				resultCh <- taskResult{name: "server", err: nil}
-			}()
-
-			// Restart rathole-client
-			go func() {
-				client, err := ssh.GetClient(m.config.Client.Host, m.config.Client.User, m.config.Client.SSHKey)
-				if err != nil {
-					resultCh <- taskResult{name: "client", err: err, friendly: fmt.Sprintf("Couldn't connect to client (%s)", m.config.Client.Host)}
-					return
-				}
-				// Don't close - connection is pooled and reused
-
-				if err := client.RestartService("rathole-client"); err != nil {
-					resultCh <- taskResult{name: "client", err: err, friendly: "Couldn't restart rathole on client"}
-					return
-				}
-				resultCh <- taskResult{name: "client", err: nil}
-			}()
-
-			// Restart Caddy (if configured)
-			if m.config.Server.CaddyComposeDir != "" {
-				go func() {
-					client, err := ssh.GetClient(m.config.Server.Host, m.config.Server.User, m.config.Server.SSHKey)
-					if err != nil {
-						resultCh <- taskResult{name: "caddy", err: err, friendly: fmt.Sprintf("Couldn't connect to server (%s)", m.config.Server.Host)}
-						return
-					}
-					// Don't close - connection is pooled and reused
-
-					if err := client.RestartDockerCompose(m.config.Server.CaddyComposeDir); err != nil {
-						resultCh <- taskResult{name: "caddy", err: err, friendly: "Couldn't restart Caddy"}
-						return
-					}
-					resultCh <- taskResult{name: "caddy", err: nil}
-				}()
-			}
-
-			// Collect all results
-			var firstErr *taskResult
-			serverOK, serverFailed := false, false
-			clientOK, clientFailed := false, false
-			caddyOK, caddyFailed := false, false
-
-			for i := 0; i < taskCount; i++ {
-				r := <-resultCh
-				switch r.name {
-				case "server":
-					if r.err == nil {
-						serverOK = true
-					} else {
-						serverFailed = true
-						if firstErr == nil {
-							firstErr = &r
-						}
-					}
-				case "client":
-					if r.err == nil {
-						clientOK = true
-					} else {
-						clientFailed = true
-						if firstErr == nil {
-							firstErr = &r
-						}
-					}
-				case "caddy":
-					if r.err == nil {
-						caddyOK = true
-					} else {
-						caddyFailed = true
-						if firstErr == nil {
-							firstErr = &r
-						}
-					}
-				}
-			}
-
-			if firstErr != nil {
-				return restartResultMsg{
-					serverOK:     serverOK,
-					serverFailed: serverFailed,
-					clientOK:     clientOK,
-					clientFailed: clientFailed,
-					caddyOK:      caddyOK,
-					caddyFailed:  caddyFailed,
-					err:          firstErr.err,
-					friendly:     firstErr.friendly,
-				}
-			}
-
-			return stepCompleteMsg{step: step}
-		}
-
-		return stepCompleteMsg{step: step}
-	}
-}